@@ -0,0 +1,442 @@
+package heapdump
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	apiDefaultLimit = 100
+	apiMaxLimit     = 10000
+)
+
+// apiError is the error with the HTTP status code.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, v ...interface{}) error {
+	return &apiError{http.StatusBadRequest, fmt.Sprintf(format, v...)}
+}
+
+func notFound(format string, v ...interface{}) error {
+	return &apiError{http.StatusNotFound, fmt.Sprintf(format, v...)}
+}
+
+// apiPage is a page of the list. All the lists in the API are paginated by the `offset` and `limit` query parameters.
+type apiPage struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Items  interface{} `json:"items"`
+}
+
+// apiPaging parses the `offset` and `limit` query parameters, and returns the range of the items in the page.
+func apiPaging(r *http.Request, total int) (*apiPage, int, int, error) {
+	page := &apiPage{Total: total, Limit: apiDefaultLimit}
+	q := r.URL.Query()
+	if s := q.Get("offset"); s != "" {
+		offset, err := strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return nil, 0, 0, badRequest("invalid offset: %v", s)
+		}
+		page.Offset = offset
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > apiMaxLimit {
+			return nil, 0, 0, badRequest("invalid limit: %v", s)
+		}
+		page.Limit = limit
+	}
+
+	start := page.Offset
+	if start > total {
+		start = total
+	}
+	end := start + page.Limit
+	if end > total {
+		end = total
+	}
+	return page, start, end, nil
+}
+
+// apiSortKey is a key of the `sort` query parameter.
+type apiSortKey struct {
+	name string
+	// less is the ascending order by the key.
+	less func(i, j int) bool
+	// descending is the default order, used if the `order` query parameter isn't given.
+	descending bool
+}
+
+// apiSort sorts the items by the `sort` and `order`(asc or desc) query parameters. The first key is the default.
+func apiSort(r *http.Request, items interface{}, keys []*apiSortKey) error {
+	q := r.URL.Query()
+	key := keys[0]
+	if name := q.Get("sort"); name != "" {
+		key = nil
+		for _, k := range keys {
+			if k.name == name {
+				key = k
+			}
+		}
+		if key == nil {
+			return badRequest("unknown sort key: %v", name)
+		}
+	}
+
+	descending := key.descending
+	switch order := q.Get("order"); order {
+	case "":
+	case "asc":
+		descending = false
+	case "desc":
+		descending = true
+	default:
+		return badRequest("invalid order: %v", order)
+	}
+
+	if descending {
+		sort.SliceStable(items, func(i, j int) bool {
+			return key.less(j, i)
+		})
+	} else {
+		sort.SliceStable(items, key.less)
+	}
+	return nil
+}
+
+func parseObjectIdSegment(s string) (uint64, error) {
+	objectId, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, badRequest("invalid object ID: %v", s)
+	}
+	return objectId, nil
+}
+
+// handleAPI serves the JSON API. The path is the one without the "/api" prefix.
+//
+//	GET /classes                         the class histogram. `pattern` filters the classes by ClassPattern.
+//	GET /classes/{id}/instances          the instances of the class.
+//	GET /objects/{id}                    the object. The elements of the arrays are paginated.
+//	GET /objects/{id}/referrers          the incoming references to the object.
+//	GET /objects/{id}/path-to-root       the shortest path from the GC roots. It's empty if the object is unreachable.
+//	GET /dominators/{id}/children        the objects immediately dominated by the object. Use 0 for the GC roots.
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	var result interface{}
+	var err error
+	if r.Method != http.MethodGet {
+		err = &apiError{http.StatusMethodNotAllowed, "method not allowed"}
+	} else {
+		result, err = s.routeAPI(r)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err != nil {
+		status := http.StatusInternalServerError
+		if e, ok := err.(*apiError); ok {
+			status = e.status
+		} else {
+			s.logger.Error("%v", err)
+		}
+		w.WriteHeader(status)
+		result = map[string]string{"error": err.Error()}
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		s.logger.Warn("cannot write the response: %v", err)
+	}
+}
+
+func (s *Server) routeAPI(r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "classes":
+		return s.apiClasses(r)
+	case len(parts) == 2 && parts[0] == "objects":
+		objectId, err := parseObjectIdSegment(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return s.apiObject(r, objectId)
+	case len(parts) == 3:
+		objectId, err := parseObjectIdSegment(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		switch parts[0] + "/" + parts[2] {
+		case "classes/instances":
+			return s.apiInstances(r, objectId)
+		case "objects/referrers":
+			return s.apiReferrers(r, objectId)
+		case "objects/path-to-root":
+			return s.apiPathToRoot(r, objectId)
+		case "dominators/children":
+			return s.apiDominatorChildren(r, objectId)
+		}
+	}
+	return nil, notFound("unknown API: %v", r.URL.Path)
+}
+
+func (s *Server) apiClasses(r *http.Request) (interface{}, error) {
+	entries := []*HistogramEntry{}
+	if p := r.URL.Query().Get("pattern"); p != "" {
+		pattern, err := NewClassPattern(p)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		for _, entry := range s.histogram {
+			if pattern.Match(entry.Name) {
+				entries = append(entries, entry)
+			}
+		}
+	} else {
+		entries = append(entries, s.histogram...)
+	}
+
+	err := apiSort(r, entries, []*apiSortKey{
+		{"size", func(i, j int) bool { return entries[i].ShallowSize < entries[j].ShallowSize }, true},
+		{"count", func(i, j int) bool { return entries[i].Count < entries[j].Count }, true},
+		{"name", func(i, j int) bool { return entries[i].Name < entries[j].Name }, false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	page, start, end, err := apiPaging(r, len(entries))
+	if err != nil {
+		return nil, err
+	}
+	page.Items = entries[start:end]
+	return page, nil
+}
+
+func (s *Server) apiInstances(r *http.Request, classObjectId uint64) (interface{}, error) {
+	class, err := s.analyzer.GetClass(classObjectId)
+	if err != nil {
+		return nil, err
+	}
+	if class == nil {
+		return nil, notFound("class not found: %v", classObjectId)
+	}
+	instances, err := s.instances(classObjectId)
+	if err != nil {
+		return nil, err
+	}
+	if instances == nil {
+		instances = []*instanceView{}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ObjectId < instances[j].ObjectId
+	})
+	err = apiSort(r, instances, []*apiSortKey{
+		{"retained", func(i, j int) bool { return instances[i].RetainedSize < instances[j].RetainedSize }, true},
+		{"shallow", func(i, j int) bool { return instances[i].ShallowSize < instances[j].ShallowSize }, true},
+		{"id", func(i, j int) bool { return instances[i].ObjectId < instances[j].ObjectId }, false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	page, start, end, err := apiPaging(r, len(instances))
+	if err != nil {
+		return nil, err
+	}
+	page.Items = instances[start:end]
+	return page, nil
+}
+
+type apiField struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// ObjectId is the referred object. Only for the non-null object references.
+	ObjectId uint64 `json:"objectId,omitempty"`
+}
+
+type apiObject struct {
+	ObjectId           uint64      `json:"id"`
+	Kind               string      `json:"kind"`
+	ClassObjectId      uint64      `json:"classId,omitempty"`
+	ClassName          string      `json:"className"`
+	ShallowSize        uint64      `json:"shallowSize"`
+	Reachable          bool        `json:"reachable"`
+	RetainedSize       uint64      `json:"retainedSize"`
+	ImmediateDominator uint64      `json:"immediateDominator,omitempty"`
+	RootKinds          []string    `json:"rootKinds,omitempty"`
+	StringValue        *string     `json:"stringValue,omitempty"`
+	Fields             []*apiField `json:"fields,omitempty"`
+	Length             *int        `json:"length,omitempty"`
+	Elements           *apiPage    `json:"elements,omitempty"`
+}
+
+func newAPIField(field *Field) *apiField {
+	f := &apiField{Name: field.Name, Type: field.Type.String(), Value: field.String()}
+	if field.Type == hprofdata.HProfValueType_OBJECT {
+		f.ObjectId = field.Value
+	}
+	return f
+}
+
+func (s *Server) apiObject(r *http.Request, objectId uint64) (interface{}, error) {
+	object, err := s.analyzer.GetObject(objectId)
+	if err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return nil, notFound("object not found: %v", objectId)
+	}
+	shallowSize, err := s.analyzer.GetShallowSize(objectId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &apiObject{
+		ObjectId:      objectId,
+		Kind:          object.Kind.String(),
+		ClassObjectId: object.ClassObjectId,
+		ClassName:     object.ClassName,
+		ShallowSize:   uint64(shallowSize),
+		Reachable:     s.tree.Contains(objectId),
+		RetainedSize:  s.tree.RetainedSize(objectId),
+		RootKinds:     s.analyzer.hprof.GetRootKinds(objectId),
+	}
+	result.ImmediateDominator, _ = s.tree.ImmediateDominator(objectId)
+	for _, field := range object.Fields {
+		result.Fields = append(result.Fields, newAPIField(field))
+	}
+	if object.Kind == ObjectKind_INSTANCE && object.ClassName == javaLangString {
+		value, err := s.analyzer.GetStringValue(objectId)
+		if err != nil {
+			return nil, err
+		}
+		result.StringValue = &value
+	}
+
+	if object.Kind == ObjectKind_OBJECT_ARRAY || object.Kind == ObjectKind_PRIMITIVE_ARRAY {
+		result.Length = &object.Length
+		page, start, end, err := apiPaging(r, object.Length)
+		if err != nil {
+			return nil, err
+		}
+		elements := []*apiField{}
+		for i := start; i < end; i++ {
+			if object.Kind == ObjectKind_OBJECT_ARRAY {
+				elements = append(elements, newAPIField(&Field{
+					Name:  "[" + strconv.Itoa(i) + "]",
+					Type:  object.ElementType,
+					Value: object.ElementObjectIds[i],
+				}))
+			} else {
+				elements = append(elements, newAPIField(object.Element(i)))
+			}
+		}
+		page.Items = elements
+		result.Elements = page
+	}
+	return result, nil
+}
+
+type apiReferrer struct {
+	ObjectId  uint64 `json:"id"`
+	ClassName string `json:"className"`
+	Reference string `json:"reference"`
+}
+
+func (s *Server) apiReferrers(r *http.Request, objectId uint64) (interface{}, error) {
+	if ok, err := s.analyzer.hprof.HasObject(objectId); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, notFound("object not found: %v", objectId)
+	}
+	references, err := s.analyzer.GetIncomingReferences(s.referrers, objectId)
+	if err != nil {
+		return nil, err
+	}
+	referrers := []*apiReferrer{}
+	for _, reference := range references {
+		name, err := s.analyzer.GetObjectClassName(reference.ReferrerObjectId)
+		if err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, &apiReferrer{
+			ObjectId:  reference.ReferrerObjectId,
+			ClassName: name,
+			Reference: reference.Reference,
+		})
+	}
+
+	err = apiSort(r, referrers, []*apiSortKey{
+		{"id", func(i, j int) bool { return referrers[i].ObjectId < referrers[j].ObjectId }, false},
+		{"className", func(i, j int) bool { return referrers[i].ClassName < referrers[j].ClassName }, false},
+		{"retained", func(i, j int) bool {
+			return s.tree.RetainedSize(referrers[i].ObjectId) < s.tree.RetainedSize(referrers[j].ObjectId)
+		}, true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	page, start, end, err := apiPaging(r, len(referrers))
+	if err != nil {
+		return nil, err
+	}
+	page.Items = referrers[start:end]
+	return page, nil
+}
+
+func (s *Server) apiPathToRoot(r *http.Request, objectId uint64) (interface{}, error) {
+	if ok, err := s.analyzer.hprof.HasObject(objectId); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, notFound("object not found: %v", objectId)
+	}
+	path, err := s.analyzer.FindPathFromRoot(objectId)
+	if err != nil {
+		return nil, err
+	}
+	if path == nil {
+		path = []*PathElement{}
+	}
+	page, start, end, err := apiPaging(r, len(path))
+	if err != nil {
+		return nil, err
+	}
+	page.Items = path[start:end]
+	return page, nil
+}
+
+func (s *Server) apiDominatorChildren(r *http.Request, objectId uint64) (interface{}, error) {
+	if objectId != 0 && !s.tree.Contains(objectId) {
+		return nil, notFound("object not found in the dominator tree: %v", objectId)
+	}
+	entries, err := s.analyzer.GetDominatorEntries(s.tree, objectId, 0)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []*DominatorEntry{}
+	}
+	err = apiSort(r, entries, []*apiSortKey{
+		{"retained", func(i, j int) bool { return entries[i].RetainedSize < entries[j].RetainedSize }, true},
+		{"shallow", func(i, j int) bool { return entries[i].ShallowSize < entries[j].ShallowSize }, true},
+		{"id", func(i, j int) bool { return entries[i].ObjectId < entries[j].ObjectId }, false},
+		{"className", func(i, j int) bool { return entries[i].ClassName < entries[j].ClassName }, false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	page, start, end, err := apiPaging(r, len(entries))
+	if err != nil {
+		return nil, err
+	}
+	page.Items = entries[start:end]
+	return page, nil
+}
@@ -0,0 +1,102 @@
+package heapdump
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func (a *Tester) GetJSON(server http.Handler, url string, expectedStatus int, v interface{}) {
+	code, body := a.Get(server, url)
+	if code != expectedStatus {
+		a.t.Fatalf("%v: unexpected status %v: %v", url, code, body)
+	}
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		a.t.Fatalf("%v: invalid JSON: %v: %v", url, err, body)
+	}
+}
+
+type testPage struct {
+	Total  int
+	Offset int
+	Limit  int
+	Items  []map[string]interface{}
+}
+
+func TestAPIClasses(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+	server := tester.NewServer()
+
+	var page testPage
+	tester.GetJSON(server, "/api/classes?pattern=Object?", http.StatusOK, &page)
+	if page.Total != 2 || len(page.Items) != 2 {
+		t.Fatalf("Object1 and Object2 should match. But %v", page.Items)
+	}
+
+	tester.GetJSON(server, "/api/classes?sort=name&order=desc&limit=1", http.StatusOK, &page)
+	if page.Total <= 2 || len(page.Items) != 1 || page.Limit != 1 {
+		t.Fatalf("Unexpected page: %#v", page)
+	}
+	var all testPage
+	tester.GetJSON(server, fmt.Sprintf("/api/classes?sort=name&order=asc&offset=%d", page.Total-1), http.StatusOK, &all)
+	if len(all.Items) != 1 || all.Items[0]["name"] != page.Items[0]["name"] {
+		t.Fatalf("The last class in the ascending order should be the first in the descending order: %v, %v", all.Items, page.Items)
+	}
+
+	var e map[string]string
+	tester.GetJSON(server, "/api/classes?sort=unknown", http.StatusBadRequest, &e)
+	tester.GetJSON(server, "/api/classes?limit=0", http.StatusBadRequest, &e)
+	tester.GetJSON(server, "/api/unknown", http.StatusNotFound, &e)
+	if e["error"] == "" {
+		t.Fatalf("The error message should be returned")
+	}
+}
+
+func TestAPIObjects(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+	server := tester.NewServer()
+
+	object1 := tester.FindInstance("Object1")
+	object2 := tester.FindInstance("Object2")
+
+	var object apiObject
+	tester.GetJSON(server, fmt.Sprintf("/api/objects/%d", object1), http.StatusOK, &object)
+	if object.ClassName != "Object1" || object.RetainedSize != 66 || !object.Reachable {
+		t.Fatalf("Unexpected object: %#v", object)
+	}
+	if len(object.Fields) != 1 || object.Fields[0].Name != "o2" || object.Fields[0].ObjectId != object2 {
+		t.Fatalf("Object1.o2 should refer Object2: %#v", object.Fields)
+	}
+
+	var page testPage
+	tester.GetJSON(server, fmt.Sprintf("/api/classes/%d/instances", object.ClassObjectId), http.StatusOK, &page)
+	if page.Total != 1 || page.Items[0]["id"] != float64(object1) {
+		t.Fatalf("Unexpected instances: %#v", page)
+	}
+
+	tester.GetJSON(server, fmt.Sprintf("/api/objects/%d/referrers", object2), http.StatusOK, &page)
+	if page.Total != 1 || page.Items[0]["id"] != float64(object1) || page.Items[0]["reference"] != "o2" {
+		t.Fatalf("Unexpected referrers: %#v", page)
+	}
+
+	tester.GetJSON(server, fmt.Sprintf("/api/objects/%d/path-to-root", object2), http.StatusOK, &page)
+	if last := page.Items[len(page.Items)-1]; last["id"] != float64(object2) || last["reference"] != "o2" {
+		t.Fatalf("Unexpected path: %#v", page)
+	}
+
+	tester.GetJSON(server, fmt.Sprintf("/api/dominators/%d/children", object1), http.StatusOK, &page)
+	if page.Total != 1 || page.Items[0]["id"] != float64(object2) || page.Items[0]["retainedSize"] != float64(42) {
+		t.Fatalf("Unexpected dominator children: %#v", page)
+	}
+	tester.GetJSON(server, "/api/dominators/0/children?sort=shallow&limit=5", http.StatusOK, &page)
+	if len(page.Items) != 5 {
+		t.Fatalf("Unexpected dominator children: %#v", page)
+	}
+
+	var e map[string]string
+	tester.GetJSON(server, "/api/objects/1", http.StatusNotFound, &e)
+	tester.GetJSON(server, "/api/objects/xyz/referrers", http.StatusBadRequest, &e)
+}
@@ -0,0 +1,262 @@
+package heapdump
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CheckMetric is what the check rule measures.
+type CheckMetric int
+
+const (
+	// CheckMetric_RETAINED is the retained size of the reachable instances of the classes. See DominatorTree.WalkDominated.
+	CheckMetric_RETAINED CheckMetric = iota
+	// CheckMetric_SHALLOW is the total shallow size of the reachable instances of the classes.
+	CheckMetric_SHALLOW
+	// CheckMetric_COUNT is the number of the reachable instances of the classes.
+	CheckMetric_COUNT
+	// CheckMetric_DUPLICATE_STRINGS is the size wasted by the duplicated strings. See DuplicateStrings.
+	CheckMetric_DUPLICATE_STRINGS
+	// CheckMetric_HEAP is the size of the reachable heap.
+	CheckMetric_HEAP
+)
+
+var checkMetricNames = []string{"retained", "shallow", "count", "duplicate-strings", "heap"}
+
+func (m CheckMetric) String() string {
+	return checkMetricNames[m]
+}
+
+// hasPattern returns true if the metric is measured for the classes matching the pattern.
+func (m CheckMetric) hasPattern() bool {
+	return m == CheckMetric_RETAINED || m == CheckMetric_SHALLOW || m == CheckMetric_COUNT
+}
+
+// CheckRule is a rule of the memory budget, e.g. "retained com.example.Cache < 200MB".
+type CheckRule struct {
+	Line int
+	// Text is the rule as written in the rules file.
+	Text   string
+	Metric CheckMetric
+	// Pattern is nil for the metrics of the whole heap.
+	Pattern *ClassPattern
+	// Operator is one of "<", "<=", ">" and ">=".
+	Operator  string
+	Threshold float64
+	// Percent is true if Threshold is the percentage of the reachable heap.
+	Percent bool
+}
+
+// CheckResult is the result of a rule. Limit is the threshold in bytes or the count, with the percentage resolved.
+type CheckResult struct {
+	Rule     *CheckRule
+	Value    uint64
+	Limit    uint64
+	Violated bool
+}
+
+// ParseCheckRules parses the rules of the memory budget. Each line is a rule of the metric, the class pattern
+// for the metrics of the classes, the operator and the threshold. e.g.
+//
+//	# metric           pattern            operator  threshold
+//	retained           com.example.Cache  <         200MB
+//	shallow            byte[]             <=        30%
+//	count              java.lang.Thread   <         500
+//	duplicate-strings                     <         5%
+//	heap                                  <         1GB
+//
+// The metrics are "retained", "shallow", "count", "duplicate-strings" and "heap". The class pattern is same as `-target`.
+// The sizes are in bytes, with the units like "KB", "MB" and "GB", or in the percentage of the reachable heap.
+// Empty lines and the lines starting with "#" are ignored.
+func ParseCheckRules(r io.Reader) ([]*CheckRule, error) {
+	var rules []*CheckRule
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseCheckRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		rule.Line = lineNumber
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseCheckRule(line string) (*CheckRule, error) {
+	rule := &CheckRule{Text: line, Metric: -1}
+	fields := strings.Fields(line)
+	for i, name := range checkMetricNames {
+		if fields[0] == name {
+			rule.Metric = CheckMetric(i)
+		}
+	}
+	if rule.Metric < 0 {
+		return nil, fmt.Errorf("unknown metric: %v", fields[0])
+	}
+	fields = fields[1:]
+	if rule.Metric.hasPattern() {
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("the class pattern is missing: %v", line)
+		}
+		pattern, err := NewClassPattern(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		rule.Pattern = pattern
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("the operator and the threshold are missing: %v", line)
+	}
+	switch fields[0] {
+	case "<", "<=", ">", ">=":
+		rule.Operator = fields[0]
+	default:
+		return nil, fmt.Errorf("unknown operator: %v", fields[0])
+	}
+
+	// the threshold can be written with a space, e.g. "200 MB".
+	threshold := strings.Join(fields[1:], "")
+	if strings.HasSuffix(threshold, "%") {
+		if rule.Metric == CheckMetric_COUNT {
+			return nil, fmt.Errorf("the count can't be the percentage: %v", threshold)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold: %v", threshold)
+		}
+		rule.Threshold = v
+		rule.Percent = true
+		return rule, nil
+	}
+	if v, err := strconv.ParseFloat(threshold, 64); err == nil {
+		rule.Threshold = v
+		return rule, nil
+	}
+	if rule.Metric == CheckMetric_COUNT {
+		return nil, fmt.Errorf("invalid threshold: %v", threshold)
+	}
+	v, err := bytesize.Parse(threshold)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold: %v", threshold)
+	}
+	rule.Threshold = float64(v)
+	return rule, nil
+}
+
+// LoadCheckRules reads the rules file. See ParseCheckRules for the format.
+func LoadCheckRules(path string) ([]*CheckRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCheckRules(f)
+}
+
+// Check measures the rules on the reachable heap, in the order of the rules.
+func (a HeapDumpAnalyzer) Check(tree *DominatorTree, rules []*CheckRule) ([]*CheckResult, error) {
+	heapSize := tree.RetainedSize(0)
+	values := make([]uint64, len(rules))
+
+	var classRules []int
+	var wastedSize *uint64
+	for i, rule := range rules {
+		switch {
+		case rule.Metric.hasPattern():
+			classRules = append(classRules, i)
+		case rule.Metric == CheckMetric_HEAP:
+			values[i] = heapSize
+		case rule.Metric == CheckMetric_DUPLICATE_STRINGS:
+			if wastedSize == nil {
+				duplicates, err := a.DuplicateStrings()
+				if err != nil {
+					return nil, err
+				}
+				wastedSize = new(uint64)
+				for _, duplicate := range duplicates {
+					*wastedSize += duplicate.WastedSize
+				}
+			}
+			values[i] = *wastedSize
+		}
+	}
+	if len(classRules) > 0 {
+		if err := a.checkClasses(tree, rules, classRules, values); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []*CheckResult
+	for i, rule := range rules {
+		limit := rule.Threshold
+		if rule.Percent {
+			limit = float64(heapSize) * rule.Threshold / 100
+		}
+		value := float64(values[i])
+		satisfied := false
+		switch rule.Operator {
+		case "<":
+			satisfied = value < limit
+		case "<=":
+			satisfied = value <= limit
+		case ">":
+			satisfied = value > limit
+		case ">=":
+			satisfied = value >= limit
+		}
+		results = append(results, &CheckResult{Rule: rule, Value: values[i], Limit: uint64(limit), Violated: !satisfied})
+	}
+	return results, nil
+}
+
+// checkClasses measures the rules of the classes by walking the dominator tree, keyed by the matched rules.
+func (a HeapDumpAnalyzer) checkClasses(tree *DominatorTree, rules []*CheckRule, classRules []int, values []uint64) error {
+	// class name -> the indexes of the matched rules
+	matches := make(map[string][]interface{})
+	return tree.WalkDominated(tree.Children(0), func(objectId uint64) ([]interface{}, error) {
+		className, err := a.hprof.GetObjectClassName(objectId)
+		if err != nil {
+			return nil, err
+		}
+		matched, ok := matches[className]
+		if !ok {
+			// the class objects are not the instances.
+			if !strings.HasPrefix(className, "class ") {
+				for _, i := range classRules {
+					if rules[i].Pattern.Match(className) {
+						matched = append(matched, i)
+					}
+				}
+			}
+			matches[className] = matched
+		}
+		return matched, nil
+	}, func(objectId uint64, key interface{}, outermost bool) {
+		i := key.(int)
+		switch rules[i].Metric {
+		case CheckMetric_RETAINED:
+			if outermost {
+				values[i] += tree.RetainedSize(objectId)
+			}
+		case CheckMetric_SHALLOW:
+			values[i] += tree.ShallowSize(objectId)
+		case CheckMetric_COUNT:
+			values[i]++
+		}
+	})
+}
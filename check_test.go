@@ -0,0 +1,81 @@
+package heapdump
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCheckRules(t *testing.T) {
+	rules, err := ParseCheckRules(strings.NewReader(`
+# comment
+retained com.example.Cache < 200MB
+count java.lang.Thread <= 500
+duplicate-strings < 5%
+heap >= 1 KB
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("4 rules should be parsed. But %v", len(rules))
+	}
+	if rule := rules[0]; rule.Line != 3 || rule.Metric != CheckMetric_RETAINED || !rule.Pattern.Match("com/example/Cache") ||
+		rule.Operator != "<" || rule.Threshold != 200*1024*1024 || rule.Percent {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+	if rule := rules[1]; rule.Metric != CheckMetric_COUNT || rule.Operator != "<=" || rule.Threshold != 500 {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+	if rule := rules[2]; rule.Metric != CheckMetric_DUPLICATE_STRINGS || rule.Pattern != nil || rule.Threshold != 5 || !rule.Percent {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+	if rule := rules[3]; rule.Metric != CheckMetric_HEAP || rule.Operator != ">=" || rule.Threshold != 1024 {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+
+	for _, line := range []string{"unknown < 1", "retained < 1MB", "count Foo < 10%", "heap = 1GB", "heap < 1XB"} {
+		if _, err := ParseCheckRules(strings.NewReader(line)); err == nil {
+			t.Errorf("%q should be invalid", line)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ParseCheckRules(strings.NewReader(`
+retained Object1 < 100
+retained Object* < 66
+shallow Object2 <= 24
+count Object? >= 2
+heap < 100%
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := tester.analyzer.Check(tree, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		value    uint64
+		violated bool
+	}{
+		{66, false},
+		// Object2 is retained by Object1, so it's not counted twice.
+		{66, true},
+		{24, false},
+		{2, false},
+		{tree.RetainedSize(0), true},
+	}
+	for i, e := range expected {
+		if results[i].Value != e.value || results[i].Violated != e.violated {
+			t.Errorf("%v should be %v, violated=%v. But %+v", rules[i].Text, e.value, e.violated, results[i])
+		}
+	}
+}
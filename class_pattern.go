@@ -0,0 +1,78 @@
+package heapdump
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ClassPattern matches the class names. The pattern is one of:
+//
+//   - exact name: "java.util.Vector" or the JVM internal name "java/util/Vector"
+//   - glob: "com.example.cache.*". "*" matches any characters except ".", "**" matches any characters
+//     and "?" matches a character.
+//   - regular expression enclosed by slashes: "/^com\.example\.(cache|store)\./"
+//
+// Class names are matched in the Java style, e.g. "java.util.HashMap$Node".
+type ClassPattern struct {
+	pattern string
+	exact   string
+	regexp  *regexp.Regexp
+}
+
+func NewClassPattern(pattern string) (*ClassPattern, error) {
+	m := new(ClassPattern)
+	m.pattern = pattern
+
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid class pattern %v: %v", pattern, err)
+		}
+		m.regexp = re
+		return m, nil
+	}
+
+	name := ToJavaClassName(pattern)
+	if !strings.ContainsAny(name, "*?") {
+		m.exact = name
+		return m, nil
+	}
+
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(name); i++ {
+		switch {
+		case strings.HasPrefix(name[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case name[i] == '*':
+			re.WriteString(`[^.]*`)
+		case name[i] == '?':
+			re.WriteString(`[^.]`)
+		default:
+			re.WriteString(regexp.QuoteMeta(name[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	m.regexp = regexp.MustCompile(re.String())
+	return m, nil
+}
+
+// ToJavaClassName converts the JVM internal class name to the Java style. e.g. "java/util/Vector" to "java.util.Vector".
+func ToJavaClassName(name string) string {
+	return strings.Replace(name, "/", ".", -1)
+}
+
+// Match returns true if the class name matches. Both of the JVM internal name and the Java style name are accepted.
+func (p *ClassPattern) Match(className string) bool {
+	name := ToJavaClassName(className)
+	if p.regexp != nil {
+		return p.regexp.MatchString(name)
+	}
+	return name == p.exact
+}
+
+func (p *ClassPattern) String() string {
+	return p.pattern
+}
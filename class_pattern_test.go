@@ -0,0 +1,103 @@
+package heapdump
+
+import (
+	"testing"
+)
+
+func TestClassPattern(t *testing.T) {
+	for _, c := range []struct {
+		pattern   string
+		className string
+		expected  bool
+	}{
+		{"java.util.Vector", "java/util/Vector", true},
+		{"java/util/Vector", "java/util/Vector", true},
+		{"java.util.Vector", "java/util/VectorX", false},
+		{"com.example.cache.*", "com/example/cache/LruCache", true},
+		{"com.example.cache.*", "com/example/cache/LruCache$Entry", true},
+		{"com.example.cache.*", "com/example/cache/impl/LruCache", false},
+		{"com.example.**", "com/example/cache/impl/LruCache", true},
+		{"com.example.Cache?", "com/example/Cache1", true},
+		{"com.example.Cache?", "com/example/Cache12", false},
+		{"/Cache$/", "com/example/cache/LruCache", true},
+		{"/^com\\.example\\.(cache|store)\\./", "com/example/store/Store", true},
+		{"/^com\\.example\\.(cache|store)\\./", "com/example/other/Store", false},
+	} {
+		pattern, err := NewClassPattern(c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pattern.Match(c.className) != c.expected {
+			t.Errorf("%v should match %v: %v", c.pattern, c.className, c.expected)
+		}
+	}
+}
+
+func TestClassPatternInvalidRegexp(t *testing.T) {
+	if _, err := NewClassPattern("/(/"); err == nil {
+		t.Fatal("invalid regexp should be an error")
+	}
+}
+
+func TestCalculateRetainedSizeOfInstancesByPattern(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	rootScanner := NewRootScanner(tester.analyzer.logger)
+	if err := rootScanner.ScanAll(tester.analyzer); err != nil {
+		t.Fatal(err)
+	}
+	pattern, err := NewClassPattern("Object?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tester.analyzer.CalculateRetainedSizeOfInstancesByPattern(pattern, rootScanner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("Object1 and Object2 should match. But %v classes", len(result))
+	}
+	if result[0].Class.Name != "Object1" || result[0].TotalRetainedSize != 66 {
+		t.Fatalf("Unexpected result: %v=%v", result[0].Class.Name, result[0].TotalRetainedSize)
+	}
+	if result[1].Class.Name != "Object2" || result[1].TotalRetainedSize != 42 {
+		t.Fatalf("Unexpected result: %v=%v", result[1].Class.Name, result[1].TotalRetainedSize)
+	}
+}
+
+func TestRetainedSizeOfInstancesByPattern(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern, err := NewClassPattern("Object?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tester.analyzer.RetainedSizeOfInstancesByPattern(tree, pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || result[0].Class.Name != "Object1" || result[0].TotalRetainedSize != 66 ||
+		result[1].Class.Name != "Object2" || result[1].TotalRetainedSize != 42 {
+		t.Fatalf("Object1 and Object2 should retain 66 and 42 bytes. But %+v, %+v", result[0], result[1])
+	}
+
+	// Object2 is retained by Object1, so the total is the retained size of Object1.
+	objectIds := make(map[uint64]bool)
+	for _, sizes := range result {
+		for objectId, size := range sizes.RetainedSizes {
+			if size != tree.RetainedSize(objectId) {
+				t.Errorf("%v should retain %v bytes like the dominator tree. But %v", objectId, tree.RetainedSize(objectId), size)
+			}
+			objectIds[objectId] = true
+		}
+	}
+	if total := tree.TotalRetainedSize(objectIds); total != 66 {
+		t.Errorf("The total should be 66. But %v", total)
+	}
+}
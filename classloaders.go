@@ -0,0 +1,252 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"sort"
+)
+
+// BootstrapClassLoaderName is the name of the bootstrap class loader, whose object ID is 0.
+const BootstrapClassLoaderName = "<bootstrap>"
+
+// ClassLoaderEntry is the classes and the instances loaded by a class loader.
+type ClassLoaderEntry struct {
+	// ObjectId is 0 for the bootstrap class loader.
+	ObjectId      uint64
+	ClassName     string
+	ClassCount    int
+	InstanceCount int
+	// RetainedSize is the size of the objects retained by the loader, its classes or their instances.
+	// See DominatorTree.WalkDominated.
+	RetainedSize uint64
+	// Referenced is true if the loader is strongly reachable from the GC roots without going through its own classes and instances,
+	// e.g. from the container or the child loaders.
+	Referenced bool
+	// Pinned is true if the loader isn't referenced, but its classes or instances are still reachable from the GC roots.
+	// That keeps the loader and all of its classes alive, as the redeployed web application leaked by a ThreadLocal.
+	Pinned bool
+	// PinPath is the shortest path from the GC roots to the loader, or to its biggest reachable object if the loader isn't
+	// reachable by the fields. Only for the pinned loaders.
+	PinPath []*PathElement
+}
+
+// DuplicateClass is a class name loaded by the multiple class loaders.
+type DuplicateClass struct {
+	Name string
+	// Classes are ordered by the class loader object ID.
+	Classes []*Class
+}
+
+// classLoaderIndex maps the objects to their class loaders.
+type classLoaderIndex struct {
+	// class object ID -> class loader object ID
+	loaders map[uint64]uint64
+	// class loader object ID -> class object IDs
+	classes map[uint64][]uint64
+}
+
+func (a HeapDumpAnalyzer) buildClassLoaderIndex() (*classLoaderIndex, error) {
+	m := new(classLoaderIndex)
+	m.loaders = make(map[uint64]uint64)
+	m.classes = make(map[uint64][]uint64)
+	err := a.hprof.ForEachClassDump(func(classDump *hprofdata.HProfClassDump) error {
+		m.loaders[classDump.ClassObjectId] = classDump.ClassLoaderObjectId
+		m.classes[classDump.ClassLoaderObjectId] = append(m.classes[classDump.ClassLoaderObjectId], classDump.ClassObjectId)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// loaderOf returns the class loader of the class of the object, or of the class itself.
+// The primitive arrays belong to the bootstrap class loader.
+func (m *classLoaderIndex) loaderOf(h *HProf, objectId uint64) uint64 {
+	if instanceDump, ok := h.objectId2instanceDump[objectId]; ok {
+		return m.loaders[instanceDump.ClassObjectId]
+	}
+	if arrayDump, ok := h.arrayObjectId2objectArrayDump[objectId]; ok {
+		return m.loaders[arrayDump.ArrayClassObjectId]
+	}
+	return m.loaders[objectId]
+}
+
+// ClassLoaders returns the classes, the instances and the retained size of each class loader, ordered by the retained size.
+// The loaders which are pinned by their own classes or instances are flagged.
+func (a HeapDumpAnalyzer) ClassLoaders(tree *DominatorTree, referrers *Referrers) ([]*ClassLoaderEntry, error) {
+	index, err := a.buildClassLoaderIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[uint64]*ClassLoaderEntry)
+	for loaderObjectId, classObjectIds := range index.classes {
+		entry := &ClassLoaderEntry{ObjectId: loaderObjectId, ClassName: BootstrapClassLoaderName, ClassCount: len(classObjectIds)}
+		if loaderObjectId != 0 {
+			if entry.ClassName, err = a.hprof.GetObjectClassName(loaderObjectId); err != nil {
+				return nil, err
+			}
+		}
+		for _, classObjectId := range classObjectIds {
+			entry.InstanceCount += len(a.hprof.classObjectId2objectIds[classObjectId])
+		}
+		entries[loaderObjectId] = entry
+	}
+	for _, arrayDump := range a.hprof.arrayObjectId2objectArrayDump {
+		if entry, ok := entries[index.loaders[arrayDump.ArrayClassObjectId]]; ok {
+			entry.InstanceCount++
+		}
+	}
+	if entry, ok := entries[0]; ok {
+		entry.InstanceCount += len(a.hprof.arrayObjectId2primitiveArrayDump)
+	}
+
+	if err := a.classLoaderRetainedSizes(tree, index, entries); err != nil {
+		return nil, err
+	}
+
+	for loaderObjectId, entry := range entries {
+		if loaderObjectId == 0 {
+			entry.Referenced = true
+			continue
+		}
+		if entry.Referenced, err = a.isReferencedLoader(referrers, index, loaderObjectId); err != nil {
+			return nil, err
+		}
+		if entry.Referenced {
+			continue
+		}
+		pin := uint64(0)
+		if tree.Contains(loaderObjectId) {
+			pin = loaderObjectId
+		} else {
+			// the loader is kept alive by its classes or instances which aren't linked to the loader by the fields.
+			for _, classObjectId := range index.classes[loaderObjectId] {
+				objectIds := append([]uint64{classObjectId}, a.hprof.classObjectId2objectIds[classObjectId]...)
+				for _, objectId := range objectIds {
+					if tree.Contains(objectId) && (pin == 0 || tree.RetainedSize(objectId) > tree.RetainedSize(pin)) {
+						pin = objectId
+					}
+				}
+			}
+		}
+		if pin == 0 {
+			continue
+		}
+		entry.Pinned = true
+		if entry.PinPath, err = a.FindPathFromRoot(pin); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []*ClassLoaderEntry
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].RetainedSize != result[j].RetainedSize {
+			return result[i].RetainedSize > result[j].RetainedSize
+		}
+		return result[i].ObjectId < result[j].ObjectId
+	})
+	return result, nil
+}
+
+// classLoaderRetainedSizes sums up the retained sizes of each loader by DominatorTree.WalkDominated, keyed by the loaders.
+func (a HeapDumpAnalyzer) classLoaderRetainedSizes(tree *DominatorTree, index *classLoaderIndex, entries map[uint64]*ClassLoaderEntry) error {
+	return tree.WalkDominated(tree.Children(0), func(objectId uint64) ([]interface{}, error) {
+		// the loader object belongs to itself, and to the loader of its class.
+		loader := index.loaderOf(a.hprof, objectId)
+		if _, ok := entries[objectId]; ok && objectId != loader {
+			return []interface{}{loader, objectId}, nil
+		}
+		return []interface{}{loader}, nil
+	}, func(objectId uint64, key interface{}, outermost bool) {
+		if entry, ok := entries[key.(uint64)]; ok && outermost {
+			entry.RetainedSize += tree.RetainedSize(objectId)
+		}
+	})
+}
+
+// isReferencedLoader returns true if the loader is strongly reachable from the GC roots by the paths which don't go through
+// the classes and the instances loaded by the loader. The soft, weak and phantom references don't keep the loader alive.
+func (a HeapDumpAnalyzer) isReferencedLoader(referrers *Referrers, index *classLoaderIndex, loaderObjectId uint64) (bool, error) {
+	seen := map[uint64]bool{loaderObjectId: true}
+	queue := []uint64{loaderObjectId}
+	for len(queue) > 0 {
+		objectId := queue[0]
+		queue = queue[1:]
+		if len(a.hprof.GetRootKinds(objectId)) > 0 {
+			return true, nil
+		}
+		for _, referrer := range referrers.Get(objectId) {
+			if seen[referrer] || index.loaderOf(a.hprof, referrer) == loaderObjectId {
+				continue
+			}
+			strong, err := a.hasStrongReference(referrer, objectId)
+			if err != nil {
+				return false, err
+			}
+			if !strong {
+				continue
+			}
+			seen[referrer] = true
+			queue = append(queue, referrer)
+		}
+	}
+	return false, nil
+}
+
+// hasStrongReference returns true if the referrer refers the object by a strong reference.
+func (a HeapDumpAnalyzer) hasStrongReference(referrerObjectId uint64, objectId uint64) (bool, error) {
+	strong := false
+	err := a.hprof.ForEachReference(referrerObjectId, func(ref *Reference) error {
+		if ref.ObjectId == objectId && ref.Strength == ReferenceStrength_STRONG {
+			strong = true
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return false, err
+	}
+	return strong, nil
+}
+
+// DuplicateClasses returns the class names loaded by the multiple class loaders, ordered by the name.
+func (a HeapDumpAnalyzer) DuplicateClasses() ([]*DuplicateClass, error) {
+	classes := make(map[string][]*Class)
+	err := a.hprof.ForEachClassDump(func(classDump *hprofdata.HProfClassDump) error {
+		class, err := a.GetClass(classDump.ClassObjectId)
+		if err != nil {
+			return err
+		}
+		classes[class.Name] = append(classes[class.Name], class)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*DuplicateClass
+	for name, sameNamed := range classes {
+		loaders := make(map[uint64]bool)
+		for _, class := range sameNamed {
+			loaders[class.ClassLoaderObjectId] = true
+		}
+		if len(loaders) < 2 {
+			continue
+		}
+		sort.Slice(sameNamed, func(i, j int) bool {
+			if sameNamed[i].ClassLoaderObjectId != sameNamed[j].ClassLoaderObjectId {
+				return sameNamed[i].ClassLoaderObjectId < sameNamed[j].ClassLoaderObjectId
+			}
+			return sameNamed[i].ClassObjectId < sameNamed[j].ClassObjectId
+		})
+		result = append(result, &DuplicateClass{Name: name, Classes: sameNamed})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
@@ -0,0 +1,153 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"testing"
+)
+
+func TestClassLoaders(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrers, err := tester.analyzer.BuildReferrers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaders, err := tester.analyzer.ClassLoaders(tree, referrers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaders) != 2 {
+		t.Fatalf("The bootstrap and the application class loaders should be found. But %v", len(loaders))
+	}
+	if loaders[0].ObjectId != 0 || loaders[0].ClassName != BootstrapClassLoaderName {
+		t.Errorf("The bootstrap class loader should retain the most. But %v", loaders[0].ClassName)
+	}
+
+	classObjectIds, err := tester.analyzer.FindClassObjectIdsByName("Object1")
+	if err != nil || len(classObjectIds) != 1 {
+		t.Fatalf("Object1 should be found: %v", err)
+	}
+	class, err := tester.analyzer.GetClass(classObjectIds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := loaders[1]
+	if app.ObjectId != class.ClassLoaderObjectId {
+		t.Fatalf("Object1 should be loaded by %v. But %v", app.ObjectId, class.ClassLoaderObjectId)
+	}
+	// Object1, Object2 and the main class.
+	if app.ClassCount != 3 || app.InstanceCount != 2 {
+		t.Errorf("The application class loader should have 3 classes and 2 instances. But %v, %v",
+			app.ClassCount, app.InstanceCount)
+	}
+	if app.RetainedSize < 66 {
+		t.Errorf("The application class loader should retain Object1. But %v", app.RetainedSize)
+	}
+	if !app.Referenced || app.Pinned {
+		t.Errorf("The application class loader should be referenced, and not be pinned")
+	}
+
+	duplicates, err := tester.analyzer.DuplicateClasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 0 {
+		t.Errorf("No class should be loaded twice. But %v", duplicates[0].Name)
+	}
+}
+
+func TestClassLoadersPinned(t *testing.T) {
+	b := NewHProfBuilder(8)
+	object := b.AddClass("java/lang/Object", 0)
+	loaderClass := b.AddClass("org/apache/catalina/loader/WebappClassLoader", object)
+	thread := b.AddClass("java/lang/Thread", object,
+		HProfBuilderField{"threadLocals", hprofdata.HProfValueType_OBJECT})
+	// the web application is undeployed, but its instance is left in the ThreadLocal of the thread pool.
+	webapp := b.AddInstance(loaderClass)
+	session := b.AddClass("com/example/Session", object,
+		HProfBuilderField{"user", hprofdata.HProfValueType_OBJECT},
+		HProfBuilderField{"id", hprofdata.HProfValueType_INT})
+	b.SetClassLoader(session, webapp)
+	worker := b.AddInstance(thread)
+	leaked := b.AddInstance(session)
+	b.SetField(worker, "threadLocals", leaked)
+	b.AddRoot(parser.HProfHDRecordTypeRootThreadObj, worker)
+	// the container still refers the loader weakly, which doesn't keep it alive.
+	weakReference := b.AddInstance(referenceClasses(b, object)["java/lang/ref/WeakReference"])
+	b.SetField(weakReference, "referent", webapp)
+	b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, weakReference)
+	tester := builderTestData(t, b)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrers, err := tester.analyzer.BuildReferrers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaders, err := tester.analyzer.ClassLoaders(tree, referrers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry *ClassLoaderEntry
+	for _, loader := range loaders {
+		if loader.ObjectId == webapp {
+			entry = loader
+		}
+	}
+	if entry == nil {
+		t.Fatalf("The web application class loader should be found")
+	}
+	if entry.Referenced || !entry.Pinned {
+		t.Errorf("The web application class loader should be pinned. But %+v", entry)
+	}
+	if len(entry.PinPath) != 2 || entry.PinPath[0].ObjectId != worker || entry.PinPath[1].ObjectId != leaked ||
+		entry.PinPath[1].Reference != "threadLocals" {
+		t.Errorf("The pin path should be the ThreadLocal of the thread. But %v", entry.PinPath)
+	}
+	if entry.ClassCount != 1 || entry.InstanceCount != 1 || entry.RetainedSize != 28 {
+		t.Errorf("The web application class loader should retain the session. But %+v", entry)
+	}
+}
+
+func TestDuplicateClasses(t *testing.T) {
+	b := NewHProfBuilder(8)
+	object := b.AddClass("java/lang/Object", 0)
+	loaderClass := b.AddClass("java/net/URLClassLoader", object)
+	loader1 := b.AddInstance(loaderClass)
+	loader2 := b.AddInstance(loaderClass)
+	plugin1 := b.AddClass("com/example/Plugin", object)
+	b.SetClassLoader(plugin1, loader1)
+	plugin2 := b.AddClass("com/example/Plugin", object)
+	b.SetClassLoader(plugin2, loader2)
+	// loaded by the same loader twice isn't a duplicate.
+	util := b.AddClass("com/example/Util", object)
+	b.SetClassLoader(util, loader1)
+	b.SetClassLoader(b.AddClass("com/example/Util", object), loader1)
+	for _, objectId := range []uint64{loader1, loader2} {
+		b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, objectId)
+	}
+	tester := builderTestData(t, b)
+	defer tester.Close()
+
+	duplicates, err := tester.analyzer.DuplicateClasses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 1 || duplicates[0].Name != "com/example/Plugin" {
+		t.Fatalf("Plugin should be loaded twice. But %v", duplicates)
+	}
+	classes := duplicates[0].Classes
+	if len(classes) != 2 || classes[0].ClassObjectId != plugin1 || classes[0].ClassLoaderObjectId != loader1 ||
+		classes[1].ClassObjectId != plugin2 || classes[1].ClassLoaderObjectId != loader2 {
+		t.Errorf("Plugin should be loaded by the loaders. But %+v, %+v", classes[0], classes[1])
+	}
+}
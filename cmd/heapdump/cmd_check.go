@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+)
+
+func runCheck(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("check", "<hprof|index>")
+	rulesPath := fs.String("rules", "", "check the rules in the `file`, which has the lines like \"retained com.example.Cache < 200MB\". (required)")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	if *rulesPath == "" {
+		fmt.Fprintln(fs.Output(), "-rules is required")
+		fs.Usage()
+		return errUsage
+	}
+	rules, err := heapdump.LoadCheckRules(*rulesPath)
+	if err != nil {
+		return fmt.Errorf("%v: %v", *rulesPath, err)
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	tree, err := g.buildDominatorTree(analyzer)
+	if err != nil {
+		return err
+	}
+	results, err := analyzer.Check(tree, rules)
+	if err != nil {
+		return err
+	}
+
+	violations := 0
+	w := newTabWriter()
+	fmt.Fprintf(w, "status\tvalue\tlimit\t  rule\n")
+	for _, result := range results {
+		status := "OK"
+		if result.Violated {
+			status = "VIOLATED"
+			violations++
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t  %s\n", status, result.Value, result.Limit, result.Rule.Text)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if violations > 0 {
+		fmt.Printf("%d of %d rules violated\n", violations, len(results))
+		return errFailed
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"os"
+)
+
+func runClassLoaders(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("classloaders", "<hprof|index>")
+	dup := fs.Bool("dup", false, "show the class names loaded by the multiple class loaders")
+	pinned := fs.Bool("pinned", false, "show the loaders pinned by their own classes or instances, with the paths from the GC roots")
+	limit := fs.Int("n", 0, "show top `N` rows only. 0 means all")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	w := newTabWriter()
+	if *dup {
+		duplicates, err := analyzer.DuplicateClasses()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "loaders\tinstances\t  class\t  classLoaders\n")
+		for i, duplicate := range duplicates {
+			if *limit > 0 && i >= *limit {
+				break
+			}
+			instances := 0
+			loaders := ""
+			for j, class := range duplicate.Classes {
+				instances += class.InstanceCount
+				if j > 0 {
+					loaders += ", "
+				}
+				loaders += fmt.Sprint(class.ClassLoaderObjectId)
+			}
+			fmt.Fprintf(w, "%d\t%d\t  %s\t  %s\n", len(duplicate.Classes), instances, duplicate.Name, loaders)
+		}
+		fmt.Fprintf(w, "total\t\t  (%d classes)\t\n", len(duplicates))
+		return w.Flush()
+	}
+
+	tree, err := g.buildDominatorTree(analyzer)
+	if err != nil {
+		return err
+	}
+	referrers, err := g.buildReferrers(analyzer)
+	if err != nil {
+		return err
+	}
+	loaders, err := analyzer.ClassLoaders(tree, referrers)
+	if err != nil {
+		return err
+	}
+
+	if *pinned {
+		n := 0
+		for _, loader := range loaders {
+			if !loader.Pinned || *limit > 0 && n >= *limit {
+				continue
+			}
+			n++
+			fmt.Printf("%s (%d) retains %d bytes, with %d classes and %d instances, pinned by:\n",
+				loader.ClassName, loader.ObjectId, loader.RetainedSize, loader.ClassCount, loader.InstanceCount)
+			heapdump.WritePath(os.Stdout, loader.PinPath, "  ")
+			fmt.Println()
+		}
+		if n == 0 {
+			fmt.Println("No pinned class loader found.")
+		}
+		return nil
+	}
+
+	fmt.Fprintf(w, "objectId\tclasses\tinstances\tretainedSize\t  status\t  class\n")
+	for i, loader := range loaders {
+		if *limit > 0 && i >= *limit {
+			break
+		}
+		status := "referenced"
+		if loader.Pinned {
+			status = "PINNED"
+		} else if !loader.Referenced {
+			status = "unreachable"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t  %s\t  %s\n",
+			loader.ObjectId, loader.ClassCount, loader.InstanceCount, loader.RetainedSize, status, loader.ClassName)
+	}
+	return w.Flush()
+}
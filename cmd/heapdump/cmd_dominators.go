@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+)
+
+func runDominators(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("dominators", "<hprof|index>")
+	limit := fs.Int("n", 20, "show top `N` objects only. 0 means all")
+	parent := fs.String("object", "0", "show the objects dominated by the `objectId`. 0 means the GC roots")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	parentObjectId, err := parseObjectId(*parent)
+	if err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	tree, err := g.buildDominatorTree(analyzer)
+	if err != nil {
+		return err
+	}
+	if parentObjectId != 0 && !tree.Contains(parentObjectId) {
+		return fmt.Errorf("object %v is not reachable from the GC roots", parentObjectId)
+	}
+	entries, err := analyzer.GetDominatorEntries(tree, parentObjectId, *limit)
+	if err != nil {
+		return err
+	}
+
+	total := tree.RetainedSize(0)
+	w := newTabWriter()
+	fmt.Fprintf(w, "objectId\tshallowSize\tretainedSize\tpercentage\t  class\n")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%.2f%%\t  %s\n",
+			entry.ObjectId, entry.ShallowSize, entry.RetainedSize,
+			heapdump.Percentage(entry.RetainedSize, total), entry.ClassName)
+	}
+	return w.Flush()
+}
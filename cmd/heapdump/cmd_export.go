@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"io"
+	"os"
+)
+
+func runExport(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("export", "<hprof|index>")
+	format := fs.String("format", "pprof", "output `format`: pprof, folded, dot, graphml, sqlite or ndjson")
+	output := fs.String("o", "", "write to the `file`. (default: stdout except pprof and sqlite)")
+	paths := fs.Bool("paths", false, "pprof and folded: follow the shortest paths from the GC roots instead of the dominator tree")
+	maxDepth := fs.Int("max-depth", 64, "pprof and folded: fold the frames deeper than `N`. 0 means unlimited")
+	object := fs.String("object", "", "dot and graphml: start from the object `ID`")
+	targetClassName := fs.String("target", "", "dot and graphml: start from the instances of the class `pattern`")
+	depth := fs.Int("depth", 3, "dot and graphml: follow `N` references at most from the start objects")
+	maxNodes := fs.Int("max-nodes", 100, "dot and graphml: export `N` objects at most")
+	ndjson := fs.Bool("ndjson", false, "same as -format ndjson")
+	sqlite := fs.String("sqlite", "", "same as -format sqlite -o `file`")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	if *ndjson {
+		if *format != "pprof" && *format != "ndjson" {
+			fmt.Fprintf(fs.Output(), "-ndjson and -format %v can't be used together\n", *format)
+			fs.Usage()
+			return errUsage
+		}
+		*format = "ndjson"
+	}
+	if *sqlite != "" {
+		if *format != "pprof" && *format != "sqlite" {
+			fmt.Fprintf(fs.Output(), "-sqlite and -format %v can't be used together\n", *format)
+			fs.Usage()
+			return errUsage
+		}
+		if *output != "" && *output != *sqlite {
+			fmt.Fprintln(fs.Output(), "-sqlite and -o can't be used together")
+			fs.Usage()
+			return errUsage
+		}
+		*format = "sqlite"
+		*output = *sqlite
+	}
+	graph := *format == "dot" || *format == "graphml"
+	if *format != "pprof" && *format != "folded" && *format != "sqlite" && *format != "ndjson" && !graph {
+		fmt.Fprintf(fs.Output(), "Unknown format: %v\n", *format)
+		fs.Usage()
+		return errUsage
+	}
+	if (*format == "pprof" || *format == "sqlite") && *output == "" {
+		fmt.Fprintf(fs.Output(), "-o is required for %v\n", *format)
+		fs.Usage()
+		return errUsage
+	}
+	if graph && (*object == "") == (*targetClassName == "") {
+		fmt.Fprintf(fs.Output(), "Either -object or -target is required for %v\n", *format)
+		fs.Usage()
+		return errUsage
+	}
+	kind := heapdump.HeapStackTree_DOMINATOR
+	if *paths {
+		kind = heapdump.HeapStackTree_PATH
+	}
+
+	if *format == "ndjson" {
+		// stream the objects from the hprof without the index.
+		if info, err := os.Stat(fs.Arg(0)); err == nil && info.IsDir() {
+			return fmt.Errorf("ndjson is streamed from the hprof file, not the index directory: %v", fs.Arg(0))
+		}
+		return writeOutput(*output, func(w io.Writer) error {
+			return heapdump.WriteNDJSON(g.logger, fs.Arg(0), w)
+		})
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	tree, err := g.buildDominatorTree(analyzer)
+	if err != nil {
+		return err
+	}
+
+	var write func(w io.Writer) error
+	switch *format {
+	case "pprof":
+		write = func(w io.Writer) error {
+			return analyzer.WriteProfile(w, tree, kind, *maxDepth)
+		}
+	case "folded":
+		write = func(w io.Writer) error {
+			return analyzer.WriteFoldedStacks(w, tree, kind, *maxDepth)
+		}
+	case "sqlite":
+		write = func(w io.Writer) error {
+			// the tables are written from the end of the file, and the schema is written at the head at last.
+			return analyzer.WriteSQLite(w.(io.WriteSeeker), tree)
+		}
+	default:
+		startObjectIds, err := exportStartObjectIds(analyzer, *object, *targetClassName)
+		if err != nil {
+			return err
+		}
+		subgraph, err := analyzer.BuildSubgraph(tree, startObjectIds, *depth, *maxNodes)
+		if err != nil {
+			return err
+		}
+		if subgraph.Truncated {
+			g.logger.Info("Exported %d objects only. Increase -max-nodes to export more.", len(subgraph.Nodes))
+		}
+		write = subgraph.WriteDOT
+		if *format == "graphml" {
+			write = subgraph.WriteGraphML
+		}
+	}
+	return writeOutput(*output, write)
+}
+
+// writeOutput calls write with the file, or stdout if the path is empty.
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// exportStartObjectIds returns the object ID, or the instances of the classes matching the pattern.
+func exportStartObjectIds(analyzer *heapdump.HeapDumpAnalyzer, object string, targetClassName string) ([]uint64, error) {
+	if object != "" {
+		objectId, err := parseObjectId(object)
+		if err != nil {
+			return nil, err
+		}
+		if exists, err := analyzer.HasObject(objectId); err != nil || !exists {
+			if err == nil {
+				err = fmt.Errorf("object %v is not found", objectId)
+			}
+			return nil, err
+		}
+		return []uint64{objectId}, nil
+	}
+
+	classObjectIds, err := analyzer.FindClassObjectIdsByName(targetClassName)
+	if err != nil {
+		return nil, err
+	}
+	if len(classObjectIds) == 0 {
+		return nil, fmt.Errorf("no class matches %v", targetClassName)
+	}
+	var objectIds []uint64
+	for _, classObjectId := range classObjectIds {
+		err := analyzer.ForEachInstance(classObjectId, func(objectId uint64) error {
+			objectIds = append(objectIds, objectId)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objectIds, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"os"
+)
+
+func runExtract(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("extract", "<hprof>")
+	object := fs.String("object", "", "extract from the object `ID`")
+	targetClassName := fs.String("target", "", "extract from the instances of the class `pattern`")
+	reachable := fs.Bool("reachable", false, "extract all the objects reachable from the start objects, instead of the dominated objects")
+	output := fs.String("o", "", "write the extracted hprof to the `file`. (required)")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	if *output == "" {
+		fmt.Fprintln(fs.Output(), "-o is required")
+		fs.Usage()
+		return errUsage
+	}
+	if (*object == "") == (*targetClassName == "") {
+		fmt.Fprintln(fs.Output(), "Either -object or -target is required")
+		fs.Usage()
+		return errUsage
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	startObjectIds, err := exportStartObjectIds(analyzer, *object, *targetClassName)
+	if err != nil {
+		return err
+	}
+	mode := heapdump.ExtractMode_DOMINATED
+	var tree *heapdump.DominatorTree
+	if *reachable {
+		mode = heapdump.ExtractMode_REACHABLE
+	} else {
+		tree, err = g.buildDominatorTree(analyzer)
+		if err != nil {
+			return err
+		}
+	}
+	objectIds, err := analyzer.SelectSubHeap(tree, startObjectIds, mode)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := analyzer.WriteSubHeap(fs.Arg(0), f, objectIds, startObjectIds); err != nil {
+		f.Close()
+		os.Remove(*output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	g.logger.Info("Wrote %d objects to %v", len(objectIds), *output)
+	return nil
+}
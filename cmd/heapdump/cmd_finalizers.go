@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+)
+
+func runFinalizers(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("finalizers", "<hprof|index>")
+	threshold := fs.Float64("threshold", 10, "flag the classes whose pending objects retain `percent` of the heap or more as DOMINANT")
+	limit := fs.Int("n", 0, "show top `N` classes only. 0 means all")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	report, err := analyzer.FindPendingFinalizers(*threshold)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d finalizable objects, %d pending finalization, retaining %d bytes (%.1f%% of the heap)\n\n",
+		report.FinalizerCount, report.PendingCount, report.RetainedSize, heapdump.Percentage(report.RetainedSize, report.TotalSize))
+
+	w := newTabWriter()
+	fmt.Fprintf(w, "count\tpending\tshallowSize\tretainedSize\tpercentage\tstatus\t  class\n")
+	for i, class := range report.Classes {
+		if *limit > 0 && i >= *limit {
+			break
+		}
+		status := ""
+		if class.Dominant {
+			status = "DOMINANT"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%.1f%%\t%s\t  %s\n", class.Count, class.PendingCount,
+			class.ShallowSize, class.RetainedSize, class.Percentage, status, class.ClassName)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+)
+
+func runFind(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("find", "<hprof|index> <class pattern>")
+	limit := fs.Int("n", 100, "show first `N` instances only. 0 means all")
+	previewLength := fs.Int("preview-length", 60, "truncate the preview around `N` characters")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	pattern, err := heapdump.NewClassPattern(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	classObjectIds, err := analyzer.FindClassObjectIdsByPattern(pattern)
+	if err != nil {
+		return err
+	}
+	if len(classObjectIds) == 0 {
+		return fmt.Errorf("no class matches %v", pattern)
+	}
+
+	w := newTabWriter()
+	fmt.Fprintf(w, "objectId\tshallowSize\t  class\t  preview\n")
+	n := 0
+	for _, classObjectId := range classObjectIds {
+		class, err := analyzer.GetClass(classObjectId)
+		if err != nil {
+			return err
+		}
+		err = analyzer.ForEachInstance(classObjectId, func(objectId uint64) error {
+			n++
+			if *limit > 0 && n > *limit {
+				return nil
+			}
+			size, err := analyzer.GetShallowSize(objectId)
+			if err != nil {
+				return err
+			}
+			preview, err := analyzer.GetObjectPreview(objectId, *previewLength)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%d\t%d\t  %s\t  %s\n", objectId, size, class.Name, preview)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "total\t\t  %d instances\t\n", n)
+	return w.Flush()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+)
+
+func runHistogram(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("histogram", "<hprof|index>")
+	limit := fs.Int("n", 0, "show top `N` classes only. 0 means all")
+	live := fs.Bool("live", false, "exclude the objects unreachable from the GC roots, like jmap -histo:live")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	var histogram []*heapdump.HistogramEntry
+	if *live {
+		reachability, err := analyzer.ScanReachability()
+		if err != nil {
+			return err
+		}
+		histogram, err = analyzer.ReachableHistogram(reachability)
+	} else {
+		histogram, err = analyzer.Histogram()
+	}
+	if err != nil {
+		return err
+	}
+	w := newTabWriter()
+	fmt.Fprintf(w, "count\tshallowSize\t  class\n")
+	for i, entry := range histogram {
+		if *limit > 0 && i >= *limit {
+			break
+		}
+		fmt.Fprintf(w, "%d\t%d\t  %s\n", entry.Count, entry.ShallowSize, entry.Name)
+	}
+	return w.Flush()
+}
+
+func runUnreachable(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("unreachable", "<hprof|index>")
+	limit := fs.Int("n", 0, "show top `N` classes only. 0 means all")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	reachability, err := analyzer.ScanReachability()
+	if err != nil {
+		return err
+	}
+	histogram, err := analyzer.UnreachableHistogram(reachability)
+	if err != nil {
+		return err
+	}
+	all, err := analyzer.Histogram()
+	if err != nil {
+		return err
+	}
+	heapSize := uint64(0)
+	for _, entry := range all {
+		heapSize += entry.ShallowSize
+	}
+
+	w := newTabWriter()
+	fmt.Fprintf(w, "count\tshallowSize\t  class\n")
+	count := 0
+	size := uint64(0)
+	for i, entry := range histogram {
+		count += entry.Count
+		size += entry.ShallowSize
+		if *limit > 0 && i >= *limit {
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%d\t  %s\n", entry.Count, entry.ShallowSize, entry.Name)
+	}
+	fmt.Fprintf(w, "%d\t%d\t  total (%.1f%% of the heap)\n", count, size, heapdump.Percentage(size, heapSize))
+	return w.Flush()
+}
+
+func runReachability(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("reachability", "<hprof|index>")
+	limit := fs.Int("n", 20, "show top `N` classes of each reachability only. 0 means all")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	reachability, err := analyzer.ScanReachability()
+	if err != nil {
+		return err
+	}
+	w := newTabWriter()
+	fmt.Fprintf(w, "reachability\tcount\tshallowSize\t  class\n")
+	for _, strength := range heapdump.WeakReferenceStrengths {
+		histogram, err := analyzer.ReachabilityHistogram(reachability, strength)
+		if err != nil {
+			return err
+		}
+		count := 0
+		size := uint64(0)
+		for i, entry := range histogram {
+			count += entry.Count
+			size += entry.ShallowSize
+			if *limit > 0 && i >= *limit {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t  %s\n", strength, entry.Count, entry.ShallowSize, entry.Name)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t  total\n", strength, count, size)
+	}
+	return w.Flush()
+}
+
+func runDiff(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("diff", "<base hprof|index> <hprof|index>")
+	limit := fs.Int("n", 0, "show top `N` classes only. 0 means all")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+
+	var histograms [2][]*heapdump.HistogramEntry
+	for i, path := range fs.Args() {
+		// the -index option can't be shared by two heap dumps.
+		analyzer, err := g.openWithIndex(path, "")
+		if err != nil {
+			return err
+		}
+		histograms[i], err = analyzer.Histogram()
+		analyzer.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	diffs := heapdump.DiffHistograms(histograms[0], histograms[1])
+	w := newTabWriter()
+	fmt.Fprintf(w, "countDelta\tshallowSizeDelta\tcount\tshallowSize\t  class\n")
+	for i, diff := range diffs {
+		if *limit > 0 && i >= *limit {
+			break
+		}
+		if diff.CountDelta == 0 && diff.ShallowSizeDelta == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%+d\t%+d\t%d\t%d\t  %s\n",
+			diff.CountDelta, diff.ShallowSizeDelta, diff.Count, diff.ShallowSize, diff.Name)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runIndex(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("index", "<hprof>")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	heapFilePath := fs.Arg(0)
+
+	indexPath := g.indexPath
+	if indexPath == "" {
+		indexPath = heapFilePath + ".index"
+	}
+	if _, err := os.Stat(indexPath); err == nil {
+		return fmt.Errorf("index already exists: %v", indexPath)
+	}
+
+	analyzer, err := g.openWithIndex(heapFilePath, indexPath)
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+	fmt.Printf("Created index: %v\n", indexPath)
+	return nil
+}
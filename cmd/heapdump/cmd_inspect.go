@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+)
+
+func runInspect(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("inspect", "<hprof|index> <objectId>")
+	maxElements := fs.Int("n", 100, "show first `N` elements of the arrays")
+	retained := fs.Bool("retained", false, "show the retained size. It scans the whole heap")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	objectId, err := parseObjectId(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	object, err := analyzer.GetObject(objectId)
+	if err != nil {
+		return err
+	}
+	if object == nil {
+		return fmt.Errorf("object %v is not in the heap dump", objectId)
+	}
+	shallowSize, err := analyzer.GetShallowSize(objectId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("objectId:     %d\n", object.ObjectId)
+	fmt.Printf("kind:         %s\n", object.Kind)
+	fmt.Printf("class:        %s\n", object.ClassName)
+	fmt.Printf("shallowSize:  %d\n", shallowSize)
+	if *retained {
+		rootScanner, err := g.scanRoots(analyzer)
+		if err != nil {
+			return err
+		}
+		size, err := analyzer.GetRetainedSize(objectId, rootScanner)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("retainedSize: %d\n", size)
+	}
+
+	switch object.Kind {
+	case heapdump.ObjectKind_INSTANCE, heapdump.ObjectKind_CLASS:
+		fmt.Printf("fields:\n")
+		for _, field := range object.Fields {
+			fmt.Printf("  %s %s = %s\n", field.Type, field.Name, field.String())
+		}
+	case heapdump.ObjectKind_OBJECT_ARRAY, heapdump.ObjectKind_PRIMITIVE_ARRAY:
+		fmt.Printf("length:       %d\n", object.Length)
+		fmt.Printf("elements:\n")
+		for i := 0; i < object.Length && i < *maxElements; i++ {
+			if object.Kind == heapdump.ObjectKind_OBJECT_ARRAY {
+				element := &heapdump.Field{Type: object.ElementType, Value: object.ElementObjectIds[i]}
+				fmt.Printf("  [%d] = %s\n", i, element.String())
+			} else {
+				fmt.Printf("  [%d] = %s\n", i, object.Element(i).String())
+			}
+		}
+		if object.Length > *maxElements {
+			fmt.Printf("  ... %d more\n", object.Length-*maxElements)
+		}
+	}
+	return nil
+}
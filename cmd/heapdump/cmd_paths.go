@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"os"
+)
+
+func runPaths(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("paths", "<hprof|index> <objectId>")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	objectId, err := parseObjectId(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	path, err := analyzer.FindPathFromRoot(objectId)
+	if err != nil {
+		return err
+	}
+	if path == nil {
+		return fmt.Errorf("object %v is not reachable from the GC roots", objectId)
+	}
+	heapdump.WritePath(os.Stdout, path, "")
+	return nil
+}
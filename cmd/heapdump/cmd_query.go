@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"os"
+)
+
+func runQuery(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("query", "<hprof|index> <query>")
+	format := fs.String("format", "table", "output `format`: table or json")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(fs.Output(), "Unknown format: %v\n", *format)
+		fs.Usage()
+		return errUsage
+	}
+
+	// parse the query first, not to wait for loading the heap dump to know the syntax error.
+	q, err := heapdump.ParseQuery(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	result, err := analyzer.RunQuery(q)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		return result.WriteJSON(os.Stdout)
+	}
+	return result.WriteTable(os.Stdout)
+}
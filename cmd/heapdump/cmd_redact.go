@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"os"
+	"strings"
+)
+
+func runRedact(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("redact", "<hprof>")
+	mode := fs.String("mode", "zero", "`mode` to redact the values: zero or hash")
+	salt := fs.String("salt", "", "key of the hash. Use the same `salt` to compare the redacted dumps. (default: random)")
+	allow := fs.String("allow", "", "comma separated class `patterns` to keep the values, e.g. java.lang.Thread,int[]")
+	output := fs.String("o", "", "write the redacted hprof to the `file`. (required)")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	if *output == "" {
+		fmt.Fprintln(fs.Output(), "-o is required")
+		fs.Usage()
+		return errUsage
+	}
+
+	var redactMode heapdump.RedactMode
+	switch *mode {
+	case "zero":
+		redactMode = heapdump.RedactMode_ZERO
+	case "hash":
+		redactMode = heapdump.RedactMode_HASH
+	default:
+		fmt.Fprintf(fs.Output(), "Unknown mode: %v\n", *mode)
+		fs.Usage()
+		return errUsage
+	}
+	key := []byte(*salt)
+	if *salt == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+	}
+	redactor := heapdump.NewRedactor(redactMode, key)
+	if *allow != "" {
+		for _, s := range strings.Split(*allow, ",") {
+			pattern, err := heapdump.NewClassPattern(strings.TrimSpace(s))
+			if err != nil {
+				return err
+			}
+			redactor.Allow(pattern)
+		}
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := redactor.Redact(fs.Arg(0), f); err != nil {
+		f.Close()
+		os.Remove(*output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	g.logger.Info("Wrote the redacted heap dump: %v", *output)
+	return nil
+}
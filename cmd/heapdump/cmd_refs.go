@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+)
+
+func runRefs(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("refs", "<hprof|index> <objectId>")
+	limit := fs.Int("n", 100, "show first `N` referrers only. 0 means all")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	objectId, err := parseObjectId(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	referrers, err := g.buildReferrers(analyzer)
+	if err != nil {
+		return err
+	}
+	references, err := analyzer.GetIncomingReferences(referrers, objectId)
+	if err != nil {
+		return err
+	}
+
+	w := newTabWriter()
+	fmt.Fprintf(w, "objectId\t  reference\t  class\n")
+	for i, reference := range references {
+		if *limit > 0 && i >= *limit {
+			fmt.Fprintf(w, "...\t  %d more\t\n", len(references)-*limit)
+			break
+		}
+		name, err := analyzer.GetObjectClassName(reference.ReferrerObjectId)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d\t  %s\t  %s\n", reference.ReferrerObjectId, reference.Reference, name)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"net/http"
+	"os"
+)
+
+func runReport(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("report", "<hprof|index>")
+	output := fs.String("o", "", "write the report to the `file`. (default: stdout)")
+	limit := fs.Int("n", 100, "number of the rows in each table")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	rootScanner, err := g.scanRoots(analyzer)
+	if err != nil {
+		return err
+	}
+	report, err := analyzer.NewReport(rootScanner, *limit)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return report.WriteHTML(os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteHTML(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runServe(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("serve", "<hprof|index>")
+	addr := fs.String("addr", "localhost:8080", "listen `address`")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	server, err := heapdump.NewServer(g.logger, analyzer)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Listening on http://%s/\n", *addr)
+	return http.ListenAndServe(*addr, server)
+}
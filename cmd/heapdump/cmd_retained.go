@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+)
+
+func runRetained(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("retained", "<hprof|index>")
+	targetClassName := fs.String("target", "", "Target class `pattern`. Java style name(java.util.Vector), glob(com.example.*) or /regexp/")
+	limit := fs.Int("n", 0, "show top `N` rows only. 0 means all")
+	top := fs.Int("top", 0, "with -target, show the biggest `N` instances with the preview of the fields")
+	previewLength := fs.Int("preview-length", 60, "truncate the preview around `N` characters")
+	packageDepth := fs.Int("package-depth", -1, "roll up the classes by the first `N` elements of the package name. 0 means the whole package name. "+
+		"The retained sizes are on the dominator tree like dominators")
+	ownersPath := fs.String("owners", "", "roll up the classes by the owners in the mapping `file`, which has the lines of the class pattern and the owner. "+
+		"The retained sizes are on the dominator tree like dominators")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	var grouper heapdump.Grouper
+	groupName := ""
+	switch {
+	case *ownersPath != "" && *packageDepth >= 0:
+		fmt.Fprintln(fs.Output(), "-owners and -package-depth can't be used together")
+		fs.Usage()
+		return errUsage
+	case *ownersPath != "":
+		mapping, err := heapdump.LoadOwnerMapping(*ownersPath)
+		if err != nil {
+			return err
+		}
+		grouper = mapping.Owner
+		groupName = "owner"
+	case *packageDepth >= 0:
+		grouper = heapdump.PackageGrouper(*packageDepth)
+		groupName = "package"
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	w := newTabWriter()
+	if grouper != nil {
+		tree, err := g.buildDominatorTree(analyzer)
+		if err != nil {
+			return err
+		}
+		groups, err := analyzer.RetainedSizeByGroup(tree, grouper)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "classes\tcount\tshallowSize\tretainedSize\t  %s\n", groupName)
+		for i, group := range groups {
+			if *limit > 0 && i >= *limit {
+				break
+			}
+			fmt.Fprintf(w, "%d\t%d\t%d\t%d\t  %s\n",
+				group.ClassCount, group.InstanceCount, group.ShallowSize, group.RetainedSize, group.Name)
+		}
+		return w.Flush()
+	}
+
+	if *targetClassName != "" {
+		pattern, err := heapdump.NewClassPattern(*targetClassName)
+		if err != nil {
+			return err
+		}
+
+		tree, err := g.buildDominatorTree(analyzer)
+		if err != nil {
+			return err
+		}
+		result, err := analyzer.RetainedSizeOfInstancesByPattern(tree, pattern)
+		if err != nil {
+			return err
+		}
+		if len(result) == 0 {
+			return fmt.Errorf("no class matches %v", pattern)
+		}
+
+		if *top > 0 {
+			instances, err := analyzer.TopInstances(result, *top)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "objectId\tshallowSize\tretainedSize\t  class\t  preview\n")
+			for _, instance := range instances {
+				preview, err := analyzer.GetObjectPreview(instance.ObjectId, *previewLength)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%d\t%d\t%d\t  %s\t  %s\n",
+					instance.ObjectId, instance.ShallowSize, instance.RetainedSize, instance.ClassName, preview)
+			}
+			return w.Flush()
+		}
+
+		objectIds := make(map[uint64]bool)
+		fmt.Fprintf(w, "classObjectId\tclassLoader\tcount\tretainedSize\t  class\n")
+		for i, sizes := range result {
+			for objectId := range sizes.RetainedSizes {
+				objectIds[objectId] = true
+			}
+			if *limit > 0 && i >= *limit {
+				continue
+			}
+			fmt.Fprintf(w, "%d\t%d\t%d\t%d\t  %s\n",
+				sizes.Class.ClassObjectId, sizes.Class.ClassLoaderObjectId,
+				len(sizes.RetainedSizes), sizes.TotalRetainedSize, sizes.Class.Name)
+		}
+		fmt.Fprintf(w, "total\t\t%d\t%d\t  (%d classes)\n", len(objectIds), tree.TotalRetainedSize(objectIds), len(result))
+		return w.Flush()
+	}
+
+	rootScanner, err := g.scanRoots(analyzer)
+	if err != nil {
+		return err
+	}
+	ranking, err := analyzer.InclusiveRanking(rootScanner)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "count\tshallowSize\tretainedSize\t  class\n")
+	for i := range ranking {
+		if *limit > 0 && i >= *limit {
+			break
+		}
+		row := ranking[len(ranking)-1-i]
+		fmt.Fprintf(w, "%d\t%d\t%d\t  %s\n",
+			row.Class.InstanceCount, row.ShallowSize, row.RetainedSize, row.Class.Name)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// shellAliases are the short names of the commands in the shell.
+var shellAliases = map[string]string{
+	"histo": "histogram",
+	"dom":   "dominators",
+}
+
+// shellExcludedCommands can't be run in the shell.
+var shellExcludedCommands = map[string]bool{
+	"index":   true,
+	"diff":    true,
+	"extract": true,
+	"redact":  true,
+	"serve":   true,
+	"shell":   true,
+	"trend":   true,
+}
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".heapdump_history")
+}
+
+func runShell(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("shell", "<hprof|index>")
+	historyPath := fs.String("history", defaultHistoryPath(), "history `file`. Empty to disable")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	// closed here, since g.close keeps the heap dump of the shell open.
+	defer analyzer.Close()
+	g.shell = &shellSession{path: fs.Arg(0), analyzer: analyzer}
+
+	classNames, err := shellClassNames(analyzer)
+	if err != nil {
+		return err
+	}
+	editor := newLineEditor(*historyPath, func(line []rune, pos int) ([]string, int) {
+		return shellComplete(classNames, line, pos)
+	})
+
+	fmt.Printf("Loaded %s. Type 'help' for the commands, 'exit' to quit.\n", fs.Arg(0))
+	for {
+		line, err := editor.readLine("heapdump> ")
+		if err == errInterrupted {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		editor.addHistory(line)
+
+		words, err := splitShellWords(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			shellHelp()
+			continue
+		}
+		cmd := findShellCommand(words[0])
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "Unknown command: %v. Type 'help' for the commands.\n", words[0])
+			continue
+		}
+		// the commands take the heap dump as the first argument.
+		err = cmd.run(g, append([]string{g.shell.path}, words[1:]...))
+		if err != nil && err != errUsage && err != errFailed {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+}
+
+func findShellCommand(name string) *command {
+	if alias, ok := shellAliases[name]; ok {
+		name = alias
+	}
+	if shellExcludedCommands[name] {
+		return nil
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func shellHelp() {
+	aliases := make(map[string]string)
+	for alias, name := range shellAliases {
+		aliases[name] = alias
+	}
+	fmt.Printf("Commands:\n")
+	for _, c := range commands {
+		if shellExcludedCommands[c.name] {
+			continue
+		}
+		name := c.name
+		if alias, ok := aliases[c.name]; ok {
+			name += " (" + alias + ")"
+		}
+		fmt.Printf("  %-20s %s\n", name, c.description)
+	}
+	fmt.Printf("  %-20s %s\n", "help", "Show this message.")
+	fmt.Printf("  %-20s %s\n", "exit", "Exit the shell.")
+	fmt.Printf("\nRun '<command> -h' for the options. Quote the arguments with spaces, e.g. query \"SELECT ...\".\n")
+}
+
+// shellClassNames returns the Java style names of the classes which have any instances, for the completion.
+func shellClassNames(analyzer *heapdump.HeapDumpAnalyzer) ([]string, error) {
+	histogram, err := analyzer.Histogram()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, entry := range histogram {
+		name := heapdump.ToJavaClassName(entry.Name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// shellComplete completes the command name for the first word, or the class name for the others.
+func shellComplete(classNames []string, line []rune, pos int) ([]string, int) {
+	start := pos
+	for start > 0 && !unicode.IsSpace(line[start-1]) {
+		start--
+	}
+	word := string(line[start:pos])
+
+	var names []string
+	if strings.TrimSpace(string(line[:start])) == "" {
+		names = append(names, "help", "exit")
+		for alias := range shellAliases {
+			names = append(names, alias)
+		}
+		for _, c := range commands {
+			if !shellExcludedCommands[c.name] {
+				names = append(names, c.name)
+			}
+		}
+		sort.Strings(names)
+	} else if strings.HasPrefix(word, "-") {
+		return nil, start
+	} else {
+		names = classNames
+	}
+
+	var candidates []string
+	for _, name := range names {
+		if strings.HasPrefix(name, word) {
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates, start
+}
+
+// splitShellWords splits the line into the words like the shell. Words can be quoted by ' or ".
+func splitShellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote: %c", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
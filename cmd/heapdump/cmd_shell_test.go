@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	for _, test := range []struct {
+		line     string
+		expected []string
+	}{
+		{"", nil},
+		{"  histogram  -n 10 ", []string{"histogram", "-n", "10"}},
+		{`query "SELECT s FROM java.lang.String s"`, []string{"query", "SELECT s FROM java.lang.String s"}},
+		{`query 'a "b" \c'`, []string{"query", `a "b" \c`}},
+		{`a\ b "c\"d" ''`, []string{"a b", `c"d`, ""}},
+		{`x"y z"`, []string{"xy z"}},
+	} {
+		words, err := splitShellWords(test.line)
+		if err != nil || !reflect.DeepEqual(words, test.expected) {
+			t.Errorf("%q should be split into %q. But %q, %v", test.line, test.expected, words, err)
+		}
+	}
+
+	for _, line := range []string{`query "SELECT`, `'abc`} {
+		if _, err := splitShellWords(line); err == nil {
+			t.Errorf("%q should be an error", line)
+		}
+	}
+}
+
+func TestFindShellCommand(t *testing.T) {
+	for name, expected := range map[string]string{
+		"histogram": "histogram",
+		"histo":     "histogram",
+		"dom":       "dominators",
+		"query":     "query",
+	} {
+		if c := findShellCommand(name); c == nil || c.name != expected {
+			t.Errorf("%v should be %v. But %v", name, expected, c)
+		}
+	}
+	for _, name := range []string{"shell", "index", "serve", "unknown", ""} {
+		if c := findShellCommand(name); c != nil {
+			t.Errorf("%v shouldn't be run in the shell. But %v", name, c.name)
+		}
+	}
+	for name := range shellExcludedCommands {
+		found := false
+		for _, c := range commands {
+			if c.name == name {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("The excluded command %v should exist", name)
+		}
+	}
+	for alias, name := range shellAliases {
+		if findShellCommand(name) == nil {
+			t.Errorf("The alias %v should be of the command run in the shell. But %v", alias, name)
+		}
+	}
+}
+
+func TestShellComplete(t *testing.T) {
+	classNames := []string{"java.lang.String", "java.lang.StringBuilder", "java.util.HashMap"}
+	for _, test := range []struct {
+		line       string
+		pos        int
+		candidates []string
+		start      int
+	}{
+		{"hist", 4, []string{"histo", "histogram"}, 0},
+		{"  ex", 4, []string{"exit", "export"}, 2},
+		{"ser", 3, nil, 0},
+		{"retained -target java.lang.Str", 30, []string{"java.lang.String", "java.lang.StringBuilder"}, 17},
+		{"retained -tar", 13, nil, 9},
+		// the cursor is in the middle of the line.
+		{"histogram java.util x", 19, []string{"java.util.HashMap"}, 10},
+	} {
+		candidates, start := shellComplete(classNames, []rune(test.line), test.pos)
+		if !reflect.DeepEqual(candidates, test.candidates) || start != test.start {
+			t.Errorf("%q at %v should be completed by %q at %v. But %q at %v",
+				test.line, test.pos, test.candidates, test.start, candidates, start)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+func runStrings(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("strings", "<hprof|index>")
+	dup := fs.Bool("dup", false, "show the duplicated strings and the wasted size")
+	minLength := fs.Int("min-length", 0, "show the strings longer than or equal to `N` characters only")
+	maxWidth := fs.Int("width", 100, "truncate the strings longer than `N` characters")
+	limit := fs.Int("n", 0, "show top `N` strings only. 0 means all")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	truncate := func(s string) string {
+		runes := []rune(s)
+		if len(runes) > *maxWidth {
+			return strconv.Quote(string(runes[:*maxWidth])) + "..."
+		}
+		return strconv.Quote(s)
+	}
+
+	w := newTabWriter()
+	if *dup {
+		duplicates, err := analyzer.DuplicateStrings()
+		if err != nil {
+			return err
+		}
+		total := uint64(0)
+		fmt.Fprintf(w, "count\twastedSize\t  value\n")
+		n := 0
+		for _, duplicate := range duplicates {
+			if len([]rune(duplicate.Value)) < *minLength {
+				continue
+			}
+			total += duplicate.WastedSize
+			if *limit == 0 || n < *limit {
+				fmt.Fprintf(w, "%d\t%d\t  %s\n",
+					len(duplicate.ObjectIds), duplicate.WastedSize, truncate(duplicate.Value))
+			}
+			n++
+		}
+		fmt.Fprintf(w, "total\t%d\t\n", total)
+		return w.Flush()
+	}
+
+	fmt.Fprintf(w, "objectId\tlength\t  value\n")
+	n := 0
+	err = analyzer.ForEachString(func(objectId uint64, value string) error {
+		length := len([]rune(value))
+		if length < *minLength || (*limit > 0 && n >= *limit) {
+			return nil
+		}
+		n++
+		fmt.Fprintf(w, "%d\t%d\t  %s\n", objectId, length, truncate(value))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+func runSuspects(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("suspects", "<hprof|index>")
+	threshold := fs.Float64("threshold", 10, "report the objects retaining `percent` or more of the heap")
+	format := fs.String("format", "text", "output `format`: text or html")
+	output := fs.String("o", "", "write the report to the `file`. (default: stdout)")
+	limit := fs.Int("n", 20, "number of the classes in the breakdown of each suspect")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "html" {
+		fmt.Fprintf(fs.Output(), "Unknown format: %v\n", *format)
+		fs.Usage()
+		return errUsage
+	}
+
+	analyzer, err := g.open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer g.close(analyzer)
+
+	tree, err := g.buildDominatorTree(analyzer)
+	if err != nil {
+		return err
+	}
+	report, err := analyzer.FindLeakSuspects(tree, *threshold, *limit)
+	if err != nil {
+		return err
+	}
+
+	write := func(w io.Writer) error {
+		if *format == "html" {
+			return report.WriteHTML(w)
+		}
+		return report.WriteText(w)
+	}
+	if *output == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func runTrend(g *globalOptions, args []string) error {
+	fs := g.newFlagSet("trend", "<dir | hprof|index...>")
+	retained := fs.Bool("retained", false, "compare the retained sizes instead of the shallow sizes")
+	csvOutput := fs.Bool("csv", false, "write the size of each class in each heap dump as CSV")
+	limit := fs.Int("n", 20, "show top `N` classes only in the table. 0 means all. -csv writes all the classes")
+	minScore := fs.Float64("min-score", 1, "flag the classes growing in this `ratio` of the steps or more as LEAK")
+	if err := parseArgs(fs, args, -1); err != nil {
+		return err
+	}
+	paths, err := trendPaths(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(paths) < 2 {
+		fmt.Fprintln(fs.Output(), "Two or more heap dumps are required")
+		fs.Usage()
+		return errUsage
+	}
+
+	var samples []heapdump.TrendSample
+	for _, path := range paths {
+		sample, err := g.trendSample(path, *retained)
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		samples = append(samples, sample)
+	}
+	trends := heapdump.Trends(samples, *minScore)
+
+	if *csvOutput {
+		w := csv.NewWriter(os.Stdout)
+		w.Write(append([]string{"class"}, paths...))
+		for _, trend := range trends {
+			record := []string{trend.Name}
+			for _, size := range trend.Sizes {
+				record = append(record, strconv.FormatUint(size, 10))
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	if *limit > 0 && len(trends) > *limit {
+		trends = trends[:*limit]
+	}
+	w := newTabWriter()
+	fmt.Fprintf(w, "growthRate\tscore\tfirst\tlast\ttrend\tstatus\t  class\n")
+	for _, trend := range trends {
+		status := ""
+		if trend.LeakCandidate {
+			status = "LEAK"
+		}
+		fmt.Fprintf(w, "%+.0f\t%.2f\t%d\t%d\t%s\t%s\t  %s\n", trend.GrowthRate, trend.MonotonicScore,
+			trend.Sizes[0], trend.Sizes[len(trend.Sizes)-1], heapdump.Sparkline(trend.Sizes), status, trend.Name)
+	}
+	return w.Flush()
+}
+
+// trendPaths returns the heap dumps in the time order. The directory of the heap dumps is expanded to its hprof files and
+// indexes ordered by the name, e.g. heapdump-20200101.hprof, since the modification time changes by copying them.
+// The hprof file is skipped if its index is in the directory with the same name but the extension, e.g. heapdump-20200101.index.
+func trendPaths(args []string) ([]string, error) {
+	if len(args) != 1 {
+		return args, nil
+	}
+	fi, err := os.Stat(args[0])
+	if err != nil || !fi.IsDir() || heapdump.IsIndex(args[0]) {
+		return args, nil
+	}
+	files, err := ioutil.ReadDir(args[0])
+	if err != nil {
+		return nil, err
+	}
+	indexed := make(map[string]bool)
+	for _, file := range files {
+		if file.IsDir() && heapdump.IsIndex(filepath.Join(args[0], file.Name())) {
+			indexed[trimExt(file.Name())] = true
+		}
+	}
+	// ReadDir returns the files ordered by the name.
+	var paths []string
+	for _, file := range files {
+		path := filepath.Join(args[0], file.Name())
+		if file.IsDir() && indexed[trimExt(file.Name())] ||
+			!file.IsDir() && filepath.Ext(file.Name()) == ".hprof" && !indexed[trimExt(file.Name())] {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+func trimExt(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func (g *globalOptions) trendSample(path string, retained bool) (heapdump.TrendSample, error) {
+	// the -index option can't be shared by the heap dumps.
+	analyzer, err := g.openWithIndex(path, "")
+	if err != nil {
+		return nil, err
+	}
+	defer analyzer.Close()
+
+	if retained {
+		tree, err := g.buildDominatorTree(analyzer)
+		if err != nil {
+			return nil, err
+		}
+		return analyzer.RetainedTrendSample(tree)
+	}
+	histogram, err := analyzer.Histogram()
+	if err != nil {
+		return nil, err
+	}
+	return heapdump.HistogramTrendSample(histogram), nil
+}
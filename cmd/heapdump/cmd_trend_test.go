@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTrendPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// day2.hprof is indexed into day2.index side by side, so only the index is sampled.
+	for _, name := range []string{"day1.hprof", "day2.hprof", "day2.index/CURRENT", "day3.index/CURRENT", "notes.txt", "logs/app.log"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := trendPaths([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "day1.hprof"), filepath.Join(dir, "day2.index"), filepath.Join(dir, "day3.index")}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("The paths should be %v. But %v", expected, paths)
+	}
+
+	// the index directory itself isn't expanded.
+	index := filepath.Join(dir, "day2.index")
+	if paths, err := trendPaths([]string{index}); err != nil || !reflect.DeepEqual(paths, []string{index}) {
+		t.Errorf("The index should be %v. But %v, %v", index, paths, err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// errInterrupted is returned by readLine when Ctrl-C is pressed.
+var errInterrupted = errors.New("interrupted")
+
+const maxHistory = 1000
+
+// lineEditor reads the lines from the terminal with the history and the completion, like readline.
+// If the input isn't a terminal, it just reads the lines.
+type lineEditor struct {
+	in          *os.File
+	reader      *bufio.Reader
+	out         io.Writer
+	history     []string
+	historyPath string
+	// complete returns the candidates of the word ending at pos of the line, and the start position of the word.
+	complete func(line []rune, pos int) ([]string, int)
+}
+
+func newLineEditor(historyPath string, complete func(line []rune, pos int) ([]string, int)) *lineEditor {
+	m := new(lineEditor)
+	m.in = os.Stdin
+	m.reader = bufio.NewReader(os.Stdin)
+	m.out = os.Stdout
+	m.historyPath = historyPath
+	m.complete = complete
+	if historyPath != "" {
+		if data, err := ioutil.ReadFile(historyPath); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if line != "" {
+					m.history = append(m.history, line)
+				}
+			}
+		}
+	}
+	return m
+}
+
+// addHistory adds the line to the history, and appends it to the history file.
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+	if e.historyPath == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// readLine reads a line. It returns io.EOF on Ctrl-D, and errInterrupted on Ctrl-C.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.in.Fd())
+	if err != nil {
+		// not a terminal.
+		fmt.Fprint(e.out, prompt)
+		line, err := e.reader.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	defer restore()
+
+	var line []rune
+	pos := 0
+	historyIndex := len(e.history)
+	// the line being edited is kept while browsing the history.
+	var editing []rune
+	lastKeyIsTab := false
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if n := len(line) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+	setLine := func(s []rune) {
+		line = append([]rune{}, s...)
+		pos = len(line)
+	}
+	fmt.Fprint(e.out, prompt)
+
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		isTab := r == '\t'
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(line)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(line) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			line = line[:pos]
+		case 21: // Ctrl-U
+			line = line[pos:]
+			pos = 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16, 14: // Ctrl-P, Ctrl-N
+			historyIndex, editing = e.moveHistory(r == 16, historyIndex, line, editing, setLine)
+		case '\t':
+			if e.complete == nil {
+				break
+			}
+			candidates, start := e.complete(line, pos)
+			if len(candidates) == 0 {
+				break
+			}
+			prefix := commonPrefix(candidates)
+			if len(candidates) == 1 {
+				prefix += " "
+			}
+			if len([]rune(prefix)) > pos-start {
+				line = append(append(append([]rune{}, line[:start]...), []rune(prefix)...), line[pos:]...)
+				pos = start + len([]rune(prefix))
+			} else if lastKeyIsTab {
+				// show the candidates on the second Tab, like bash.
+				fmt.Fprint(e.out, "\r\n")
+				for _, candidate := range candidates {
+					fmt.Fprintf(e.out, "%s\r\n", candidate)
+				}
+			}
+		case 27: // escape sequences
+			if next, _, err := e.reader.ReadRune(); err != nil || next != '[' && next != 'O' {
+				break
+			}
+			key, _, err := e.reader.ReadRune()
+			if err != nil {
+				break
+			}
+			switch key {
+			case 'A':
+				historyIndex, editing = e.moveHistory(true, historyIndex, line, editing, setLine)
+			case 'B':
+				historyIndex, editing = e.moveHistory(false, historyIndex, line, editing, setLine)
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case '3': // Delete: ESC [ 3 ~
+				e.reader.ReadRune()
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+		lastKeyIsTab = isTab
+		redraw()
+	}
+}
+
+// moveHistory moves to the previous(up) or the next entry of the history.
+func (e *lineEditor) moveHistory(up bool, index int, line []rune, editing []rune, setLine func([]rune)) (int, []rune) {
+	if index == len(e.history) {
+		editing = append([]rune{}, line...)
+	}
+	if up && index > 0 {
+		index--
+	} else if !up && index < len(e.history) {
+		index++
+	} else {
+		return index, editing
+	}
+	if index == len(e.history) {
+		setLine(editing)
+	} else {
+		setLine([]rune(e.history[index]))
+	}
+	return index, editing
+}
+
+func commonPrefix(candidates []string) string {
+	prefix := []rune(candidates[0])
+	for _, candidate := range candidates[1:] {
+		c := []rune(candidate)
+		n := 0
+		for n < len(prefix) && n < len(c) && prefix[n] == c[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
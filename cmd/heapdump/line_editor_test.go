@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLineEditorHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+
+	editor := newLineEditor(path, nil)
+	for _, line := range []string{"histogram", "histogram", " ", "dom -n 10", "histogram"} {
+		editor.addHistory(line)
+	}
+	// the blank lines and the repeated lines are skipped.
+	expected := []string{"histogram", "dom -n 10", "histogram"}
+	if !reflect.DeepEqual(editor.history, expected) {
+		t.Errorf("The history should be %q. But %q", expected, editor.history)
+	}
+	if reloaded := newLineEditor(path, nil); !reflect.DeepEqual(reloaded.history, expected) {
+		t.Errorf("The history should be loaded from the file. But %q", reloaded.history)
+	}
+
+	var line []rune
+	setLine := func(l []rune) {
+		line = l
+	}
+	index, editing := len(editor.history), []rune(nil)
+	for _, test := range []struct {
+		up       bool
+		expected string
+	}{
+		{true, "histogram"},
+		{true, "dom -n 10"},
+		{true, "histogram"},
+		// the oldest entry stays.
+		{true, "histogram"},
+		{false, "dom -n 10"},
+		{false, "histogram"},
+		// back to the line being edited.
+		{false, "quer"},
+		{false, "quer"},
+	} {
+		if index == len(editor.history) {
+			line = []rune("quer")
+		}
+		index, editing = editor.moveHistory(test.up, index, line, editing, setLine)
+		if string(line) != test.expected {
+			t.Errorf("The line should be %q at %v. But %q", test.expected, index, string(line))
+		}
+	}
+}
+
+func TestLineEditorHistoryLimit(t *testing.T) {
+	editor := newLineEditor("", nil)
+	for i := 0; i < maxHistory+10; i++ {
+		editor.addHistory(string(rune('a'+i%26)) + string(rune('a'+i/26%26)))
+	}
+	if len(editor.history) != maxHistory || editor.history[maxHistory-1] != "vm" {
+		t.Errorf("The latest %v lines should be kept. But %v lines, the last is %q",
+			maxHistory, len(editor.history), editor.history[len(editor.history)-1])
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	for _, test := range []struct {
+		candidates []string
+		expected   string
+	}{
+		{[]string{"histo"}, "histo"},
+		{[]string{"histo", "histogram"}, "histo"},
+		{[]string{"java.lang.String", "java.lang.StringBuilder", "java.lang.System"}, "java.lang.S"},
+		{[]string{"exit", "query"}, ""},
+		{[]string{"日本語", "日本"}, "日本"},
+	} {
+		if prefix := commonPrefix(test.candidates); prefix != test.expected {
+			t.Errorf("The common prefix of %q should be %q. But %q", test.candidates, test.expected, prefix)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/inhies/go-bytesize"
+	"github.com/tokuhirom/heapdump"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"syscall"
+)
+
+type command struct {
+	name        string
+	description string
+	run         func(g *globalOptions, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	// initialized here since the commands refer to this list for the usage.
+	commands = []*command{
+		{"index", "Create the index of the heap dump, to open it quickly later.", runIndex},
+		{"histogram", "Show the number of the objects and the shallow size of each class.", runHistogram},
+		{"unreachable", "Show the number of the objects and the shallow size of each class which are unreachable from the GC roots.", runUnreachable},
+		{"reachability", "Show the objects reachable only by the soft, weak, finalizer or phantom references by class.", runReachability},
+		{"retained", "Show the retained size of each class, or the instances of the target class.", runRetained},
+		{"dominators", "Show the biggest objects in the dominator tree.", runDominators},
+		{"paths", "Show the shortest path from the GC roots to the object.", runPaths},
+		{"inspect", "Show the fields of the object.", runInspect},
+		{"refs", "Show the objects which refer the object.", runRefs},
+		{"find", "Show the instances of the classes matching the pattern.", runFind},
+		{"strings", "Show the java/lang/String values, or the duplicated ones.", runStrings},
+		{"query", "Run the query, e.g. SELECT s, @retainedSize FROM java.util.HashMap s WHERE size > 10000.", runQuery},
+		{"export", "Export the heap as the pprof profile, the folded stacks, the SQLite database, NDJSON, or the subgraph as DOT or GraphML.", runExport},
+		{"extract", "Write the new hprof with the objects dominated by or reachable from the object.", runExtract},
+		{"redact", "Write the copy of the hprof with the primitive values redacted, to share it safely.", runRedact},
+		{"diff", "Compare the class histograms of two heap dumps.", runDiff},
+		{"trend", "Show the growth of each class across the heap dumps in time order, to find the slow leaks.", runTrend},
+		{"check", "Check the memory budget rules, e.g. the retained size of the class, and fail on the violations.", runCheck},
+		{"classloaders", "Show the classes, the instances and the retained size of each class loader.", runClassLoaders},
+		{"finalizers", "Show the objects waiting for finalize() by class, and the classes whose pending objects dominate the heap.", runFinalizers},
+		{"suspects", "Find the leak suspects which retain the big part of the heap.", runSuspects},
+		{"report", "Write the HTML report.", runReport},
+		{"serve", "Browse the heap dump on the local web UI.", runServe},
+		{"shell", "Load the heap dump once and run the commands interactively.", runShell},
+	}
+}
+
+// errUsage is returned by the commands when the arguments are wrong. The usage is already shown.
+var errUsage = errors.New("usage error")
+
+// errFailed is returned by the commands when the result is the failure, e.g. the violations of check. The result is already shown.
+// It exits with exitFailed, to tell it from the errors, which exit with 1.
+var errFailed = errors.New("failed")
+
+const exitFailed = 3
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: heapdump [global options] <command> [options] <args>\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-12s %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(out, "\nGlobal options:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nRun 'heapdump <command> -h' for the options of the command.\n")
+}
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+
+	verbose := flag.Bool("v", false, "Verbose")
+	veryVerbose := flag.Bool("vv", false, "Very Verbose")
+	rlimitString := flag.String("rlimit", "4GB", "RLimit")
+	indexPath := flag.String("index", "", "index directory. It's created if it doesn't exist, or created again if the hprof is modified. (default: temporary directory)")
+	memprofile := flag.String("memprofile", "", "write memory profile to `file`")
+	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
+
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for _, c := range commands {
+		if c.name == args[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command: %v\n\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+
+	minLevel := heapdump.LogLevel_INFO
+	if *verbose {
+		minLevel = heapdump.LogLevel_DEBUG
+	}
+	if *veryVerbose {
+		minLevel = heapdump.LogLevel_TRACE
+	}
+
+	rlimitInt, err := bytesize.Parse(*rlimitString)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rLimit syscall.Rlimit
+	err = syscall.Getrlimit(syscall.RLIMIT_AS, &rLimit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rLimit.Cur = uint64(rlimitInt)
+	rLimit.Max = uint64(rlimitInt)
+	err = syscall.Setrlimit(syscall.RLIMIT_AS, &rLimit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := &globalOptions{
+		logger:    heapdump.NewLogger(minLevel),
+		indexPath: *indexPath,
+	}
+	err = cmd.run(g, args[1:])
+
+	if *memprofile != "" {
+		f, err := os.Create(*memprofile)
+		if err != nil {
+			log.Fatal("could not create memory profile: ", err)
+		}
+		defer f.Close()
+		runtime.GC() // get up-to-date statistics
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Fatal("could not write memory profile: ", err)
+		}
+	}
+
+	if err == errUsage {
+		os.Exit(2)
+	}
+	if err == errFailed {
+		os.Exit(exitFailed)
+	}
+	if err != nil {
+		log.Fatalf("An error occurred: %v", err)
+	}
+}
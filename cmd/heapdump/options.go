@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/tokuhirom/heapdump"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type globalOptions struct {
+	logger    *heapdump.Logger
+	indexPath string
+	// shell is the heap dump loaded by the shell command. nil for the other commands.
+	shell *shellSession
+}
+
+// shellSession keeps the heap dump and the results of the expensive analyses among the commands in the shell.
+type shellSession struct {
+	path        string
+	analyzer    *heapdump.HeapDumpAnalyzer
+	rootScanner *heapdump.RootScanner
+	tree        *heapdump.DominatorTree
+	referrers   *heapdump.Referrers
+}
+
+// newFlagSet creates the flag set of the command. argsUsage describes the positional arguments.
+func (g *globalOptions) newFlagSet(name string, argsUsage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		if g.shell != nil {
+			// the heap dump is already loaded in the shell.
+			fmt.Fprintf(out, "Usage: %s [options] %s\n\n", name, strings.TrimSpace(strings.TrimPrefix(argsUsage, "<hprof|index>")))
+		} else {
+			fmt.Fprintf(out, "Usage: heapdump [global options] %s [options] %s\n\n", name, argsUsage)
+		}
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(out, "%s\n\n", c.description)
+			}
+		}
+		fmt.Fprintf(out, "Options:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the options of the command and checks the number of the positional arguments.
+// If nArgs is negative, one or more positional arguments are accepted.
+// Options are accepted after the positional arguments too, e.g. "inspect heapdump.hprof -n 10 123".
+func parseArgs(fs *flag.FlagSet, args []string, nArgs int) error {
+	var positionals []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return errUsage
+		}
+		if consumed := len(args) - fs.NArg(); consumed > 0 && args[consumed-1] == "--" {
+			positionals = append(positionals, fs.Args()...)
+			break
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positionals = append(positionals, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	// set the positional arguments to fs.Args()
+	fs.Parse(append([]string{"--"}, positionals...))
+
+	if (nArgs < 0 && fs.NArg() == 0) || (nArgs >= 0 && fs.NArg() != nArgs) {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// open opens the heap dump file or the index directory.
+// If the -index option is given, the index is reused if it exists, or created otherwise.
+// In the shell, it returns the loaded heap dump. Close it by g.close.
+func (g *globalOptions) open(path string) (*heapdump.HeapDumpAnalyzer, error) {
+	if g.shell != nil && path == g.shell.path {
+		return g.shell.analyzer, nil
+	}
+	return g.openWithIndex(path, g.indexPath)
+}
+
+// close closes the heap dump opened by g.open. The heap dump of the shell is kept open.
+func (g *globalOptions) close(analyzer *heapdump.HeapDumpAnalyzer) {
+	if g.shell != nil && analyzer == g.shell.analyzer {
+		return
+	}
+	if err := analyzer.Close(); err != nil {
+		g.logger.Warn("cannot close the heap dump: %v", err)
+	}
+}
+
+func (g *globalOptions) openWithIndex(path string, indexPath string) (*heapdump.HeapDumpAnalyzer, error) {
+	start := time.Now()
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		indexPath = path
+	}
+
+	if _, err := os.Stat(indexPath); indexPath != "" && err == nil {
+		analyzer, err := heapdump.OpenIndex(g.logger, indexPath)
+		if err != nil {
+			return nil, err
+		}
+		// the heap dump may be overwritten after indexing, e.g. by jmap with the same file name.
+		upToDate := true
+		if indexPath != path {
+			upToDate, err = analyzer.IsIndexOf(path)
+			// the index can be used without the heap dump file.
+			if os.IsNotExist(err) {
+				upToDate, err = true, nil
+			}
+			if err != nil {
+				analyzer.Close()
+				return nil, err
+			}
+		}
+		if upToDate {
+			g.logger.Info("Loaded index in %s.", time.Since(start))
+			return analyzer, nil
+		}
+		analyzer.Close()
+		g.logger.Info("%v is modified after indexing. Creating the index %v again.", path, indexPath)
+		if err := os.RemoveAll(indexPath); err != nil {
+			return nil, err
+		}
+		start = time.Now()
+	}
+
+	analyzer, err := heapdump.Open(g.logger, path, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	g.logger.Info("Read heap dump file in %s.", time.Since(start))
+	return analyzer, nil
+}
+
+func (g *globalOptions) scanRoots(analyzer *heapdump.HeapDumpAnalyzer) (*heapdump.RootScanner, error) {
+	if g.shell != nil && analyzer == g.shell.analyzer && g.shell.rootScanner != nil {
+		return g.shell.rootScanner, nil
+	}
+	start := time.Now()
+	rootScanner := heapdump.NewRootScanner(g.logger)
+	if err := rootScanner.ScanAll(analyzer); err != nil {
+		return nil, fmt.Errorf("error in scanning root: %v", err)
+	}
+	g.logger.Info("Scanned retained root in %s.", time.Since(start))
+	if g.shell != nil && analyzer == g.shell.analyzer {
+		g.shell.rootScanner = rootScanner
+	}
+	return rootScanner, nil
+}
+
+func (g *globalOptions) buildDominatorTree(analyzer *heapdump.HeapDumpAnalyzer) (*heapdump.DominatorTree, error) {
+	if g.shell != nil && analyzer == g.shell.analyzer && g.shell.tree != nil {
+		return g.shell.tree, nil
+	}
+	start := time.Now()
+	tree, err := analyzer.BuildDominatorTree()
+	if err != nil {
+		return nil, err
+	}
+	g.logger.Info("Built dominator tree of %d objects in %s.", tree.Size(), time.Since(start))
+	if g.shell != nil && analyzer == g.shell.analyzer {
+		g.shell.tree = tree
+	}
+	return tree, nil
+}
+
+func (g *globalOptions) buildReferrers(analyzer *heapdump.HeapDumpAnalyzer) (*heapdump.Referrers, error) {
+	if g.shell != nil && analyzer == g.shell.analyzer && g.shell.referrers != nil {
+		return g.shell.referrers, nil
+	}
+	start := time.Now()
+	referrers, err := analyzer.BuildReferrers()
+	if err != nil {
+		return nil, err
+	}
+	g.logger.Info("Built index of the incoming references in %s.", time.Since(start))
+	if g.shell != nil && analyzer == g.shell.analyzer {
+		g.shell.referrers = referrers
+	}
+	return referrers, nil
+}
+
+// parseObjectId parses the object ID in decimal, or in hexadecimal with the 0x prefix.
+func parseObjectId(s string) (uint64, error) {
+	objectId, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid object ID: %v", s)
+	}
+	return objectId, nil
+}
+
+func newTabWriter() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+}
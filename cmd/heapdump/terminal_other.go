@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// makeRaw isn't supported on this platform. The shell reads the lines without the line editing.
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, errors.New("line editing is not supported")
+}
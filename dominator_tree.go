@@ -0,0 +1,336 @@
+package heapdump
+
+import (
+	"sort"
+)
+
+// DominatorTree is the dominator tree of the objects strongly reachable from the GC roots.
+// The referents of the references like WeakReference are not retained by them. See ScanReachability for them.
+// The GC roots are dominated by the virtual root, whose object ID is 0.
+//
+// It's computed by the iterative algorithm described in
+// "A Simple, Fast Dominance Algorithm"(Cooper, Harvey and Kennedy).
+type DominatorTree struct {
+	logger        *Logger
+	objectIds     []uint64       // index -> object ID. index 0 is the virtual root.
+	indexes       map[uint64]int // object ID -> index
+	idoms         []int          // index -> index of the immediate dominator
+	shallowSizes  []uint64
+	retainedSizes []uint64
+	children      [][]int
+	// strength is the weakest reference to follow.
+	strength ReferenceStrength
+}
+
+func NewDominatorTree(logger *Logger, hprof *HProf, softSizeCalculator *SoftSizeCalculator) (*DominatorTree, error) {
+	return newDominatorTree(logger, hprof, softSizeCalculator, ReferenceStrength_STRONG)
+}
+
+func newDominatorTree(logger *Logger, hprof *HProf, softSizeCalculator *SoftSizeCalculator, strength ReferenceStrength) (*DominatorTree, error) {
+	m := new(DominatorTree)
+	m.logger = logger
+	m.strength = strength
+	m.objectIds = []uint64{0}
+	m.indexes = map[uint64]int{0: 0}
+
+	successors, postOrder, err := m.traverse(hprof)
+	if err != nil {
+		return nil, err
+	}
+	m.logger.Debug("DominatorTree: %v reachable objects", len(m.objectIds)-1)
+
+	m.computeIdoms(successors, postOrder)
+
+	m.shallowSizes = make([]uint64, len(m.objectIds))
+	m.retainedSizes = make([]uint64, len(m.objectIds))
+	m.children = make([][]int, len(m.objectIds))
+	for i := 1; i < len(m.objectIds); i++ {
+		size, err := softSizeCalculator.CalcSoftSizeByObjectId(hprof, m.objectIds[i])
+		if err != nil {
+			return nil, err
+		}
+		m.shallowSizes[i] = uint64(size)
+		m.retainedSizes[i] = uint64(size)
+	}
+	// The immediate dominator always comes after the node in the post order.
+	for _, node := range postOrder {
+		if node == 0 {
+			continue
+		}
+		idom := m.idoms[node]
+		m.retainedSizes[idom] += m.retainedSizes[node]
+		m.children[idom] = append(m.children[idom], node)
+	}
+	for _, children := range m.children {
+		sort.Slice(children, func(i, j int) bool {
+			return m.retainedSizes[children[i]] > m.retainedSizes[children[j]]
+		})
+	}
+	return m, nil
+}
+
+// traverse visits the objects strongly reachable from the GC roots in depth first order.
+func (t *DominatorTree) traverse(hprof *HProf) ([][]int, []int, error) {
+	var successors [][]int
+	addNode := func(objectId uint64) (int, error) {
+		if index, ok := t.indexes[objectId]; ok {
+			return index, nil
+		}
+		ok, err := hprof.HasObject(objectId)
+		if err != nil || !ok {
+			return -1, err
+		}
+		index := len(t.objectIds)
+		t.objectIds = append(t.objectIds, objectId)
+		t.indexes[objectId] = index
+		return index, nil
+	}
+	getSuccessors := func(node int) ([]int, error) {
+		var result []int
+		addSuccessor := func(objectId uint64) error {
+			index, err := addNode(objectId)
+			if err != nil {
+				return err
+			}
+			if index >= 0 {
+				result = append(result, index)
+			}
+			return nil
+		}
+		if node == 0 {
+			for _, objectId := range hprof.RootObjectIds() {
+				if err := addSuccessor(objectId); err != nil {
+					return nil, err
+				}
+			}
+			return result, nil
+		}
+		err := hprof.ForEachReference(t.objectIds[node], func(ref *Reference) error {
+			if ref.Strength > t.strength {
+				return nil
+			}
+			return addSuccessor(ref.ObjectId)
+		})
+		return result, err
+	}
+
+	type frame struct {
+		node int
+		next int
+	}
+	visited := map[int]bool{0: true}
+	var postOrder []int
+	rootSuccessors, err := getSuccessors(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	successors = append(successors, rootSuccessors)
+	stack := []*frame{{node: 0}}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.next < len(successors[top.node]) {
+			child := successors[top.node][top.next]
+			top.next++
+			if visited[child] {
+				continue
+			}
+			visited[child] = true
+			childSuccessors, err := getSuccessors(child)
+			if err != nil {
+				return nil, nil, err
+			}
+			// nodes are numbered in discovery order.
+			for len(successors) <= child {
+				successors = append(successors, nil)
+			}
+			successors[child] = childSuccessors
+			stack = append(stack, &frame{node: child})
+		} else {
+			postOrder = append(postOrder, top.node)
+			stack = stack[:len(stack)-1]
+		}
+	}
+	for len(successors) < len(t.objectIds) {
+		successors = append(successors, nil)
+	}
+	return successors, postOrder, nil
+}
+
+func (t *DominatorTree) computeIdoms(successors [][]int, postOrder []int) {
+	postOrderNumbers := make([]int, len(t.objectIds))
+	for i, node := range postOrder {
+		postOrderNumbers[node] = i
+	}
+	predecessors := make([][]int, len(t.objectIds))
+	for node, succs := range successors {
+		for _, succ := range succs {
+			predecessors[succ] = append(predecessors[succ], node)
+		}
+	}
+
+	t.idoms = make([]int, len(t.objectIds))
+	for i := range t.idoms {
+		t.idoms[i] = -1
+	}
+	t.idoms[0] = 0
+
+	intersect := func(a, b int) int {
+		for a != b {
+			for postOrderNumbers[a] < postOrderNumbers[b] {
+				a = t.idoms[a]
+			}
+			for postOrderNumbers[b] < postOrderNumbers[a] {
+				b = t.idoms[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		// reverse post order, skipping the virtual root.
+		for i := len(postOrder) - 2; i >= 0; i-- {
+			node := postOrder[i]
+			newIdom := -1
+			for _, pred := range predecessors[node] {
+				if t.idoms[pred] == -1 {
+					continue
+				}
+				if newIdom == -1 {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+			if t.idoms[node] != newIdom {
+				t.idoms[node] = newIdom
+				changed = true
+			}
+		}
+	}
+}
+
+// Size returns the number of the objects reachable from the GC roots.
+func (t *DominatorTree) Size() int {
+	return len(t.objectIds) - 1
+}
+
+// Contains returns true if the object is reachable from the GC roots.
+func (t *DominatorTree) Contains(objectId uint64) bool {
+	_, ok := t.indexes[objectId]
+	return ok
+}
+
+// ImmediateDominator returns the object ID of the immediate dominator.
+// It returns 0 for the objects dominated by the virtual root only.
+func (t *DominatorTree) ImmediateDominator(objectId uint64) (uint64, bool) {
+	index, ok := t.indexes[objectId]
+	if !ok || index == 0 {
+		return 0, false
+	}
+	return t.objectIds[t.idoms[index]], true
+}
+
+// Children returns the object IDs immediately dominated by the object, ordered by the retained size.
+// Pass 0 to get the objects dominated by the virtual root.
+func (t *DominatorTree) Children(objectId uint64) []uint64 {
+	index, ok := t.indexes[objectId]
+	if !ok {
+		return nil
+	}
+	var result []uint64
+	for _, child := range t.children[index] {
+		result = append(result, t.objectIds[child])
+	}
+	return result
+}
+
+// RetainedSize returns the total shallow size of the objects dominated by the object, including itself.
+func (t *DominatorTree) RetainedSize(objectId uint64) uint64 {
+	index, ok := t.indexes[objectId]
+	if !ok {
+		return 0
+	}
+	return t.retainedSizes[index]
+}
+
+func (t *DominatorTree) ShallowSize(objectId uint64) uint64 {
+	index, ok := t.indexes[objectId]
+	if !ok {
+		return 0
+	}
+	return t.shallowSizes[index]
+}
+
+// WalkDominated visits the objects dominated by the objects, including themselves, in depth first order. Pass
+// Children(0) to walk the whole tree. keys returns the distinct keys of each object, e.g. its class name, and visit is
+// called for each of them. outermost is false if another object of the same key dominates the object, since its retained
+// size is a part of the retained size of the outer one. Sum up the retained sizes of the outermost objects only, not
+// to count the nested objects twice.
+func (t *DominatorTree) WalkDominated(objectIds []uint64, keys func(objectId uint64) ([]interface{}, error),
+	visit func(objectId uint64, key interface{}, outermost bool)) error {
+	// the number of the objects of each key on the current path.
+	active := make(map[interface{}]int)
+
+	type frame struct {
+		objectId uint64
+		keys     []interface{}
+		exiting  bool
+	}
+	var stack []*frame
+	for i := len(objectIds) - 1; i >= 0; i-- {
+		stack = append(stack, &frame{objectId: objectIds[i]})
+	}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if f.exiting {
+			for _, key := range f.keys {
+				active[key]--
+			}
+			continue
+		}
+
+		var err error
+		if f.keys, err = keys(f.objectId); err != nil {
+			return err
+		}
+		for _, key := range f.keys {
+			visit(f.objectId, key, active[key] == 0)
+			active[key]++
+		}
+
+		f.exiting = true
+		stack = append(stack, f)
+		children := t.Children(f.objectId)
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, &frame{objectId: children[i]})
+		}
+	}
+	return nil
+}
+
+// TotalRetainedSize returns the retained size of the objects together, summing up the outermost ones by WalkDominated.
+func (t *DominatorTree) TotalRetainedSize(objectIds map[uint64]bool) uint64 {
+	total := uint64(0)
+	// the keys never fail.
+	_ = t.WalkDominated(t.Children(0), func(objectId uint64) ([]interface{}, error) {
+		if objectIds[objectId] {
+			return []interface{}{true}, nil
+		}
+		return nil, nil
+	}, func(objectId uint64, key interface{}, outermost bool) {
+		if outermost {
+			total += t.RetainedSize(objectId)
+		}
+	})
+	return total
+}
+
+// Percentage returns n in percent of total, e.g. the retained size of an object in the heap. It's 0 if total is 0.
+func Percentage(n uint64, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
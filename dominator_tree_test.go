@@ -0,0 +1,93 @@
+package heapdump
+
+import (
+	"testing"
+)
+
+func testDominatorTree(t *testing.T, path string, expected map[string]uint64) {
+	tester := NewTester(path, t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for targetClass, expectedRetainedSize := range expected {
+		objectId := tester.FindInstance(targetClass)
+		if !tree.Contains(objectId) {
+			t.Fatalf("%v should be reachable", targetClass)
+		}
+		if size := tree.RetainedSize(objectId); size != expectedRetainedSize {
+			t.Fatalf("%v instance should be %v bytes. But %v",
+				targetClass, expectedRetainedSize, size)
+		}
+	}
+}
+
+func TestDominatorTreeObject(t *testing.T) {
+	testDominatorTree(t, "testdata/object/heapdump.hprof", map[string]uint64{
+		"Object1": 66,
+		"Object2": 42,
+	})
+}
+
+func TestDominatorTreeRecursion(t *testing.T) {
+	testDominatorTree(t, "testdata/recursion/heapdump.hprof", map[string]uint64{
+		"Object1": 48,
+		"Object2": 24,
+	})
+}
+
+func TestDominatorTreeChildren(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	object1 := tester.FindInstance("Object1")
+	object2 := tester.FindInstance("Object2")
+	children := tree.Children(object1)
+	if len(children) != 1 || children[0] != object2 {
+		t.Fatalf("Object1 should dominate Object2 only. But %v", children)
+	}
+	if idom, ok := tree.ImmediateDominator(object2); !ok || idom != object1 {
+		t.Fatalf("The immediate dominator of Object2 should be Object1. But %v", idom)
+	}
+	if size := tree.TotalRetainedSize(map[uint64]bool{object1: true, object2: true}); size != 66 {
+		t.Fatalf("Object1 and Object2 should retain 66 bytes, counting Object2 once. But %v", size)
+	}
+}
+
+func TestDominatorTreeWalkDominated(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	object1 := tester.FindInstance("Object1")
+	object2 := tester.FindInstance("Object2")
+	outermost := make(map[interface{}]uint64)
+	visits := 0
+	err = tree.WalkDominated([]uint64{object1}, func(objectId uint64) ([]interface{}, error) {
+		if objectId == object1 || objectId == object2 {
+			return []interface{}{"Object", objectId}, nil
+		}
+		return nil, nil
+	}, func(objectId uint64, key interface{}, isOutermost bool) {
+		visits++
+		if isOutermost {
+			outermost[key] += tree.RetainedSize(objectId)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Object2 is dominated by Object1, so it's outermost by its own key only.
+	if visits != 4 || len(outermost) != 3 || outermost["Object"] != 66 || outermost[object1] != 66 || outermost[object2] != 42 {
+		t.Errorf("Object1 and Object2 should be visited with the outermost Object1. But %v visits, %v", visits, outermost)
+	}
+}
@@ -0,0 +1,437 @@
+package heapdump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+type ExtractMode int
+
+const (
+	// ExtractMode_DOMINATED extracts the objects dominated by the start objects, i.e. the objects retained by them.
+	ExtractMode_DOMINATED ExtractMode = iota
+	// ExtractMode_REACHABLE extracts all the objects reachable from the start objects.
+	ExtractMode_REACHABLE
+)
+
+// maxHeapDumpSegmentSize is the size to split the heap dump segments written by WriteSubHeap.
+const maxHeapDumpSegmentSize = 1 << 20
+
+// SelectSubHeap returns the objects to extract from the start objects, including themselves.
+func (a HeapDumpAnalyzer) SelectSubHeap(tree *DominatorTree, startObjectIds []uint64, mode ExtractMode) (map[uint64]bool, error) {
+	selected := make(map[uint64]bool)
+	queue := make([]uint64, 0, len(startObjectIds))
+	for _, objectId := range startObjectIds {
+		exists, err := a.hprof.HasObject(objectId)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("object %v is not found", objectId)
+		}
+		if !selected[objectId] {
+			selected[objectId] = true
+			queue = append(queue, objectId)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if mode == ExtractMode_DOMINATED {
+			for _, child := range tree.Children(current) {
+				if !selected[child] {
+					selected[child] = true
+					queue = append(queue, child)
+				}
+			}
+			continue
+		}
+		err := a.hprof.ForEachReference(current, func(ref *Reference) error {
+			if selected[ref.ObjectId] {
+				return nil
+			}
+			exists, err := a.hprof.HasObject(ref.ObjectId)
+			if err != nil || !exists {
+				return err
+			}
+			selected[ref.ObjectId] = true
+			queue = append(queue, ref.ObjectId)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return selected, nil
+}
+
+// WriteSubHeap reads the hprof file of the heap dump, and writes the new hprof which has the objects only, with
+// the class dumps, the LOAD_CLASS records and the strings needed by them. The stack traces are kept as they are.
+// The start objects are written as the JNI global roots, so they and the objects referred by them are reachable
+// in the new heap dump.
+func (a HeapDumpAnalyzer) WriteSubHeap(hprofPath string, w io.Writer, objectIds map[uint64]bool, startObjectIds []uint64) error {
+	classes := make(map[uint64]bool)
+	names := make(map[uint64]bool)
+	addClass := func(classObjectId uint64) error {
+		for classObjectId != 0 && !classes[classObjectId] {
+			classDump, err := a.hprof.GetClassDumpByClassObjectId(classObjectId)
+			if err != nil {
+				return err
+			}
+			if classDump == nil {
+				return nil
+			}
+			classes[classObjectId] = true
+			nameId, err := a.hprof.GetClassNameIdByClassObjectId(classObjectId)
+			if err != nil {
+				return err
+			}
+			names[nameId] = true
+			for _, field := range classDump.InstanceFields {
+				names[field.NameId] = true
+			}
+			for _, field := range classDump.StaticFields {
+				names[field.NameId] = true
+			}
+			classObjectId = classDump.SuperClassObjectId
+		}
+		return nil
+	}
+	for objectId := range objectIds {
+		classObjectId := objectId
+		if instanceDump, ok := a.hprof.objectId2instanceDump[objectId]; ok {
+			classObjectId = instanceDump.ClassObjectId
+		} else if arrayDump, ok := a.hprof.arrayObjectId2objectArrayDump[objectId]; ok {
+			classObjectId = arrayDump.ArrayClassObjectId
+		} else if _, ok := a.hprof.arrayObjectId2primitiveArrayDump[objectId]; ok {
+			continue
+		}
+		if err := addClass(classObjectId); err != nil {
+			return err
+		}
+	}
+
+	var roots []uint64
+	for _, objectId := range startObjectIds {
+		if len(a.hprof.GetRootKinds(objectId)) == 0 {
+			roots = append(roots, objectId)
+		}
+	}
+
+	// The first pass collects the names of the stack frames, which are written before the frames.
+	for _, collectFrames := range []bool{true, false} {
+		f, err := os.Open(hprofPath)
+		if err != nil {
+			return err
+		}
+		out := ioutil.Discard
+		if !collectFrames {
+			out = w
+		}
+		s := &subHeapWriter{
+			r:             bufio.NewReaderSize(f, 1024*1024),
+			w:             bufio.NewWriterSize(out, 1024*1024),
+			collectFrames: collectFrames,
+			objectIds:     objectIds,
+			classes:       classes,
+			names:         names,
+			roots:         roots,
+		}
+		err = s.run()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subHeapWriter copies the records of the hprof file, filtering the objects.
+type subHeapWriter struct {
+	r      *bufio.Reader
+	w      *bufio.Writer
+	idSize int
+	// collectFrames is true to collect the names of the frames into names, without parsing the heap dump.
+	collectFrames bool
+	objectIds     map[uint64]bool
+	classes       map[uint64]bool
+	names         map[uint64]bool
+	// roots are the start objects to be written as the JNI global roots.
+	roots []uint64
+	// segment is the heap dump sub records to be written.
+	segment     bytes.Buffer
+	heapDumpEnd bool
+}
+
+func (s *subHeapWriter) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s *subHeapWriter) id(b []byte) uint64 {
+	return decodeUint(b[:s.idSize])
+}
+
+func (s *subHeapWriter) writeRecord(tag byte, body []byte) error {
+	var header [9]byte
+	header[0] = tag
+	binary.BigEndian.PutUint32(header[5:], uint32(len(body)))
+	if _, err := s.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := s.w.Write(body)
+	return err
+}
+
+// flushSegment writes the pending sub records as a heap dump segment.
+func (s *subHeapWriter) flushSegment() error {
+	for _, objectId := range s.roots {
+		s.segment.WriteByte(byte(parser.HProfHDRecordTypeRootJNIGlobal))
+		s.writeId(&s.segment, objectId)
+		s.writeId(&s.segment, objectId)
+	}
+	s.roots = nil
+	if s.segment.Len() == 0 {
+		return nil
+	}
+	err := s.writeRecord(byte(parser.HProfRecordTypeHeapDumpSegment), s.segment.Bytes())
+	s.segment.Reset()
+	return err
+}
+
+func (s *subHeapWriter) writeId(b *bytes.Buffer, id uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], id)
+	b.Write(buf[8-s.idSize:])
+}
+
+func (s *subHeapWriter) run() error {
+	header, err := s.r.ReadBytes(0)
+	if err != nil {
+		return fmt.Errorf("invalid hprof header: %v", err)
+	}
+	rest, err := s.read(12)
+	if err != nil {
+		return err
+	}
+	s.idSize = int(binary.BigEndian.Uint32(rest[:4]))
+	if s.idSize != 4 && s.idSize != 8 {
+		return fmt.Errorf("unsupported identifier size: %d", s.idSize)
+	}
+	if _, err := s.w.Write(append(header, rest...)); err != nil {
+		return err
+	}
+
+	heapDump := false
+	for {
+		tag, err := s.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		recordHeader, err := s.read(8)
+		if err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(recordHeader[4:]))
+
+		switch parser.HProfRecordType(tag) {
+		case parser.HProfRecordTypeHeapDump, parser.HProfRecordTypeHeapDumpSegment:
+			heapDump = true
+			if !s.collectFrames {
+				if err := s.heapDump(length); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := s.r.Discard(int(length)); err != nil {
+				return err
+			}
+			continue
+		case parser.HProfRecordTypeHeapDumpEnd:
+			if _, err := s.r.Discard(int(length)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		body, err := s.read(int(length))
+		if err != nil {
+			return err
+		}
+		switch parser.HProfRecordType(tag) {
+		case parser.HProfRecordTypeUTF8:
+			if !s.names[s.id(body)] {
+				continue
+			}
+		case parser.HProfRecordTypeLoadClass:
+			if !s.classes[s.id(body[4:])] {
+				continue
+			}
+		case parser.HProfRecordTypeFrame:
+			// method name, signature and source file
+			for i := 1; i <= 3; i++ {
+				s.names[s.id(body[s.idSize*i:])] = true
+			}
+		}
+		if err := s.writeRecord(tag, body); err != nil {
+			return err
+		}
+	}
+	if heapDump && !s.collectFrames {
+		if err := s.flushSegment(); err != nil {
+			return err
+		}
+		if err := s.writeRecord(byte(parser.HProfRecordTypeHeapDumpEnd), nil); err != nil {
+			return err
+		}
+	}
+	return s.w.Flush()
+}
+
+// heapDump filters the sub records of the heap dump record.
+func (s *subHeapWriter) heapDump(length int64) error {
+	for length > 0 {
+		record, err := s.readSubRecord()
+		if err != nil {
+			return err
+		}
+		length -= int64(len(record))
+		if !s.keep(record) {
+			continue
+		}
+		if s.segment.Len()+len(record) > maxHeapDumpSegmentSize {
+			if err := s.flushSegment(); err != nil {
+				return err
+			}
+		}
+		s.segment.Write(record)
+	}
+	if length < 0 {
+		return fmt.Errorf("heap dump record overruns its length")
+	}
+	return nil
+}
+
+func (s *subHeapWriter) keep(record []byte) bool {
+	objectId := s.id(record[1:])
+	if parser.HProfHDRecordType(record[0]) == parser.HProfHDRecordTypeClassDump {
+		return s.classes[objectId]
+	}
+	return s.objectIds[objectId]
+}
+
+// readSubRecord reads a heap dump sub record, including the tag.
+func (s *subHeapWriter) readSubRecord() ([]byte, error) {
+	var record []byte
+	next := func(n int) ([]byte, error) {
+		b, err := s.read(n)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, b...)
+		return b, nil
+	}
+	valueSize := func(valueType hprofdata.HProfValueType) (int, error) {
+		if valueType == hprofdata.HProfValueType_OBJECT {
+			return s.idSize, nil
+		}
+		size, ok := parser.ValueSize[valueType]
+		if !ok {
+			return 0, fmt.Errorf("unknown value type: %d", valueType)
+		}
+		return size, nil
+	}
+
+	tag, err := next(1)
+	if err != nil {
+		return nil, err
+	}
+	idSize := s.idSize
+	switch parser.HProfHDRecordType(tag[0]) {
+	case parser.HProfHDRecordTypeRootUnknown, parser.HProfHDRecordTypeRootStickyClass, parser.HProfHDRecordTypeRootMonitorUsed:
+		_, err = next(idSize)
+	case parser.HProfHDRecordTypeRootJNIGlobal:
+		_, err = next(idSize * 2)
+	case parser.HProfHDRecordTypeRootJNILocal, parser.HProfHDRecordTypeRootJavaFrame, parser.HProfHDRecordTypeRootThreadObj:
+		_, err = next(idSize + 8)
+	case parser.HProfHDRecordTypeRootNativeStack, parser.HProfHDRecordTypeRootThreadBlock:
+		_, err = next(idSize + 4)
+	case parser.HProfHDRecordTypeClassDump:
+		// class, stack trace serial number, super class, class loader, signers, protection domain, reserved * 2
+		// and instance size
+		if _, err := next(idSize*7 + 8); err != nil {
+			return nil, err
+		}
+		// constant pool, static fields and instance fields
+		for _, entrySize := range []int{2, idSize, -1} {
+			count, err := next(2)
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < int(binary.BigEndian.Uint16(count)); i++ {
+				if entrySize < 0 {
+					// instance field name and type
+					if _, err := next(idSize + 1); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				t, err := next(entrySize + 1)
+				if err != nil {
+					return nil, err
+				}
+				size, err := valueSize(hprofdata.HProfValueType(t[entrySize]))
+				if err != nil {
+					return nil, err
+				}
+				if _, err := next(size); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case parser.HProfHDRecordTypeInstanceDump:
+		b, err := next(idSize*2 + 8)
+		if err != nil {
+			return nil, err
+		}
+		_, err = next(int(binary.BigEndian.Uint32(b[idSize*2+4:])))
+	case parser.HProfHDRecordTypeObjectArrayDump:
+		b, err := next(idSize + 8)
+		if err != nil {
+			return nil, err
+		}
+		_, err = next(idSize * (int(binary.BigEndian.Uint32(b[idSize+4:])) + 1))
+	case parser.HProfHDRecordTypePrimitiveArrayDump:
+		b, err := next(idSize + 9)
+		if err != nil {
+			return nil, err
+		}
+		size, err := valueSize(hprofdata.HProfValueType(b[idSize+8]))
+		if err != nil {
+			return nil, err
+		}
+		_, err = next(size * int(binary.BigEndian.Uint32(b[idSize+4:])))
+	default:
+		return nil, fmt.Errorf("unsupported heap dump record type: 0x%x", tag[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,81 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func extractTestData(t *testing.T, path string, targetClass string, mode ExtractMode) *Tester {
+	tester := NewTester(path, t)
+	defer tester.Close()
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	startObjectIds := []uint64{tester.FindInstance(targetClass)}
+	objectIds, err := tester.analyzer.SelectSubHeap(tree, startObjectIds, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the heap dump is read into the index by NewTester.
+	defer os.RemoveAll(dir)
+	extractedPath := filepath.Join(dir, "heapdump.hprof")
+	f, err := os.Create(extractedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tester.analyzer.WriteSubHeap(path, f, objectIds, startObjectIds); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return NewTester(extractedPath, t)
+}
+
+// classNames returns the names of all the class dumps, including the classes without any instances.
+func (a *Tester) classNames() map[string]bool {
+	names := make(map[string]bool)
+	err := a.analyzer.hprof.ForEachClassDump(func(classDump *hprofdata.HProfClassDump) error {
+		name, err := a.analyzer.hprof.GetClassNameByClassObjectId(classDump.ClassObjectId)
+		names[name] = true
+		return err
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return names
+}
+
+func TestExtractDominated(t *testing.T) {
+	tester := extractTestData(t, "testdata/object/heapdump.hprof", "Object1", ExtractMode_DOMINATED)
+	defer tester.Close()
+
+	tester.AssertSize("Object1", 66)
+	tester.AssertSize("Object2", 42)
+	names := tester.classNames()
+	if names["TestData"] {
+		t.Errorf("The class referring Object1 should not be extracted.")
+	}
+	if !names["java/lang/Object"] {
+		t.Errorf("The super class should be extracted.")
+	}
+}
+
+func TestExtractReachable(t *testing.T) {
+	tester := extractTestData(t, "testdata/object/heapdump.hprof", "Object2", ExtractMode_REACHABLE)
+	defer tester.Close()
+
+	tester.AssertSize("Object2", 42)
+	if names := tester.classNames(); names["Object1"] {
+		t.Errorf("Object1 is not reachable from Object2.")
+	}
+}
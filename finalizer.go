@@ -0,0 +1,189 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"sort"
+)
+
+// FinalizerClass is the objects of a class with finalize(), which are registered to java/lang/ref/Finalizer.
+type FinalizerClass struct {
+	ClassName string
+	// Count is the number of the registered objects, including the live ones.
+	Count int
+	// PendingCount is the number of the objects waiting for finalize() to be called, i.e. not strongly or softly reachable.
+	PendingCount int
+	// ShallowSize and RetainedSize are of the pending objects. See DominatorTree.WalkDominated.
+	ShallowSize  uint64
+	RetainedSize uint64
+	Percentage   float64
+	// Dominant is true if the pending objects retain thresholdPct percent of the heap or more.
+	Dominant bool
+}
+
+// FinalizerReport is the result of FindPendingFinalizers.
+type FinalizerReport struct {
+	// FinalizerCount is the number of the java/lang/ref/Finalizer instances in the queue with the referent.
+	FinalizerCount int
+	PendingCount   int
+	// RetainedSize is retained by all the pending objects.
+	RetainedSize uint64
+	// TotalSize is the size of the heap, including the objects waiting for the finalization.
+	TotalSize    uint64
+	ThresholdPct float64
+	// Classes are ordered by the retained size of the pending objects descending, and by the count.
+	Classes []*FinalizerClass
+}
+
+// FindPendingFinalizers walks the java/lang/ref/Finalizer queue and the referents, and reports the objects waiting
+// for finalize() by class. A slow finalize() or a blocked finalizer thread piles them up, with everything they refer.
+// The retained sizes are on the dominator tree following the finalizer references, since the strong one doesn't have them.
+func (a HeapDumpAnalyzer) FindPendingFinalizers(thresholdPct float64) (*FinalizerReport, error) {
+	reachability, err := a.ScanReachability()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := a.BuildDominatorTreeWith(ReferenceStrength_FINALIZER)
+	if err != nil {
+		return nil, err
+	}
+	report := &FinalizerReport{TotalSize: tree.RetainedSize(0), ThresholdPct: thresholdPct}
+
+	classes := make(map[string]*FinalizerClass)
+	// pending object ID -> its class
+	pending := make(map[uint64]*FinalizerClass)
+	for classObjectId, strength := range a.hprof.referenceClasses {
+		if strength != ReferenceStrength_FINALIZER {
+			continue
+		}
+		finalizerObjectIds, err := a.finalizerObjectIds(classObjectId)
+		if err != nil {
+			return nil, err
+		}
+		for _, finalizerObjectId := range finalizerObjectIds {
+			var referent uint64
+			err := a.hprof.ForEachReference(finalizerObjectId, func(ref *Reference) error {
+				if ref.Strength == ReferenceStrength_FINALIZER {
+					referent = ref.ObjectId
+					return errStopIteration
+				}
+				return nil
+			})
+			if err != nil && err != errStopIteration {
+				return nil, err
+			}
+			// the referent is cleared when finalize() is called.
+			if referent == 0 {
+				continue
+			}
+			className, err := a.hprof.GetObjectClassName(referent)
+			if err == errObjectNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			report.FinalizerCount++
+			class, ok := classes[className]
+			if !ok {
+				class = &FinalizerClass{ClassName: className}
+				classes[className] = class
+			}
+			class.Count++
+			// the weak references are cleared before the finalization.
+			if strength, ok := reachability[referent]; ok && strength >= ReferenceStrength_WEAK {
+				report.PendingCount++
+				class.PendingCount++
+				size, err := a.GetShallowSize(referent)
+				if err != nil {
+					return nil, err
+				}
+				class.ShallowSize += uint64(size)
+				pending[referent] = class
+			}
+		}
+	}
+	if err := a.pendingRetainedSizes(tree, pending, report); err != nil {
+		return nil, err
+	}
+
+	for _, class := range classes {
+		class.Percentage = Percentage(class.RetainedSize, report.TotalSize)
+		class.Dominant = class.PendingCount > 0 && class.Percentage >= thresholdPct
+		report.Classes = append(report.Classes, class)
+	}
+	sort.Slice(report.Classes, func(i, j int) bool {
+		if report.Classes[i].RetainedSize != report.Classes[j].RetainedSize {
+			return report.Classes[i].RetainedSize > report.Classes[j].RetainedSize
+		}
+		if report.Classes[i].Count != report.Classes[j].Count {
+			return report.Classes[i].Count > report.Classes[j].Count
+		}
+		return report.Classes[i].ClassName < report.Classes[j].ClassName
+	})
+	return report, nil
+}
+
+// finalizerObjectIds returns the Finalizer instances in the queue, from the head at the static unfinalized field
+// following next. The instance is removed from the queue before finalize() is called, and links itself by next and prev,
+// so the instances of the class are used only if the heap dump doesn't have the queue, skipping the removed ones.
+func (a HeapDumpAnalyzer) finalizerObjectIds(classObjectId uint64) ([]uint64, error) {
+	class, err := a.hprof.GetObject(classObjectId)
+	if err != nil {
+		return nil, err
+	}
+	if head := class.GetField("unfinalized"); head != nil && head.Type == hprofdata.HProfValueType_OBJECT {
+		var objectIds []uint64
+		seen := make(map[uint64]bool)
+		for objectId := head.Value; objectId != 0 && !seen[objectId]; {
+			seen[objectId] = true
+			object, err := a.hprof.GetObject(objectId)
+			if err != nil {
+				return nil, err
+			}
+			if object == nil {
+				break
+			}
+			objectIds = append(objectIds, objectId)
+			next := object.GetField("next")
+			if next == nil {
+				break
+			}
+			objectId = next.Value
+		}
+		return objectIds, nil
+	}
+
+	var objectIds []uint64
+	for _, objectId := range a.hprof.classObjectId2objectIds[classObjectId] {
+		object, err := a.hprof.GetObject(objectId)
+		if err != nil {
+			return nil, err
+		}
+		if next := object.GetField("next"); next != nil && next.Value == objectId {
+			continue
+		}
+		objectIds = append(objectIds, objectId)
+	}
+	return objectIds, nil
+}
+
+// pendingRetainedSizes sums up the retained sizes of the pending objects by DominatorTree.WalkDominated, keyed by the
+// classes and the report.
+func (a HeapDumpAnalyzer) pendingRetainedSizes(tree *DominatorTree, pending map[uint64]*FinalizerClass, report *FinalizerReport) error {
+	return tree.WalkDominated(tree.Children(0), func(objectId uint64) ([]interface{}, error) {
+		if class, ok := pending[objectId]; ok {
+			// the report is the key of all the pending objects.
+			return []interface{}{class, report}, nil
+		}
+		return nil, nil
+	}, func(objectId uint64, key interface{}, outermost bool) {
+		if !outermost {
+			return
+		}
+		if class, ok := key.(*FinalizerClass); ok {
+			class.RetainedSize += tree.RetainedSize(objectId)
+		} else {
+			report.RetainedSize += tree.RetainedSize(objectId)
+		}
+	})
+}
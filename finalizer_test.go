@@ -0,0 +1,100 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"testing"
+)
+
+func TestFindPendingFinalizers(t *testing.T) {
+	b := NewHProfBuilder(8)
+	object := b.AddClass("java/lang/Object", 0)
+	references := referenceClasses(b, object)
+	finalizer := references["java/lang/ref/Finalizer"]
+	resource := b.AddClass("Resource", object, HProfBuilderField{"buffer", hprofdata.HProfValueType_OBJECT})
+
+	// Finalizer.unfinalized -> f1 -> f2 -> f3
+	var finalizers []uint64
+	var resources []uint64
+	for i := 0; i < 3; i++ {
+		f := b.AddInstance(finalizer)
+		r := b.AddInstance(resource)
+		b.SetField(f, "referent", r)
+		if i > 0 {
+			b.SetField(finalizers[i-1], "next", f)
+			b.SetField(f, "prev", finalizers[i-1])
+		}
+		finalizers = append(finalizers, f)
+		resources = append(resources, r)
+	}
+	b.AddStaticField(finalizer, "unfinalized", hprofdata.HProfValueType_OBJECT, finalizers[0])
+	b.AddRoot(parser.HProfHDRecordTypeRootStickyClass, finalizer)
+	// resources[0] is pending with the big buffer, resources[1] is live, and resources[2] is pending.
+	b.SetField(resources[0], "buffer", b.AddPrimitiveArray(hprofdata.HProfValueType_LONG, make([]uint64, 100)...))
+	b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, resources[1])
+	// removed is unlinked from the queue by the finalizer thread to call finalize() and links itself,
+	// so its referent isn't pending.
+	removed := b.AddInstance(finalizer)
+	b.SetField(removed, "referent", b.AddInstance(resource))
+	b.SetField(removed, "next", removed)
+	b.SetField(removed, "prev", removed)
+	b.AddRoot(parser.HProfHDRecordTypeRootJavaFrame, removed)
+	tester := builderTestData(t, b)
+	defer tester.Close()
+
+	report, err := tester.analyzer.FindPendingFinalizers(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FinalizerCount != 3 || report.PendingCount != 2 {
+		t.Errorf("2 of 3 finalizable objects should be pending. But %+v", report)
+	}
+	if len(report.Classes) != 1 {
+		t.Fatalf("Only Resource should be reported. But %v", report.Classes)
+	}
+	// Resource: 16 + 8, long[100]: 800
+	class := report.Classes[0]
+	if class.ClassName != "Resource" || class.Count != 3 || class.PendingCount != 2 ||
+		class.ShallowSize != 24*2 || class.RetainedSize != 24*2+800 || !class.Dominant {
+		t.Errorf("Resource should retain 848 bytes and dominate the heap. But %+v", class)
+	}
+	if report.RetainedSize != class.RetainedSize || report.TotalSize < report.RetainedSize {
+		t.Errorf("unexpected total: %+v", report)
+	}
+
+	// the strong dominator tree doesn't have the pending objects.
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Contains(resources[0]) || !tree.Contains(resources[1]) {
+		t.Errorf("Only the live Resource should be strongly reachable")
+	}
+}
+
+func TestFindPendingFinalizersWithoutQueue(t *testing.T) {
+	b := NewHProfBuilder(8)
+	object := b.AddClass("java/lang/Object", 0)
+	finalizer := referenceClasses(b, object)["java/lang/ref/Finalizer"]
+	resource := b.AddClass("Resource", object)
+
+	// the heap dump doesn't have the static unfinalized field, so the instances are used without the removed one.
+	registered := b.AddInstance(finalizer)
+	b.SetField(registered, "referent", b.AddInstance(resource))
+	removed := b.AddInstance(finalizer)
+	b.SetField(removed, "referent", b.AddInstance(resource))
+	b.SetField(removed, "next", removed)
+	b.SetField(removed, "prev", removed)
+	b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, registered)
+	b.AddRoot(parser.HProfHDRecordTypeRootJavaFrame, removed)
+	tester := builderTestData(t, b)
+	defer tester.Close()
+
+	report, err := tester.analyzer.FindPendingFinalizers(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FinalizerCount != 1 || report.PendingCount != 1 {
+		t.Errorf("Only the registered object should be pending. But %+v", report)
+	}
+}
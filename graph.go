@@ -0,0 +1,252 @@
+package heapdump
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Subgraph is a part of the object graph around the start objects.
+type Subgraph struct {
+	Nodes []*SubgraphNode
+	Edges []*SubgraphEdge
+	// Truncated is true if the objects beyond the max number of the nodes are left.
+	Truncated bool
+}
+
+// SubgraphNode is an object in the subgraph.
+type SubgraphNode struct {
+	ObjectId    uint64
+	ClassName   string
+	ShallowSize uint64
+	// RetainedSize is 0 for the objects unreachable from the GC roots.
+	RetainedSize uint64
+	Reachable    bool
+	// RootKinds are the kinds of the GC root, if the object is a GC root.
+	RootKinds []string
+	// Depth is the number of the references from the nearest start object.
+	Depth int
+}
+
+// SubgraphEdge is a reference between the objects in the subgraph.
+type SubgraphEdge struct {
+	From uint64
+	To   uint64
+	// Label is the field name or the array index, e.g. "next", "[3]".
+	Label string
+}
+
+// BuildSubgraph follows the references from the start objects by the breadth first search, up to maxDepth references
+// and maxNodes objects. All the references between the collected objects are included, to show the shared objects.
+func (a HeapDumpAnalyzer) BuildSubgraph(tree *DominatorTree, startObjectIds []uint64, maxDepth int, maxNodes int) (*Subgraph, error) {
+	g := new(Subgraph)
+	nodes := make(map[uint64]*SubgraphNode)
+	addNode := func(objectId uint64, depth int) error {
+		className, err := a.hprof.GetObjectClassName(objectId)
+		if err != nil {
+			return err
+		}
+		shallowSize, err := a.GetShallowSize(objectId)
+		if err != nil {
+			return err
+		}
+		node := &SubgraphNode{
+			ObjectId:    objectId,
+			ClassName:   className,
+			ShallowSize: uint64(shallowSize),
+			Reachable:   tree.Contains(objectId),
+			RootKinds:   a.hprof.GetRootKinds(objectId),
+			Depth:       depth,
+		}
+		if node.Reachable {
+			node.RetainedSize = tree.RetainedSize(objectId)
+		}
+		nodes[objectId] = node
+		g.Nodes = append(g.Nodes, node)
+		return nil
+	}
+
+	var queue []uint64
+	for _, objectId := range startObjectIds {
+		if _, ok := nodes[objectId]; ok {
+			continue
+		}
+		if len(g.Nodes) >= maxNodes {
+			g.Truncated = true
+			break
+		}
+		if err := addNode(objectId, 0); err != nil {
+			return nil, err
+		}
+		queue = append(queue, objectId)
+	}
+	for len(queue) > 0 {
+		current := nodes[queue[0]]
+		queue = queue[1:]
+		if current.Depth >= maxDepth {
+			continue
+		}
+		err := a.hprof.ForEachReference(current.ObjectId, func(ref *Reference) error {
+			if _, ok := nodes[ref.ObjectId]; ok {
+				return nil
+			}
+			if exists, err := a.hprof.HasObject(ref.ObjectId); err != nil || !exists {
+				return err
+			}
+			if len(g.Nodes) >= maxNodes {
+				g.Truncated = true
+				return nil
+			}
+			queue = append(queue, ref.ObjectId)
+			return addNode(ref.ObjectId, current.Depth+1)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, node := range g.Nodes {
+		err := a.hprof.ForEachReference(node.ObjectId, func(ref *Reference) error {
+			if _, ok := nodes[ref.ObjectId]; ok {
+				g.Edges = append(g.Edges, &SubgraphEdge{From: node.ObjectId, To: ref.ObjectId, Label: a.hprof.GetReferenceName(ref)})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// label returns the lines of the label of the node.
+func (n *SubgraphNode) label() []string {
+	lines := []string{n.ClassName, fmt.Sprintf("#%d", n.ObjectId)}
+	if n.Reachable {
+		lines = append(lines, fmt.Sprintf("shallow %d, retained %d", n.ShallowSize, n.RetainedSize))
+	} else {
+		lines = append(lines, fmt.Sprintf("shallow %d, unreachable", n.ShallowSize))
+	}
+	if len(n.RootKinds) > 0 {
+		lines = append(lines, "GC root: "+strings.Join(n.RootKinds, ", "))
+	}
+	return lines
+}
+
+// WriteDOT writes the subgraph in the DOT language of GraphViz. The start objects are drawn in bold, and the GC roots
+// are filled.
+func (g *Subgraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph heap {")
+	fmt.Fprintln(bw, "  node [shape=box, fontname=\"monospace\"];")
+	fmt.Fprintln(bw, "  edge [fontname=\"monospace\"];")
+	for _, node := range g.Nodes {
+		var label []string
+		for _, line := range node.label() {
+			label = append(label, dotEscape(line))
+		}
+		attributes := []string{fmt.Sprintf("label=\"%s\"", strings.Join(label, "\\n"))}
+		var styles []string
+		if node.Depth == 0 {
+			styles = append(styles, "bold")
+		}
+		if len(node.RootKinds) > 0 {
+			styles = append(styles, "filled")
+			attributes = append(attributes, "fillcolor=lightgrey")
+		}
+		if len(styles) > 0 {
+			attributes = append(attributes, fmt.Sprintf("style=\"%s\"", strings.Join(styles, ",")))
+		}
+		fmt.Fprintf(bw, "  n%d [%s];\n", node.ObjectId, strings.Join(attributes, ", "))
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(bw, "  n%d -> n%d [label=\"%s\"];\n", edge.From, edge.To, dotEscape(edge.Label))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+func dotEscape(s string) string {
+	return strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1)
+}
+
+// WriteGraphML writes the subgraph in GraphML, e.g. for yEd, Gephi or Cytoscape.
+func (g *Subgraph) WriteGraphML(w io.Writer) error {
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	type node struct {
+		Id   string `xml:"id,attr"`
+		Data []data `xml:"data"`
+	}
+	type edge struct {
+		Source string `xml:"source,attr"`
+		Target string `xml:"target,attr"`
+		Data   []data `xml:"data"`
+	}
+	type key struct {
+		Id   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	type graph struct {
+		EdgeDefault string  `xml:"edgedefault,attr"`
+		Nodes       []*node `xml:"node"`
+		Edges       []*edge `xml:"edge"`
+	}
+	type graphML struct {
+		XMLName xml.Name `xml:"graphml"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Keys    []key    `xml:"key"`
+		Graph   graph    `xml:"graph"`
+	}
+
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []key{
+			{Id: "label", For: "node", Name: "label", Type: "string"},
+			{Id: "className", For: "node", Name: "className", Type: "string"},
+			{Id: "shallowSize", For: "node", Name: "shallowSize", Type: "long"},
+			{Id: "retainedSize", For: "node", Name: "retainedSize", Type: "long"},
+			{Id: "rootKinds", For: "node", Name: "rootKinds", Type: "string"},
+			{Id: "depth", For: "node", Name: "depth", Type: "int"},
+			{Id: "reference", For: "edge", Name: "reference", Type: "string"},
+		},
+		Graph: graph{EdgeDefault: "directed"},
+	}
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, &node{
+			Id: fmt.Sprintf("n%d", n.ObjectId),
+			Data: []data{
+				{"label", strings.Join(n.label(), "\n")},
+				{"className", n.ClassName},
+				{"shallowSize", fmt.Sprint(n.ShallowSize)},
+				{"retainedSize", fmt.Sprint(n.RetainedSize)},
+				{"rootKinds", strings.Join(n.RootKinds, ", ")},
+				{"depth", fmt.Sprint(n.Depth)},
+			},
+		})
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, &edge{
+			Source: fmt.Sprintf("n%d", e.From),
+			Target: fmt.Sprintf("n%d", e.To),
+			Data:   []data{{"reference", e.Label}},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
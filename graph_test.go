@@ -0,0 +1,57 @@
+package heapdump
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestBuildSubgraph(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	object1 := tester.FindInstance("Object1")
+	object2 := tester.FindInstance("Object2")
+
+	g, err := tester.analyzer.BuildSubgraph(tree, []uint64{object1}, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Nodes) != 2 || g.Nodes[0].ObjectId != object1 || g.Nodes[1].ObjectId != object2 || g.Truncated {
+		t.Fatalf("Object1 and Object2 should be exported")
+	}
+	if g.Nodes[0].RetainedSize != 66 || g.Nodes[1].Depth != 1 {
+		t.Errorf("Unexpected node: %v, %v", *g.Nodes[0], *g.Nodes[1])
+	}
+	if len(g.Edges) != 1 || *g.Edges[0] != (SubgraphEdge{From: object1, To: object2, Label: "o2"}) {
+		t.Errorf("Object1 should refer Object2 by o2. But %v", g.Edges)
+	}
+
+	g, err = tester.analyzer.BuildSubgraph(tree, []uint64{object1}, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Nodes) != 2 || !g.Truncated {
+		t.Errorf("The subgraph should be truncated by the max nodes")
+	}
+
+	var dot bytes.Buffer
+	if err := g.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dot.String(), `[label="o2"]`) {
+		t.Errorf("The edge should be labelled by the field name. But %v", dot.String())
+	}
+	var graphML bytes.Buffer
+	if err := g.WriteGraphML(&graphML); err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(graphML.Bytes(), new(struct{})); err != nil {
+		t.Errorf("GraphML should be a valid XML: %v", err)
+	}
+}
@@ -1,9 +1,25 @@
-package main
+// Package heapdump analyzes the hprof heap dump files generated by the JVM.
+//
+// A typical usage is:
+//
+//	analyzer, err := heapdump.Open(logger, "heapdump.hprof", "")
+//	if err != nil {
+//		return err
+//	}
+//	defer analyzer.Close()
+//	rootScanner := heapdump.NewRootScanner(logger)
+//	if err := rootScanner.ScanAll(analyzer); err != nil {
+//		return err
+//	}
+//	ranking, err := analyzer.InclusiveRanking(rootScanner)
+package heapdump
 
 import (
+	"fmt"
 	"golang.org/x/text/message"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 )
 
@@ -12,87 +28,303 @@ type HeapDumpAnalyzer struct {
 	hprof                  *HProf // TODO deprecate this.
 	softSizeCalculator     *SoftSizeCalculator
 	retainedSizeCalculator *RetainedSizeCalculator
+	indexPath              string
+	temporaryIndex         bool
 }
 
-func NewHeapDumpAnalyzer(logger *Logger) (*HeapDumpAnalyzer, error) {
-	m := new(HeapDumpAnalyzer)
-	m.logger = logger
+// Class is a class in the heap dump.
+type Class struct {
+	ClassObjectId       uint64
+	Name                string
+	SuperClassObjectId  uint64
+	ClassLoaderObjectId uint64
+	InstanceCount       int
+}
+
+// ClassRetainedSize is a row of the inclusive ranking.
+type ClassRetainedSize struct {
+	Class        *Class
+	ShallowSize  int
+	RetainedSize uint64
+}
 
-	// todo: clean up tempdir.
+// ClassInstancesRetainedSize is the retained size of the instances of a class.
+type ClassInstancesRetainedSize struct {
+	Class             *Class
+	RetainedSizes     map[uint64]uint64 // object ID -> retained size
+	TotalRetainedSize uint64
+}
+
+// NewHeapDumpAnalyzer creates the analyzer with the index in the temporary directory.
+// The index is removed by Close.
+func NewHeapDumpAnalyzer(logger *Logger) (*HeapDumpAnalyzer, error) {
 	indexPath, err := ioutil.TempDir(os.TempDir(), "hprof")
 	if err != nil {
 		return nil, err
 	}
+	m, err := NewHeapDumpAnalyzerWithIndex(logger, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	m.temporaryIndex = true
+	return m, nil
+}
+
+// NewHeapDumpAnalyzerWithIndex creates the analyzer with the index in the indexPath.
+// The index is kept after Close, and can be opened by OpenIndex.
+func NewHeapDumpAnalyzerWithIndex(logger *Logger, indexPath string) (*HeapDumpAnalyzer, error) {
+	m := new(HeapDumpAnalyzer)
+	m.logger = logger
+	m.indexPath = indexPath
 
 	m.logger.Info("Opening index path: %v", indexPath)
 
-	m.hprof, _ = NewHProf(logger, indexPath)
+	hprof, err := NewHProf(logger, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	m.hprof = hprof
 	m.softSizeCalculator = NewSoftSizeCalculator(logger)
 	m.retainedSizeCalculator = NewRetainedSizeCalculator(logger)
 	return m, nil
 }
 
+// Open reads the heap dump file. If the indexPath is empty, the index is created in the temporary directory.
+func Open(logger *Logger, heapFilePath string, indexPath string) (*HeapDumpAnalyzer, error) {
+	var analyzer *HeapDumpAnalyzer
+	var err error
+	if indexPath == "" {
+		analyzer, err = NewHeapDumpAnalyzer(logger)
+	} else {
+		analyzer, err = NewHeapDumpAnalyzerWithIndex(logger, indexPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := analyzer.ReadFile(heapFilePath); err != nil {
+		analyzer.Close()
+		return nil, err
+	}
+	return analyzer, nil
+}
+
+// IsIndex returns true if the path is the index directory created by Open or NewHeapDumpAnalyzerWithIndex.
+func IsIndex(path string) bool {
+	// the index is the LevelDB database, which has the CURRENT file.
+	fi, err := os.Stat(filepath.Join(path, "CURRENT"))
+	return err == nil && !fi.IsDir()
+}
+
+// OpenIndex opens the index created by Open or NewHeapDumpAnalyzerWithIndex, without reading the heap dump file.
+func OpenIndex(logger *Logger, indexPath string) (*HeapDumpAnalyzer, error) {
+	analyzer, err := NewHeapDumpAnalyzerWithIndex(logger, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := analyzer.hprof.LoadIndex(); err != nil {
+		analyzer.Close()
+		return nil, fmt.Errorf("cannot load index %v: %v", indexPath, err)
+	}
+	return analyzer, nil
+}
+
+// IsIndexOf returns true if the index is created from the heap dump file, and the file isn't modified after that.
+func (a HeapDumpAnalyzer) IsIndexOf(heapFilePath string) (bool, error) {
+	return a.hprof.isIndexOf(heapFilePath)
+}
+
+func (a HeapDumpAnalyzer) Close() error {
+	err := a.hprof.Close()
+	if a.temporaryIndex {
+		if rerr := os.RemoveAll(a.indexPath); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
 func (a HeapDumpAnalyzer) ReadFile(heapFilePath string) error {
 	return a.hprof.ReadFile(heapFilePath)
 }
 
-func (a HeapDumpAnalyzer) DumpInclusiveRanking(rootScanner *RootScanner) error {
-	a.logger.Debug("DumpInclusiveRanking")
+// ClassObjectIds returns the object IDs of the classes which have any instances, in ascending order.
+func (a HeapDumpAnalyzer) ClassObjectIds() []uint64 {
 	var classObjectIds []uint64
 	for k := range a.hprof.classObjectId2objectIds {
 		classObjectIds = append(classObjectIds, k)
 	}
-
 	sort.Slice(classObjectIds, func(i, j int) bool {
 		return classObjectIds[i] < classObjectIds[j]
 	})
+	return classObjectIds
+}
 
-	var classObjectId2objectSize = map[uint64]uint64{}
-	classObjectId2objectCount := make(map[uint64]int)
-	for _, classObjectId := range classObjectIds {
-		objectIds := a.hprof.classObjectId2objectIds[classObjectId]
-		name, err := a.hprof.GetClassNameByClassObjectId(classObjectId)
+// GetClass returns the class. It returns nil if the class isn't in the heap dump.
+func (a HeapDumpAnalyzer) GetClass(classObjectId uint64) (*Class, error) {
+	classDump, err := a.hprof.GetClassDumpByClassObjectId(classObjectId)
+	if err != nil {
+		return nil, err
+	}
+	if classDump == nil {
+		return nil, nil
+	}
+	name, err := a.hprof.GetClassNameByClassObjectId(classObjectId)
+	if err != nil {
+		return nil, err
+	}
+	return &Class{
+		ClassObjectId:       classObjectId,
+		Name:                name,
+		SuperClassObjectId:  classDump.SuperClassObjectId,
+		ClassLoaderObjectId: classDump.ClassLoaderObjectId,
+		InstanceCount:       len(a.hprof.classObjectId2objectIds[classObjectId]),
+	}, nil
+}
+
+// FindClassObjectIdsByName returns the object IDs of the classes which have any instances and the name.
+// There can be multiple classes with the same name, loaded by the different class loaders.
+func (a HeapDumpAnalyzer) FindClassObjectIdsByName(name string) ([]uint64, error) {
+	pattern, err := NewClassPattern(name)
+	if err != nil {
+		return nil, err
+	}
+	return a.FindClassObjectIdsByPattern(pattern)
+}
+
+// FindClassObjectIdsByPattern returns the object IDs of the classes which have any instances and match the pattern.
+func (a HeapDumpAnalyzer) FindClassObjectIdsByPattern(pattern *ClassPattern) ([]uint64, error) {
+	var result []uint64
+	for _, classObjectId := range a.ClassObjectIds() {
+		className, err := a.hprof.GetClassNameByClassObjectId(classObjectId)
 		if err != nil {
+			return nil, err
+		}
+		if pattern.Match(className) {
+			result = append(result, classObjectId)
+		}
+	}
+	return result, nil
+}
+
+// ForEachClass calls fn for each class which has any instances.
+func (a HeapDumpAnalyzer) ForEachClass(fn func(class *Class) error) error {
+	for _, classObjectId := range a.ClassObjectIds() {
+		class, err := a.GetClass(classObjectId)
+		if err != nil {
+			return err
+		}
+		if class == nil {
+			return fmt.Errorf("missing class dump: classObjectId=%v", classObjectId)
+		}
+		if err := fn(class); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachInstance calls fn for each instance of the class. Instances of the sub classes are not included.
+func (a HeapDumpAnalyzer) ForEachInstance(classObjectId uint64, fn func(objectId uint64) error) error {
+	for _, objectId := range a.hprof.classObjectId2objectIds[classObjectId] {
+		if err := fn(objectId); err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// GetShallowSize returns the size of the object itself.
+func (a HeapDumpAnalyzer) GetShallowSize(objectId uint64) (int, error) {
+	return a.softSizeCalculator.CalcSoftSizeByObjectId(a.hprof, objectId)
+}
 
-		for _, objectId := range objectIds {
+// GetObject returns the decoded object. It returns nil if the object isn't in the heap dump.
+func (a HeapDumpAnalyzer) GetObject(objectId uint64) (*Object, error) {
+	return a.hprof.GetObject(objectId)
+}
+
+// HasObject returns true if the object, the array or the class is in the heap dump.
+func (a HeapDumpAnalyzer) HasObject(objectId uint64) (bool, error) {
+	return a.hprof.HasObject(objectId)
+}
+
+// GetObjectClassName returns the class name of the object. For the classes, it returns "class <name>".
+func (a HeapDumpAnalyzer) GetObjectClassName(objectId uint64) (string, error) {
+	return a.hprof.GetObjectClassName(objectId)
+}
+
+// GetStringValue decodes the java/lang/String instance.
+func (a HeapDumpAnalyzer) GetStringValue(objectId uint64) (string, error) {
+	return a.hprof.GetStringValue(objectId)
+}
+
+// BuildDominatorTree builds the dominator tree of the objects strongly reachable from the GC roots.
+func (a HeapDumpAnalyzer) BuildDominatorTree() (*DominatorTree, error) {
+	return NewDominatorTree(a.logger, a.hprof, a.softSizeCalculator)
+}
+
+// BuildDominatorTreeWith builds the dominator tree following the references as strong as the strength or stronger,
+// e.g. ReferenceStrength_FINALIZER to include the objects waiting for the finalization.
+func (a HeapDumpAnalyzer) BuildDominatorTreeWith(strength ReferenceStrength) (*DominatorTree, error) {
+	return newDominatorTree(a.logger, a.hprof, a.softSizeCalculator, strength)
+}
+
+// InclusiveRanking returns the retained size of each class, ordered by the retained size ascending.
+func (a HeapDumpAnalyzer) InclusiveRanking(rootScanner *RootScanner) ([]*ClassRetainedSize, error) {
+	var result []*ClassRetainedSize
+	err := a.ForEachClass(func(class *Class) error {
+		row := &ClassRetainedSize{Class: class}
+		err := a.ForEachInstance(class.ClassObjectId, func(objectId uint64) error {
 			a.logger.Debug("Starting scan %v(classObjectId=%v, objectId=%v)\n",
-				name, classObjectId, objectId)
+				class.Name, class.ClassObjectId, objectId)
 
 			size, err := a.GetRetainedSize(objectId, rootScanner)
 			if err != nil {
 				return err
 			}
-			classObjectId2objectSize[classObjectId] += size
+			row.RetainedSize += size
 
 			a.logger.Debug("Finished scan %v(classObjectId=%v, objectId=%v) size=%v\n",
-				name, classObjectId, objectId, size)
+				class.Name, class.ClassObjectId, objectId, size)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		shallowSize, err := a.softSizeCalculator.CalcSoftSizeByClassObjectId(a.hprof, class.ClassObjectId)
+		if err != nil {
+			return err
 		}
-		classObjectId2objectCount[classObjectId] = len(objectIds)
+		row.ShallowSize = shallowSize
+		result = append(result, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// sort by retained size
-	sort.Slice(classObjectIds, func(i, j int) bool {
-		return classObjectId2objectSize[classObjectIds[i]] < classObjectId2objectSize[classObjectIds[j]]
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].RetainedSize < result[j].RetainedSize
 	})
+	return result, nil
+}
+
+func (a HeapDumpAnalyzer) DumpInclusiveRanking(rootScanner *RootScanner) error {
+	a.logger.Debug("DumpInclusiveRanking")
+	ranking, err := a.InclusiveRanking(rootScanner)
+	if err != nil {
+		return err
+	}
 
 	// print result
 	p := message.NewPrinter(message.MatchLanguage("en"))
-	for _, classObjectId := range classObjectIds {
-		name, err := a.hprof.GetClassNameByClassObjectId(classObjectId)
-		if err != nil {
-			return err
-		}
-		shallowSize, err := a.softSizeCalculator.CalcSoftSizeByClassObjectId(a.hprof, classObjectId)
-		if err != nil {
-			return err
-		}
+	for _, row := range ranking {
 		a.logger.Info(p.Sprintf("shallowSize=%11d retainedSize=%11d(count=%11d)= %s",
-			shallowSize,
-			classObjectId2objectSize[classObjectId],
-			classObjectId2objectCount[classObjectId],
-			name))
+			row.ShallowSize,
+			row.RetainedSize,
+			row.Class.InstanceCount,
+			row.Class.Name))
 	}
 
 	return nil
@@ -102,27 +334,118 @@ func (a HeapDumpAnalyzer) GetRetainedSize(objectId uint64, rootScanner *RootScan
 	return a.retainedSizeCalculator.GetRetainedSize(a.hprof, rootScanner, objectId)
 }
 
+// CalculateRetainedSizeOfInstancesByName returns the retained size of each instance of the classes with the name.
+// The name can be either of the JVM internal name or the Java style name.
 func (a HeapDumpAnalyzer) CalculateRetainedSizeOfInstancesByName(targetName string, rootScanner *RootScanner) (map[uint64]uint64, error) {
+	pattern, err := NewClassPattern(targetName)
+	if err != nil {
+		return nil, err
+	}
+	result, err := a.CalculateRetainedSizeOfInstancesByPattern(pattern, rootScanner)
+	if err != nil {
+		return nil, err
+	}
+
 	objectID2size := make(map[uint64]uint64)
+	for _, sizes := range result {
+		for objectId, size := range sizes.RetainedSizes {
+			objectID2size[objectId] = size
+		}
+	}
+	return objectID2size, nil
+}
 
-	for classObjectId, objectIds := range a.hprof.classObjectId2objectIds {
-		name, err := a.hprof.GetClassNameByClassObjectId(classObjectId)
+// CalculateRetainedSizeOfInstancesByPattern returns the retained size of each instance of the matched classes,
+// ordered by the total retained size descending.
+// Classes with the same name loaded by the different class loaders are returned separately.
+func (a HeapDumpAnalyzer) CalculateRetainedSizeOfInstancesByPattern(pattern *ClassPattern, rootScanner *RootScanner) ([]*ClassInstancesRetainedSize, error) {
+	classObjectIds, err := a.FindClassObjectIdsByPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ClassInstancesRetainedSize
+	for _, classObjectId := range classObjectIds {
+		class, err := a.GetClass(classObjectId)
 		if err != nil {
 			return nil, err
 		}
-		if name == targetName {
-			for _, objectId := range objectIds {
-				a.logger.Debug("**** Scanning %v objectId=%v", targetName, objectId)
-				size, err := a.GetRetainedSize(objectId, rootScanner)
-				if err != nil {
-					return nil, err
-				}
-				objectID2size[objectId] = size
-				a.logger.Debug("**** Scanned %v\n\n", size)
+		sizes := &ClassInstancesRetainedSize{
+			Class:         class,
+			RetainedSizes: make(map[uint64]uint64),
+		}
+		err = a.ForEachInstance(classObjectId, func(objectId uint64) error {
+			a.logger.Debug("**** Scanning %v objectId=%v", class.Name, objectId)
+			size, err := a.GetRetainedSize(objectId, rootScanner)
+			if err != nil {
+				return err
 			}
-			break
+			sizes.RetainedSizes[objectId] = size
+			sizes.TotalRetainedSize += size
+			a.logger.Debug("**** Scanned %v\n\n", size)
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
+		result = append(result, sizes)
 	}
 
-	return objectID2size, nil
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].TotalRetainedSize > result[j].TotalRetainedSize
+	})
+	return result, nil
+}
+
+// RetainedSizeOfInstancesByPattern returns the retained size of each reachable instance of the matched classes on the
+// dominator tree, ordered by the total retained size descending. TotalRetainedSize sums up the outermost instances of
+// each class. See DominatorTree.WalkDominated.
+func (a HeapDumpAnalyzer) RetainedSizeOfInstancesByPattern(tree *DominatorTree, pattern *ClassPattern) ([]*ClassInstancesRetainedSize, error) {
+	classObjectIds, err := a.FindClassObjectIdsByPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ClassInstancesRetainedSize
+	// object ID -> the sizes of its class
+	instances := make(map[uint64]*ClassInstancesRetainedSize)
+	for _, classObjectId := range classObjectIds {
+		class, err := a.GetClass(classObjectId)
+		if err != nil {
+			return nil, err
+		}
+		sizes := &ClassInstancesRetainedSize{
+			Class:         class,
+			RetainedSizes: make(map[uint64]uint64),
+		}
+		err = a.ForEachInstance(classObjectId, func(objectId uint64) error {
+			instances[objectId] = sizes
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sizes)
+	}
+
+	err = tree.WalkDominated(tree.Children(0), func(objectId uint64) ([]interface{}, error) {
+		if sizes, ok := instances[objectId]; ok {
+			return []interface{}{sizes}, nil
+		}
+		return nil, nil
+	}, func(objectId uint64, key interface{}, outermost bool) {
+		sizes := key.(*ClassInstancesRetainedSize)
+		sizes.RetainedSizes[objectId] = tree.RetainedSize(objectId)
+		if outermost {
+			sizes.TotalRetainedSize += tree.RetainedSize(objectId)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].TotalRetainedSize > result[j].TotalRetainedSize
+	})
+	return result, nil
 }
@@ -1,7 +1,15 @@
-package main
+package heapdump
 
 import (
+	"encoding/binary"
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"github.com/syndtr/goleveldb/leveldb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 type Tester struct {
@@ -12,15 +20,42 @@ type Tester struct {
 func NewTester(path string, t *testing.T) *Tester {
 	m := new(Tester)
 	m.t = t
-	m.analyzer, _ = NewHeapDumpAnalyzer(NewLogger(LogLevel_INFO))
-	err := m.analyzer.ReadFile(path)
+	analyzer, err := Open(NewLogger(LogLevel_INFO), path, "")
 	if err != nil {
 		t.Fatal(err)
 	}
+	m.analyzer = analyzer
 
 	return m
 }
 
+func (a *Tester) Close() {
+	if err := a.analyzer.Close(); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+// FindInstance returns the object ID of the only instance of the class.
+func (a *Tester) FindInstance(targetClass string) uint64 {
+	var objectIds []uint64
+	err := a.analyzer.ForEachClass(func(class *Class) error {
+		if class.Name == targetClass {
+			return a.analyzer.ForEachInstance(class.ClassObjectId, func(objectId uint64) error {
+				objectIds = append(objectIds, objectId)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if len(objectIds) != 1 {
+		a.t.Fatalf("Only %v %v instance exists", len(objectIds), targetClass)
+	}
+	return objectIds[0]
+}
+
 func (a *Tester) AssertSize(targetClass string, expectedRetainedSize uint64) {
 	rootScanner := NewRootScanner(a.analyzer.logger)
 	err := rootScanner.ScanAll(a.analyzer)
@@ -102,6 +137,7 @@ func testInstanceSize(
 	targetClass string,
 	expectedRetainedSize uint64) {
 	tester := NewTester(path, t)
+	defer tester.Close()
 	tester.AssertSize(targetClass, expectedRetainedSize)
 }
 
@@ -127,24 +163,60 @@ func TestArray(t *testing.T) {
 	t.Skip("broken test.")
 
 	tester := NewTester("testdata/array/heapdump.hprof", t)
+	defer tester.Close()
 	tester.AssertTotalSize("Object2", 480)
 	tester.AssertTotalSize("Object3", 0)
 	tester.AssertSize("Object1", 692)
 }
 
+// The values of the primitive array dump are the raw bytes of the elements. They were multiplied by the element size
+// again, e.g. char[10] was 40 bytes shallow and 64 bytes retained.
+func TestPrimitiveArray(t *testing.T) {
+	tester := NewTester("testdata/array/heapdump.hprof", t)
+	defer tester.Close()
+
+	result := tester.Query("SELECT o.r2 FROM Object1 o")
+	reference, ok := result.Rows[0][0].(*ObjectReference)
+	if !ok || reference.ClassName != "char[]" {
+		t.Fatalf("Object1.r2 should be char[]. But %v", result.Rows[0][0])
+	}
+	// the shallow size and the dominator tree count the elements only, i.e. 2 * 10 bytes, and RootScanner adds
+	// 16 + 4(length) + 4(padding) for the header.
+	if size, err := tester.analyzer.GetShallowSize(reference.ObjectId); err != nil || size != 20 {
+		t.Errorf("The shallow size of char[10] should be 20. But %v, %v", size, err)
+	}
+	rootScanner := NewRootScanner(tester.analyzer.logger)
+	if err := rootScanner.ScanAll(tester.analyzer); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := tester.analyzer.GetRetainedSize(reference.ObjectId, rootScanner); err != nil || size != 44 {
+		t.Errorf("The retained size of char[10] should be 44. But %v, %v", size, err)
+	}
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := tree.RetainedSize(reference.ObjectId); size != 20 {
+		t.Errorf("char[10] should retain 20 bytes in the dominator tree. But %v", size)
+	}
+}
+
 // 特定のクラスがデカくなりすぎてるのを確認する。
 func TestMisc(t *testing.T) {
 	tester := NewTester("testdata/array/heapdump.hprof", t)
+	defer tester.Close()
 	tester.AssertTotalSizeLessThan("java/util/Vector", 1000)
 }
 
 func TestClass(t *testing.T) {
 	tester := NewTester("testdata/class/heapdump.hprof", t)
+	defer tester.Close()
 	tester.AssertSize("Object1", 24)
 }
 
 func TestString(t *testing.T) {
 	tester := NewTester("testdata/string/heapdump.hprof", t)
+	defer tester.Close()
 	tester.AssertSize("Object1", 24)
 }
 
@@ -152,6 +224,7 @@ func TestBoxed(t *testing.T) {
 	t.Skip("broken test.")
 
 	tester := NewTester("testdata/boxed/heapdump.hprof", t)
+	defer tester.Close()
 	tester.AssertSize("Object1", 24)
 }
 
@@ -159,15 +232,268 @@ func TestHashMap(t *testing.T) {
 	t.Skip("broken test.")
 
 	tester := NewTester("testdata/hashmap/heapdump.hprof", t)
+	defer tester.Close()
 	tester.AssertSize("Object1", 24)
 }
 
 func TestStringBuilder(t *testing.T) {
 	tester := NewTester("testdata/stringbuilder/heapdump.hprof", t)
+	defer tester.Close()
 	tester.AssertSize("Object1", 93)
 }
 
 func TestByteArray(t *testing.T) {
 	tester := NewTester("testdata/bytearray/heapdump.hprof", t)
+	defer tester.Close()
 	tester.AssertSize("Object1", 53)
 }
+
+func TestMissingReference(t *testing.T) {
+	analyzer, err := NewHeapDumpAnalyzer(NewLogger(LogLevel_INFO))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer analyzer.Close()
+
+	// Object1.ref points to the object which isn't in the heap dump.
+	values := make([]byte, 8)
+	binary.BigEndian.PutUint64(values, 0xdead)
+	batch := new(leveldb.Batch)
+	for _, record := range []interface{}{
+		&hprofdata.HProfRecordUTF8{NameId: 1, Name: []byte("Object1")},
+		&hprofdata.HProfRecordUTF8{NameId: 2, Name: []byte("ref")},
+		&hprofdata.HProfRecordLoadClass{ClassObjectId: 10, ClassNameId: 1},
+		&hprofdata.HProfClassDump{
+			ClassObjectId: 10,
+			InstanceFields: []*hprofdata.HProfClassDump_InstanceField{
+				{NameId: 2, Type: hprofdata.HProfValueType_OBJECT},
+			},
+		},
+		&hprofdata.HProfInstanceDump{ObjectId: 100, ClassObjectId: 10, Values: values},
+		&hprofdata.HProfRootJNIGlobal{ObjectId: 100},
+	} {
+		if err := analyzer.hprof.addRecord(record, batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := analyzer.hprof.db.Write(batch, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rootScanner := NewRootScanner(analyzer.logger)
+	if err := rootScanner.ScanAll(analyzer); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := rootScanner.MissingReferences()
+	if len(missing) != 1 {
+		t.Fatalf("Expected 1 missing reference, but %v", len(missing))
+	}
+	if missing[0].ReferrerObjectId != 100 || missing[0].Field != "ref" || missing[0].ObjectId != 0xdead {
+		t.Fatalf("Unexpected missing reference: %#v", missing[0])
+	}
+
+	size, err := analyzer.GetRetainedSize(100, rootScanner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 24 {
+		t.Fatalf("Object1 should be 24 bytes. But %v", size)
+	}
+}
+
+func TestOpenIndex(t *testing.T) {
+	indexPath, err := ioutil.TempDir(os.TempDir(), "hprof-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(indexPath)
+	indexPath = filepath.Join(indexPath, "index")
+
+	logger := NewLogger(LogLevel_INFO)
+	analyzer, err := Open(logger, "testdata/object/heapdump.hprof", indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := len(analyzer.ClassObjectIds())
+	if err := analyzer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tester := &Tester{t: t}
+	tester.analyzer, err = OpenIndex(logger, indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+	if len(tester.analyzer.ClassObjectIds()) != expected {
+		t.Fatalf("The index should have %v classes. But %v",
+			expected, len(tester.analyzer.ClassObjectIds()))
+	}
+	tester.AssertSize("Object1", 66)
+
+	if ok, err := tester.analyzer.IsIndexOf("testdata/object/heapdump.hprof"); err != nil || !ok {
+		t.Errorf("The index should be of the heap dump. But %v, %v", ok, err)
+	}
+	// the heap dump overwritten after indexing, e.g. by jmap with the same file name.
+	modified := filepath.Join(filepath.Dir(indexPath), "heapdump.hprof")
+	if err := ioutil.WriteFile(modified, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(modified, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := tester.analyzer.IsIndexOf(modified); err != nil || ok {
+		t.Errorf("The index should be older than the modified heap dump. But %v, %v", ok, err)
+	}
+}
+
+func TestGetObject(t *testing.T) {
+	tester := NewTester("testdata/int/heapdump.hprof", t)
+	defer tester.Close()
+
+	object, err := tester.analyzer.GetObject(tester.FindInstance("Object1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := object.GetField("n")
+	if field == nil || field.String() != "5963492" {
+		t.Fatalf("Object1.n should be 5963492. But %v", field)
+	}
+}
+
+func TestGetStringValue(t *testing.T) {
+	tester := NewTester("testdata/string/heapdump.hprof", t)
+	defer tester.Close()
+
+	object, err := tester.analyzer.GetObject(tester.FindInstance("Object1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := tester.analyzer.GetStringValue(object.GetField("stringEntry").Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "abcdefghijklmnopqrstuvwxyz" {
+		t.Fatalf("Unexpected string: %v", value)
+	}
+}
+
+func TestFindPathFromRoot(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	path, err := tester.analyzer.FindPathFromRoot(tester.FindInstance("Object2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) < 3 {
+		t.Fatalf("The path should have the GC root, Object1 and Object2. But %v", len(path))
+	}
+	if len(path[0].RootKinds) == 0 {
+		t.Fatalf("The path should start from the GC root")
+	}
+	last := path[len(path)-1]
+	if last.ClassName != "Object2" || last.Reference != "o2" {
+		t.Fatalf("Unexpected path element: %#v", last)
+	}
+	if path[len(path)-2].ClassName != "Object1" {
+		t.Fatalf("Object2 should be referenced by Object1. But %v", path[len(path)-2].ClassName)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	tester := NewTester("testdata/array/heapdump.hprof", t)
+	defer tester.Close()
+
+	histogram, err := tester.analyzer.Histogram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, entry := range histogram {
+		counts[entry.Name] = entry.Count
+	}
+	if counts["Object2"] != 10 {
+		t.Fatalf("There should be 10 Object2 instances. But %v", counts["Object2"])
+	}
+	if counts["[LObject2;"] != 1 {
+		t.Fatalf("There should be 1 Object2[] array. But %v", counts["[LObject2;"])
+	}
+}
+
+func TestUnreachableHistogram(t *testing.T) {
+	b := NewHProfBuilder(8)
+	node := nodeClass(b)
+	live := b.AddInstance(node)
+	garbage := b.AddInstance(node)
+	b.SetField(garbage, "next", b.AddInstance(node))
+	b.AddPrimitiveArray(hprofdata.HProfValueType_INT, 1, 2, 3)
+	b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, live)
+	tester := builderTestData(t, b)
+	defer tester.Close()
+	reachability, err := tester.analyzer.ScanReachability()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	histograms := make(map[string][]*HistogramEntry)
+	histograms["reachable"], err = tester.analyzer.ReachableHistogram(reachability)
+	if err != nil {
+		t.Fatal(err)
+	}
+	histograms["unreachable"], err = tester.analyzer.UnreachableHistogram(reachability)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]HistogramEntry{
+		"reachable":   {"Node": {Count: 1, ShallowSize: 28}},
+		"unreachable": {"Node": {Count: 2, ShallowSize: 56}, "int[]": {Count: 1, ShallowSize: 12}},
+	}
+	for kind, histogram := range histograms {
+		if len(histogram) != len(expected[kind]) {
+			t.Errorf("%v histogram should have %v classes. But %v", kind, len(expected[kind]), len(histogram))
+		}
+		for _, entry := range histogram {
+			if e := expected[kind][entry.Name]; entry.Count != e.Count || entry.ShallowSize != e.ShallowSize {
+				t.Errorf("%v %v should be %+v. But %+v", kind, entry.Name, e, entry)
+			}
+		}
+	}
+}
+
+func TestTopInstances(t *testing.T) {
+	tester := NewTester("testdata/string/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern, err := NewClassPattern("Object1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := tester.analyzer.RetainedSizeOfInstancesByPattern(tree, pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, err := tester.analyzer.TopInstances(result, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].ShallowSize != 24 || instances[0].RetainedSize != 24 {
+		t.Fatalf("Unexpected instances: %#v", instances)
+	}
+	if instances[0].RetainedSize != tree.RetainedSize(instances[0].ObjectId) {
+		t.Errorf("The retained size should be on the dominator tree. But %v", instances[0].RetainedSize)
+	}
+
+	preview, err := tester.analyzer.GetObjectPreview(instances[0].ObjectId, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview != `stringEntry="abcdefghijklmnopqrst"...` {
+		t.Fatalf("Unexpected preview: %v", preview)
+	}
+}
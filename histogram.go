@@ -0,0 +1,192 @@
+package heapdump
+
+import (
+	"sort"
+)
+
+// HistogramEntry is a row of the class histogram.
+type HistogramEntry struct {
+	// ClassObjectId is 0 for the primitive arrays.
+	ClassObjectId uint64 `json:"id"`
+	Name          string `json:"name"`
+	Count         int    `json:"count"`
+	ShallowSize   uint64 `json:"shallowSize"`
+}
+
+// HistogramDiff is the difference of a class between two histograms.
+type HistogramDiff struct {
+	Name             string
+	BaseCount        int
+	Count            int
+	BaseShallowSize  uint64
+	ShallowSize      uint64
+	CountDelta       int
+	ShallowSizeDelta int64
+}
+
+// Histogram returns the number of the objects and the total shallow size of each class,
+// ordered by the shallow size descending. Arrays are included. The unreachable objects are included too.
+func (a HeapDumpAnalyzer) Histogram() ([]*HistogramEntry, error) {
+	return a.histogram(nil)
+}
+
+// ReachableHistogram returns the histogram of the objects reachable from the GC roots by any references,
+// including the weak references.
+func (a HeapDumpAnalyzer) ReachableHistogram(reachability Reachability) ([]*HistogramEntry, error) {
+	return a.histogram(func(objectId uint64) bool {
+		_, ok := reachability[objectId]
+		return ok
+	})
+}
+
+// UnreachableHistogram returns the histogram of the objects which are not reachable from the GC roots, i.e. the garbage
+// not collected yet when the heap dump was taken without the full GC, e.g. by `jmap -dump` without `live`.
+func (a HeapDumpAnalyzer) UnreachableHistogram(reachability Reachability) ([]*HistogramEntry, error) {
+	return a.histogram(func(objectId uint64) bool {
+		_, ok := reachability[objectId]
+		return !ok
+	})
+}
+
+// histogram counts the objects accepted by the filter, or all the objects if the filter is nil.
+func (a HeapDumpAnalyzer) histogram(filter func(objectId uint64) bool) ([]*HistogramEntry, error) {
+	entries := make(map[uint64]*HistogramEntry)
+	primitiveEntries := make(map[string]*HistogramEntry)
+
+	getEntry := func(classObjectId uint64) (*HistogramEntry, error) {
+		if entry, ok := entries[classObjectId]; ok {
+			return entry, nil
+		}
+		name, err := a.hprof.GetClassNameByClassObjectId(classObjectId)
+		if err != nil {
+			return nil, err
+		}
+		entry := &HistogramEntry{ClassObjectId: classObjectId, Name: name}
+		entries[classObjectId] = entry
+		return entry, nil
+	}
+
+	for classObjectId, objectIds := range a.hprof.classObjectId2objectIds {
+		if filter != nil {
+			for _, objectId := range objectIds {
+				if !filter(objectId) {
+					continue
+				}
+				entry, err := getEntry(classObjectId)
+				if err != nil {
+					return nil, err
+				}
+				size, err := a.GetShallowSize(objectId)
+				if err != nil {
+					return nil, err
+				}
+				entry.Count++
+				entry.ShallowSize += uint64(size)
+			}
+			continue
+		}
+		entry, err := getEntry(classObjectId)
+		if err != nil {
+			return nil, err
+		}
+		size, err := a.softSizeCalculator.CalcSoftSizeByClassObjectId(a.hprof, classObjectId)
+		if err != nil {
+			return nil, err
+		}
+		entry.Count += len(objectIds)
+		entry.ShallowSize += uint64(size)
+	}
+
+	for objectId, dump := range a.hprof.arrayObjectId2objectArrayDump {
+		if filter != nil && !filter(objectId) {
+			continue
+		}
+		entry, err := getEntry(dump.ArrayClassObjectId)
+		if err != nil {
+			return nil, err
+		}
+		size, err := a.GetShallowSize(objectId)
+		if err != nil {
+			return nil, err
+		}
+		entry.Count++
+		entry.ShallowSize += uint64(size)
+	}
+
+	for objectId, dump := range a.hprof.arrayObjectId2primitiveArrayDump {
+		if filter != nil && !filter(objectId) {
+			continue
+		}
+		name := GetPrimitiveArrayTypeName(dump.ElementType)
+		entry, ok := primitiveEntries[name]
+		if !ok {
+			entry = &HistogramEntry{Name: name}
+			primitiveEntries[name] = entry
+		}
+		size, err := a.GetShallowSize(objectId)
+		if err != nil {
+			return nil, err
+		}
+		entry.Count++
+		entry.ShallowSize += uint64(size)
+	}
+
+	var result []*HistogramEntry
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	for _, entry := range primitiveEntries {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ShallowSize != result[j].ShallowSize {
+			return result[i].ShallowSize > result[j].ShallowSize
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// DiffHistograms compares the histograms by the class name, ordered by the absolute shallow size delta descending.
+// Classes with the same name loaded by the different class loaders are merged.
+func DiffHistograms(base []*HistogramEntry, target []*HistogramEntry) []*HistogramDiff {
+	diffs := make(map[string]*HistogramDiff)
+	getDiff := func(name string) *HistogramDiff {
+		diff, ok := diffs[name]
+		if !ok {
+			diff = &HistogramDiff{Name: name}
+			diffs[name] = diff
+		}
+		return diff
+	}
+	for _, entry := range base {
+		diff := getDiff(entry.Name)
+		diff.BaseCount += entry.Count
+		diff.BaseShallowSize += entry.ShallowSize
+	}
+	for _, entry := range target {
+		diff := getDiff(entry.Name)
+		diff.Count += entry.Count
+		diff.ShallowSize += entry.ShallowSize
+	}
+
+	var result []*HistogramDiff
+	for _, diff := range diffs {
+		diff.CountDelta = diff.Count - diff.BaseCount
+		diff.ShallowSizeDelta = int64(diff.ShallowSize) - int64(diff.BaseShallowSize)
+		result = append(result, diff)
+	}
+	abs := func(n int64) int64 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if abs(result[i].ShallowSizeDelta) != abs(result[j].ShallowSizeDelta) {
+			return abs(result[i].ShallowSizeDelta) > abs(result[j].ShallowSizeDelta)
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -1,4 +1,4 @@
-package main
+package heapdump
 
 import (
 	"encoding/binary"
@@ -8,8 +8,10 @@ import (
 	"github.com/google/hprof-parser/parser"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/util"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 )
 
@@ -27,6 +29,9 @@ const (
 	keyPrefixRootJavaFrame             = "rootjavaframe-"
 	keyPrefixRootStickyClass           = "rootstickyclass-"
 	keyPrefixRootThreadObj             = "rootthreadobj-"
+	keyPrefixRootMonitorUsed           = "rootmonitorused-"
+	keyHprofMtime                      = "hprof_mtime"
+	keyIdentifierSize                  = "identifier_size"
 )
 
 type HProf struct {
@@ -44,6 +49,15 @@ type HProf struct {
 	rootThreadObj   map[uint64]bool
 	rootMonitorUsed map[uint64]bool
 	db              *leveldb.DB
+
+	// identifierSize is the size of the object IDs, 4 or 8.
+	identifierSize int
+
+	// referenceClasses are the strengths of the referents of the subclasses of java/lang/ref/Reference, by the class object ID.
+	referenceClasses       map[uint64]ReferenceStrength
+	referenceClassObjectId uint64
+	// referentNameId is the name ID of java/lang/ref/Reference.referent.
+	referentNameId uint64
 }
 
 func NewHProf(logger *Logger, indexFilePath string) (*HProf, error) {
@@ -62,6 +76,8 @@ func NewHProf(logger *Logger, indexFilePath string) (*HProf, error) {
 	m.rootStickyClass = make(map[uint64]bool)
 	m.rootThreadObj = make(map[uint64]bool)
 	m.rootMonitorUsed = make(map[uint64]bool)
+	m.identifierSize = 8
+	m.referenceClasses = make(map[uint64]ReferenceStrength)
 
 	db, err := leveldb.OpenFile(indexFilePath, nil)
 	if err != nil {
@@ -76,7 +92,7 @@ func (h HProf) Close() error {
 	return h.db.Close()
 }
 
-func (h HProf) ReadFile(heapFilePath string) error {
+func (h *HProf) ReadFile(heapFilePath string) error {
 	h.logger.Info("Opening %v", heapFilePath)
 
 	f, err := os.Open(heapFilePath)
@@ -86,12 +102,17 @@ func (h HProf) ReadFile(heapFilePath string) error {
 	defer f.Close()
 
 	p := parser.NewParser(f)
-	_, err = p.ParseHeader()
+	header, err := p.ParseHeader()
 	if err != nil {
-		return nil
+		return err
 	}
+	if header.IdentifierSize != 4 && header.IdentifierSize != 8 {
+		return fmt.Errorf("unsupported identifier size: %d", header.IdentifierSize)
+	}
+	h.identifierSize = int(header.IdentifierSize)
 
 	batch := new(leveldb.Batch)
+	batch.Put([]byte(keyIdentifierSize), []byte(strconv.Itoa(h.identifierSize)))
 
 	var prev int64
 	for {
@@ -125,13 +146,26 @@ func (h HProf) ReadFile(heapFilePath string) error {
 	if err != nil {
 		return err
 	}
-	batch.Put([]byte("hprof_mtime"), []byte(mtime))
+	batch.Put([]byte(keyHprofMtime), []byte(mtime))
 
 	if err := h.db.Write(batch, nil); err != nil {
 		return err
 	}
 
-	return nil
+	return h.scanReferenceClasses()
+}
+
+// isIndexOf returns true if the index is read from the heap dump file, comparing its modification time at that time.
+func (h *HProf) isIndexOf(fileName string) (bool, error) {
+	bs, err := h.db.Get([]byte(keyHprofMtime), nil)
+	if err != nil {
+		return false, err
+	}
+	mtime, err := getMtimeInString(fileName)
+	if err != nil {
+		return false, err
+	}
+	return string(bs) == mtime, nil
 }
 
 func getMtimeInString(fileName string) (string, error) {
@@ -172,34 +206,139 @@ func (h HProf) addRecord(record interface{}, batch *leveldb.Batch) error {
 	case *hprofdata.HProfRecordHeapDumpBoundary:
 		break
 	case *hprofdata.HProfClassDump:
+		// the parser stores the value left aligned in 8 bytes. Align the 4 bytes object IDs to the right.
+		for _, field := range o.StaticFields {
+			if field.Type == hprofdata.HProfValueType_OBJECT {
+				field.Value >>= uint(8-h.identifierSize) * 8
+			}
+		}
 		return writeRecord(batch, keyPrefixClass, o.ClassObjectId, o)
 	case *hprofdata.HProfInstanceDump: // HPROF_GC_INSTANCE_DUMP
-		h.classObjectId2objectIds[o.ClassObjectId] = append(h.classObjectId2objectIds[o.ClassObjectId], o.ObjectId)
-		h.objectId2instanceDump[o.ObjectId] = o
+		h.addInstanceDump(o)
+		return writeRecord(batch, keyPrefixInstance, o.ObjectId, o)
 	case *hprofdata.HProfObjectArrayDump:
 		arrayObjectId := o.GetArrayObjectId()
 		h.arrayObjectId2objectArrayDump[arrayObjectId] = o
+		return writeRecord(batch, keyPrefixObjectArray, arrayObjectId, o)
 	case *hprofdata.HProfPrimitiveArrayDump:
 		arrayObjectId := o.GetArrayObjectId()
 		h.arrayObjectId2primitiveArrayDump[arrayObjectId] = o
+		return writeRecord(batch, keyPrefixPrimitiveArray, arrayObjectId, o)
 	case *hprofdata.HProfRootJNIGlobal:
 		h.rootJniGlobals[o.GetObjectId()] = true
+		batch.Put(createKey(keyPrefixRootJNIGlobal, o.GetObjectId()), nil)
 	case *hprofdata.HProfRootJNILocal:
 		h.rootJniLocal[o.GetObjectId()] = true
+		batch.Put(createKey(keyPrefixRootJNILocal, o.GetObjectId()), nil)
 	case *hprofdata.HProfRootJavaFrame:
 		h.rootJavaFrame[o.GetObjectId()] = true
+		batch.Put(createKey(keyPrefixRootJavaFrame, o.GetObjectId()), nil)
 	case *hprofdata.HProfRootStickyClass:
 		h.rootStickyClass[o.GetObjectId()] = true
+		batch.Put(createKey(keyPrefixRootStickyClass, o.GetObjectId()), nil)
 	case *hprofdata.HProfRootThreadObj:
 		h.rootThreadObj[o.GetThreadObjectId()] = true
+		batch.Put(createKey(keyPrefixRootThreadObj, o.GetThreadObjectId()), nil)
 	case *hprofdata.HProfRootMonitorUsed:
 		h.rootMonitorUsed[o.GetObjectId()] = true
+		batch.Put(createKey(keyPrefixRootMonitorUsed, o.GetObjectId()), nil)
 	default:
 		h.logger.Warn("unknown record type!!: %#v", record)
 	}
 	return nil
 }
 
+func (h HProf) addInstanceDump(o *hprofdata.HProfInstanceDump) {
+	h.classObjectId2objectIds[o.ClassObjectId] = append(h.classObjectId2objectIds[o.ClassObjectId], o.ObjectId)
+	h.objectId2instanceDump[o.ObjectId] = o
+}
+
+// LoadIndex restores the in-memory maps from the index written by ReadFile.
+func (h *HProf) LoadIndex() error {
+	if _, err := h.db.Get([]byte(keyHprofMtime), nil); err != nil {
+		return fmt.Errorf("the index is not complete: %v", err)
+	}
+	// the index created by the older version doesn't have the identifier size.
+	if bs, err := h.db.Get([]byte(keyIdentifierSize), nil); err == nil {
+		size, err := strconv.Atoi(string(bs))
+		if err != nil {
+			return err
+		}
+		h.identifierSize = size
+	} else if err != errors.ErrNotFound {
+		return err
+	}
+
+	err := h.forEachProto(keyPrefixInstance, func() proto.Message {
+		return &hprofdata.HProfInstanceDump{}
+	}, func(m proto.Message) {
+		h.addInstanceDump(m.(*hprofdata.HProfInstanceDump))
+	})
+	if err != nil {
+		return err
+	}
+	err = h.forEachProto(keyPrefixObjectArray, func() proto.Message {
+		return &hprofdata.HProfObjectArrayDump{}
+	}, func(m proto.Message) {
+		o := m.(*hprofdata.HProfObjectArrayDump)
+		h.arrayObjectId2objectArrayDump[o.ArrayObjectId] = o
+	})
+	if err != nil {
+		return err
+	}
+	err = h.forEachProto(keyPrefixPrimitiveArray, func() proto.Message {
+		return &hprofdata.HProfPrimitiveArrayDump{}
+	}, func(m proto.Message) {
+		o := m.(*hprofdata.HProfPrimitiveArrayDump)
+		h.arrayObjectId2primitiveArrayDump[o.ArrayObjectId] = o
+	})
+	if err != nil {
+		return err
+	}
+
+	for prefix, roots := range map[string]map[uint64]bool{
+		keyPrefixRootJNIGlobal:   h.rootJniGlobals,
+		keyPrefixRootJNILocal:    h.rootJniLocal,
+		keyPrefixRootJavaFrame:   h.rootJavaFrame,
+		keyPrefixRootStickyClass: h.rootStickyClass,
+		keyPrefixRootThreadObj:   h.rootThreadObj,
+		keyPrefixRootMonitorUsed: h.rootMonitorUsed,
+	} {
+		iter := h.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+		for iter.Next() {
+			id, err := strconv.ParseUint(string(iter.Key()[len(prefix):]), 16, 64)
+			if err != nil {
+				iter.Release()
+				return err
+			}
+			roots[id] = true
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	return h.scanReferenceClasses()
+}
+
+// readObjectId reads the object ID at the head of the values of the instance.
+func (h HProf) readObjectId(values []byte) uint64 {
+	return readValue(values, h.identifierSize)
+}
+
+func (h HProf) forEachProto(prefix string, newMessage func() proto.Message, fn func(m proto.Message)) error {
+	iter := h.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		m := newMessage()
+		if err := proto.Unmarshal(iter.Value(), m); err != nil {
+			return err
+		}
+		fn(m)
+	}
+	return iter.Error()
+}
+
 func (h HProf) GetStringByNameId(id uint64) (string, error) {
 	bytes, err := h.db.Get(createKey(keyPrefixString, id), nil)
 	if err != nil {
@@ -248,3 +387,85 @@ func (h HProf) GetClassDumpByClassObjectId(classObjectId uint64) (*hprofdata.HPr
 	}
 	return &d, nil
 }
+
+// GetFieldName returns the name of the field. It never fails since it's used for diagnostics.
+func (h HProf) GetFieldName(nameId uint64) string {
+	name, err := h.GetStringByNameId(nameId)
+	if err != nil {
+		return "<unknown:" + strconv.FormatUint(nameId, 16) + ">"
+	}
+	return name
+}
+
+// HasObject returns true if the object ID is an instance, an array or a class in the heap dump.
+func (h HProf) HasObject(objectId uint64) (bool, error) {
+	if _, ok := h.objectId2instanceDump[objectId]; ok {
+		return true, nil
+	}
+	if _, ok := h.arrayObjectId2objectArrayDump[objectId]; ok {
+		return true, nil
+	}
+	if _, ok := h.arrayObjectId2primitiveArrayDump[objectId]; ok {
+		return true, nil
+	}
+	return h.db.Has(createKey(keyPrefixClass, objectId), nil)
+}
+
+// RootObjectIds returns the object IDs of all the GC roots, without duplicates.
+func (h HProf) RootObjectIds() []uint64 {
+	set := make(map[uint64]bool)
+	for _, roots := range []map[uint64]bool{
+		h.rootJniGlobals,
+		h.rootJniLocal,
+		h.rootJavaFrame,
+		h.rootStickyClass,
+		h.rootThreadObj,
+		h.rootMonitorUsed,
+	} {
+		for objectId := range roots {
+			set[objectId] = true
+		}
+	}
+	objectIds := keys(set)
+	sort.Slice(objectIds, func(i, j int) bool {
+		return objectIds[i] < objectIds[j]
+	})
+	return objectIds
+}
+
+// GetRootKinds returns the kinds of the GC root, e.g. "sticky class". It returns nil if the object isn't a GC root.
+func (h HProf) GetRootKinds(objectId uint64) []string {
+	var kinds []string
+	for _, root := range []struct {
+		kind  string
+		roots map[uint64]bool
+	}{
+		{"JNI global", h.rootJniGlobals},
+		{"JNI local", h.rootJniLocal},
+		{"Java frame", h.rootJavaFrame},
+		{"sticky class", h.rootStickyClass},
+		{"thread object", h.rootThreadObj},
+		{"monitor used", h.rootMonitorUsed},
+	} {
+		if root.roots[objectId] {
+			kinds = append(kinds, root.kind)
+		}
+	}
+	return kinds
+}
+
+// ForEachClassDump calls fn for each class dump, including the classes without any instances.
+func (h HProf) ForEachClassDump(fn func(classDump *hprofdata.HProfClassDump) error) error {
+	iter := h.db.NewIterator(util.BytesPrefix([]byte(keyPrefixClass)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var classDump hprofdata.HProfClassDump
+		if err := proto.Unmarshal(iter.Value(), &classDump); err != nil {
+			return err
+		}
+		if err := fn(&classDump); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
@@ -0,0 +1,378 @@
+package heapdump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"io"
+	"os"
+)
+
+// HProfBuilder builds the synthetic heap dump, to test the exact object graph without the JDK.
+// Declare the classes, the instances, the arrays and the GC roots, then write them by WriteTo. e.g.
+//
+//	b := NewHProfBuilder(8)
+//	object := b.AddClass("java/lang/Object", 0)
+//	node := b.AddClass("Node", object, HProfBuilderField{"next", hprofdata.HProfValueType_OBJECT})
+//	n1 := b.AddInstance(node)
+//	n2 := b.AddInstance(node)
+//	b.SetField(n1, "next", n2)
+//	b.SetField(n2, "next", n1)
+//	b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, n1)
+//
+// The IDs are allocated in the order of the calls, so the IDs of the objects declared later are larger.
+type HProfBuilder struct {
+	identifierSize int
+	nextId         uint64
+	// string -> string ID
+	strings     map[string]uint64
+	stringOrder []string
+	classes     []*builderClass
+	// class object ID -> class
+	classIndex map[uint64]*builderClass
+	// the instances and the arrays in the order of the declaration
+	objects []*builderObject
+	// object ID -> object
+	objectIndex map[uint64]*builderObject
+	roots       []builderRoot
+}
+
+// HProfBuilderField is a field of the class declared by HProfBuilder.AddClass.
+type HProfBuilderField struct {
+	Name string
+	Type hprofdata.HProfValueType
+}
+
+type builderClass struct {
+	classObjectId      uint64
+	name               string
+	superClassObjectId uint64
+	classLoaderId      uint64
+	fields             []HProfBuilderField
+	staticFields       []HProfBuilderField
+	staticValues       []uint64
+}
+
+type builderObject struct {
+	objectId      uint64
+	classObjectId uint64
+	// values of the instance fields, or the elements of the arrays
+	values      []uint64
+	elementType hprofdata.HProfValueType
+	kind        ObjectKind
+}
+
+type builderRoot struct {
+	recordType parser.HProfHDRecordType
+	objectId   uint64
+}
+
+// NewHProfBuilder returns the builder of the heap dump whose object IDs are identifierSize bytes, 4 or 8.
+func NewHProfBuilder(identifierSize int) *HProfBuilder {
+	m := new(HProfBuilder)
+	m.identifierSize = identifierSize
+	m.nextId = 0x1000
+	m.strings = make(map[string]uint64)
+	m.classIndex = make(map[uint64]*builderClass)
+	m.objectIndex = make(map[uint64]*builderObject)
+	return m
+}
+
+func (b *HProfBuilder) newId() uint64 {
+	id := b.nextId
+	b.nextId += 16
+	return id
+}
+
+func (b *HProfBuilder) stringId(s string) uint64 {
+	if id, ok := b.strings[s]; ok {
+		return id
+	}
+	id := b.newId()
+	b.strings[s] = id
+	b.stringOrder = append(b.stringOrder, s)
+	return id
+}
+
+// AddClass declares the class and returns its class object ID. The super class is 0 for java/lang/Object.
+// The name is in the internal form, e.g. "java/lang/String", "[Ljava/lang/Object;".
+func (b *HProfBuilder) AddClass(name string, superClassObjectId uint64, fields ...HProfBuilderField) uint64 {
+	class := &builderClass{
+		classObjectId:      b.newId(),
+		name:               name,
+		superClassObjectId: superClassObjectId,
+		fields:             fields,
+	}
+	b.classes = append(b.classes, class)
+	b.classIndex[class.classObjectId] = class
+	return class.classObjectId
+}
+
+// AddStaticField declares the static field of the class with the value. The value is the object ID for the objects.
+func (b *HProfBuilder) AddStaticField(classObjectId uint64, name string, valueType hprofdata.HProfValueType, value uint64) {
+	class := b.classIndex[classObjectId]
+	class.staticFields = append(class.staticFields, HProfBuilderField{Name: name, Type: valueType})
+	class.staticValues = append(class.staticValues, value)
+}
+
+// SetClassLoader sets the class loader of the class. It is the bootstrap class loader by default.
+func (b *HProfBuilder) SetClassLoader(classObjectId uint64, classLoaderObjectId uint64) {
+	b.classIndex[classObjectId].classLoaderId = classLoaderObjectId
+}
+
+// instanceFields returns the instance fields of the class, including the fields of the super classes,
+// in the order of the values of the instance dump.
+func (b *HProfBuilder) instanceFields(classObjectId uint64) []HProfBuilderField {
+	var fields []HProfBuilderField
+	for class := b.classIndex[classObjectId]; class != nil; class = b.classIndex[class.superClassObjectId] {
+		fields = append(fields, class.fields...)
+	}
+	return fields
+}
+
+// AddInstance declares the instance of the class and returns its object ID. The fields are zero, i.e. null.
+func (b *HProfBuilder) AddInstance(classObjectId uint64) uint64 {
+	object := &builderObject{
+		objectId:      b.newId(),
+		classObjectId: classObjectId,
+		values:        make([]uint64, len(b.instanceFields(classObjectId))),
+		kind:          ObjectKind_INSTANCE,
+	}
+	b.objects = append(b.objects, object)
+	b.objectIndex[object.objectId] = object
+	return object.objectId
+}
+
+// SetField sets the instance field, including the fields of the super classes. The value is the object ID for the objects,
+// and the bits of the value for the primitives, e.g. math.Float64bits(d). It panics if the instance doesn't have the field.
+func (b *HProfBuilder) SetField(objectId uint64, name string, value uint64) {
+	object := b.objectIndex[objectId]
+	for i, field := range b.instanceFields(object.classObjectId) {
+		if field.Name == name {
+			object.values[i] = value
+			return
+		}
+	}
+	panic(fmt.Sprintf("object %v doesn't have the field %v", objectId, name))
+}
+
+// AddObjectArray declares the object array of the array class, e.g. "[Ljava/lang/Object;", and returns its object ID.
+// The elements are the object IDs, or 0 for null.
+func (b *HProfBuilder) AddObjectArray(arrayClassObjectId uint64, elements ...uint64) uint64 {
+	object := &builderObject{
+		objectId:      b.newId(),
+		classObjectId: arrayClassObjectId,
+		values:        elements,
+		kind:          ObjectKind_OBJECT_ARRAY,
+	}
+	b.objects = append(b.objects, object)
+	b.objectIndex[object.objectId] = object
+	return object.objectId
+}
+
+// AddPrimitiveArray declares the primitive array and returns its object ID. The elements are the bits of the values.
+func (b *HProfBuilder) AddPrimitiveArray(elementType hprofdata.HProfValueType, elements ...uint64) uint64 {
+	object := &builderObject{
+		objectId:    b.newId(),
+		values:      elements,
+		elementType: elementType,
+		kind:        ObjectKind_PRIMITIVE_ARRAY,
+	}
+	b.objects = append(b.objects, object)
+	b.objectIndex[object.objectId] = object
+	return object.objectId
+}
+
+// AddRoot declares the GC root, e.g. parser.HProfHDRecordTypeRootJNIGlobal, parser.HProfHDRecordTypeRootStickyClass.
+func (b *HProfBuilder) AddRoot(recordType parser.HProfHDRecordType, objectId uint64) {
+	b.roots = append(b.roots, builderRoot{recordType: recordType, objectId: objectId})
+}
+
+// builderBuffer encodes the values of the hprof in big endian.
+type builderBuffer struct {
+	bytes.Buffer
+	identifierSize int
+}
+
+func (w *builderBuffer) u1(v byte) {
+	w.WriteByte(v)
+}
+
+func (w *builderBuffer) u2(v int) {
+	w.value(2, uint64(v))
+}
+
+func (w *builderBuffer) u4(v int) {
+	w.value(4, uint64(v))
+}
+
+func (w *builderBuffer) id(v uint64) {
+	w.value(w.identifierSize, v)
+}
+
+func (w *builderBuffer) value(size int, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	w.Write(buf[8-size:])
+}
+
+func (w *builderBuffer) typedValue(valueType hprofdata.HProfValueType, v uint64) {
+	if valueType == hprofdata.HProfValueType_OBJECT {
+		w.id(v)
+	} else {
+		w.value(parser.ValueSize[valueType], v)
+	}
+}
+
+func (b *HProfBuilder) valueSize(valueType hprofdata.HProfValueType) int {
+	if valueType == hprofdata.HProfValueType_OBJECT {
+		return b.identifierSize
+	}
+	return parser.ValueSize[valueType]
+}
+
+// WriteTo writes the heap dump in the hprof format of JDK 6, with a heap dump segment.
+func (b *HProfBuilder) WriteTo(w io.Writer) (int64, error) {
+	if b.identifierSize != 4 && b.identifierSize != 8 {
+		return 0, fmt.Errorf("unsupported identifier size: %d", b.identifierSize)
+	}
+	// register the names before writing the strings.
+	for _, class := range b.classes {
+		b.stringId(class.name)
+		for _, field := range class.staticFields {
+			b.stringId(field.Name)
+		}
+		for _, field := range class.fields {
+			b.stringId(field.Name)
+		}
+	}
+
+	out := &builderBuffer{identifierSize: b.identifierSize}
+	out.WriteString("JAVA PROFILE 1.0.2")
+	out.u1(0)
+	out.u4(b.identifierSize)
+	out.value(8, 0)
+
+	record := &builderBuffer{identifierSize: b.identifierSize}
+	writeRecord := func(recordType parser.HProfRecordType) {
+		out.u1(byte(recordType))
+		out.u4(0)
+		out.u4(record.Len())
+		out.Write(record.Bytes())
+		record.Reset()
+	}
+	for _, s := range b.stringOrder {
+		record.id(b.strings[s])
+		record.WriteString(s)
+		writeRecord(parser.HProfRecordTypeUTF8)
+	}
+	for i, class := range b.classes {
+		record.u4(i + 1)
+		record.id(class.classObjectId)
+		record.u4(0)
+		record.id(b.strings[class.name])
+		writeRecord(parser.HProfRecordTypeLoadClass)
+	}
+
+	for _, root := range b.roots {
+		record.u1(byte(root.recordType))
+		record.id(root.objectId)
+		switch root.recordType {
+		case parser.HProfHDRecordTypeRootJNIGlobal:
+			record.id(0)
+		case parser.HProfHDRecordTypeRootJNILocal, parser.HProfHDRecordTypeRootJavaFrame, parser.HProfHDRecordTypeRootThreadObj:
+			record.u4(0)
+			record.u4(0)
+		case parser.HProfHDRecordTypeRootNativeStack, parser.HProfHDRecordTypeRootThreadBlock:
+			record.u4(0)
+		}
+	}
+	for _, class := range b.classes {
+		instanceSize := 0
+		for _, field := range b.instanceFields(class.classObjectId) {
+			instanceSize += b.valueSize(field.Type)
+		}
+		record.u1(byte(parser.HProfHDRecordTypeClassDump))
+		record.id(class.classObjectId)
+		record.u4(0)
+		record.id(class.superClassObjectId)
+		record.id(class.classLoaderId)
+		// signers, protection domain and reserved
+		for i := 0; i < 4; i++ {
+			record.id(0)
+		}
+		record.u4(instanceSize)
+		// constant pool
+		record.u2(0)
+		record.u2(len(class.staticFields))
+		for i, field := range class.staticFields {
+			record.id(b.strings[field.Name])
+			record.u1(byte(field.Type))
+			record.typedValue(field.Type, class.staticValues[i])
+		}
+		record.u2(len(class.fields))
+		for _, field := range class.fields {
+			record.id(b.strings[field.Name])
+			record.u1(byte(field.Type))
+		}
+	}
+	for _, object := range b.objects {
+		switch object.kind {
+		case ObjectKind_INSTANCE:
+			fields := b.instanceFields(object.classObjectId)
+			values := &builderBuffer{identifierSize: b.identifierSize}
+			for i, field := range fields {
+				values.typedValue(field.Type, object.values[i])
+			}
+			record.u1(byte(parser.HProfHDRecordTypeInstanceDump))
+			record.id(object.objectId)
+			record.u4(0)
+			record.id(object.classObjectId)
+			record.u4(values.Len())
+			record.Write(values.Bytes())
+		case ObjectKind_OBJECT_ARRAY:
+			record.u1(byte(parser.HProfHDRecordTypeObjectArrayDump))
+			record.id(object.objectId)
+			record.u4(0)
+			record.u4(len(object.values))
+			record.id(object.classObjectId)
+			for _, v := range object.values {
+				record.id(v)
+			}
+		case ObjectKind_PRIMITIVE_ARRAY:
+			record.u1(byte(parser.HProfHDRecordTypePrimitiveArrayDump))
+			record.id(object.objectId)
+			record.u4(0)
+			record.u4(len(object.values))
+			record.u1(byte(object.elementType))
+			for _, v := range object.values {
+				record.typedValue(object.elementType, v)
+			}
+		}
+	}
+	writeRecord(parser.HProfRecordTypeHeapDumpSegment)
+	writeRecord(parser.HProfRecordTypeHeapDumpEnd)
+
+	return out.WriteTo(w)
+}
+
+// WriteFile writes the heap dump to the file.
+func (b *HProfBuilder) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if _, err := b.WriteTo(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,147 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func builderTestData(t *testing.T, b *HProfBuilder) *Tester {
+	dir, err := ioutil.TempDir("", "builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the heap dump is read into the index by NewTester.
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "heapdump.hprof")
+	if err := b.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return NewTester(path, t)
+}
+
+// nodeClass declares Node { Node next; int value; }. The shallow size of Node is 16 + identifierSize + 4.
+func nodeClass(b *HProfBuilder) uint64 {
+	object := b.AddClass("java/lang/Object", 0)
+	return b.AddClass("Node", object,
+		HProfBuilderField{"next", hprofdata.HProfValueType_OBJECT},
+		HProfBuilderField{"value", hprofdata.HProfValueType_INT})
+}
+
+func assertRetainedSizes(t *testing.T, tester *Tester, expected map[uint64]uint64) {
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for objectId, expectedRetainedSize := range expected {
+		if !tree.Contains(objectId) {
+			t.Fatalf("%v should be reachable", objectId)
+		}
+		if size := tree.RetainedSize(objectId); size != expectedRetainedSize {
+			t.Errorf("%v should retain %v bytes. But %v", objectId, expectedRetainedSize, size)
+		}
+	}
+}
+
+func TestHProfBuilderCycle(t *testing.T) {
+	b := NewHProfBuilder(8)
+	node := nodeClass(b)
+	n1 := b.AddInstance(node)
+	n2 := b.AddInstance(node)
+	n3 := b.AddInstance(node)
+	b.SetField(n1, "next", n2)
+	b.SetField(n2, "next", n3)
+	b.SetField(n3, "next", n1)
+	b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, n1)
+	tester := builderTestData(t, b)
+	defer tester.Close()
+
+	assertRetainedSizes(t, tester, map[uint64]uint64{
+		n1: 28 * 3,
+		n2: 28 * 2,
+		n3: 28,
+	})
+}
+
+func TestHProfBuilderSharedReference(t *testing.T) {
+	b := NewHProfBuilder(8)
+	node := nodeClass(b)
+	shared := b.AddInstance(node)
+	n1 := b.AddInstance(node)
+	n2 := b.AddInstance(node)
+	b.SetField(n1, "next", shared)
+	b.SetField(n2, "next", shared)
+	b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, n1)
+	b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, n2)
+	tester := builderTestData(t, b)
+	defer tester.Close()
+
+	assertRetainedSizes(t, tester, map[uint64]uint64{
+		n1:     28,
+		n2:     28,
+		shared: 28,
+	})
+}
+
+func TestHProfBuilderDeepRecursion(t *testing.T) {
+	const length = 10000
+	b := NewHProfBuilder(8)
+	node := nodeClass(b)
+	head := b.AddInstance(node)
+	prev := head
+	for i := 1; i < length; i++ {
+		n := b.AddInstance(node)
+		b.SetField(prev, "next", n)
+		b.SetField(n, "value", uint64(i))
+		prev = n
+	}
+	b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, head)
+	tester := builderTestData(t, b)
+	defer tester.Close()
+
+	assertRetainedSizes(t, tester, map[uint64]uint64{
+		head: 28 * length,
+		prev: 28,
+	})
+}
+
+func TestHProfBuilderIdentifierSize4(t *testing.T) {
+	b := NewHProfBuilder(4)
+	node := nodeClass(b)
+	objectArray := b.AddClass("[LNode;", 0)
+	holder := b.AddClass("Holder", 0)
+	n1 := b.AddInstance(node)
+	n2 := b.AddInstance(node)
+	b.SetField(n1, "next", n2)
+	b.SetField(n1, "value", 42)
+	array := b.AddObjectArray(objectArray, n1, 0, n2)
+	values := b.AddPrimitiveArray(hprofdata.HProfValueType_LONG, 1, 2)
+	b.AddStaticField(holder, "nodes", hprofdata.HProfValueType_OBJECT, array)
+	b.AddStaticField(holder, "values", hprofdata.HProfValueType_OBJECT, values)
+	b.AddRoot(parser.HProfHDRecordTypeRootStickyClass, holder)
+	tester := builderTestData(t, b)
+	defer tester.Close()
+
+	// Node: 16 + 4 + 4, Node[3]: 3 * 4, long[2]: 2 * 8, Holder: 2 * 4
+	assertRetainedSizes(t, tester, map[uint64]uint64{
+		n1:     24,
+		n2:     24,
+		array:  12 + 24*2,
+		values: 16,
+		holder: 8 + 12 + 24*2 + 16,
+	})
+
+	object, err := tester.analyzer.GetObject(n1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := object.GetField("next"); next == nil || next.Value != n2 {
+		t.Errorf("Node.next should be %v. But %v", n2, next)
+	}
+	if value := object.GetField("value"); value == nil || value.Value != 42 {
+		t.Errorf("Node.value should be 42. But %v", value)
+	}
+}
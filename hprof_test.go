@@ -0,0 +1,37 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func TestReadObjectId(t *testing.T) {
+	values := []byte{0, 0, 0, 5, 0, 0, 0, 6}
+	// the object IDs were always read as 8 bytes, with the following field of the dumps with 4 bytes IDs.
+	if objectId := (HProf{identifierSize: 4}).readObjectId(values); objectId != 5 {
+		t.Errorf("The object ID of 4 bytes should be 5. But %x", objectId)
+	}
+	if objectId := (HProf{identifierSize: 8}).readObjectId(values); objectId != 5<<32|6 {
+		t.Errorf("The object ID of 8 bytes should be 500000006. But %x", objectId)
+	}
+}
+
+func TestAddRecordStaticObjectId(t *testing.T) {
+	// the parser stores the 4 bytes object ID of the static field left aligned in 8 bytes.
+	classDump := &hprofdata.HProfClassDump{
+		ClassObjectId: 1,
+		StaticFields: []*hprofdata.HProfClassDump_StaticField{
+			{Type: hprofdata.HProfValueType_OBJECT, Value: 5 << 32},
+			{Type: hprofdata.HProfValueType_INT, Value: 7 << 32},
+		},
+	}
+	h := HProf{identifierSize: 4}
+	if err := h.addRecord(classDump, new(leveldb.Batch)); err != nil {
+		t.Fatal(err)
+	}
+	if classDump.StaticFields[0].Value != 5 || classDump.StaticFields[1].Value != 7<<32 {
+		t.Errorf("The object ID should be aligned to the right. But %x, %x",
+			classDump.StaticFields[0].Value, classDump.StaticFields[1].Value)
+	}
+}
@@ -0,0 +1,144 @@
+package heapdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"sort"
+	"unicode/utf16"
+)
+
+const javaLangString = "java/lang/String"
+
+// DuplicateString is a string value held by multiple java/lang/String instances.
+type DuplicateString struct {
+	Value     string
+	ObjectIds []uint64
+	// WastedSize is the shallow size of the duplicated instances and their value arrays, except the first one.
+	WastedSize uint64
+}
+
+// GetStringValue decodes the java/lang/String instance.
+// It supports both of the char[] based String and the compact String(JDK 9+) with the coder field.
+func (h HProf) GetStringValue(objectId uint64) (string, error) {
+	valueArray, coder, err := h.getStringFields(objectId)
+	if err != nil {
+		return "", err
+	}
+	if valueArray == nil {
+		return "", nil
+	}
+
+	switch valueArray.ElementType {
+	case hprofdata.HProfValueType_CHAR:
+		chars := make([]uint16, len(valueArray.Values)/2)
+		for i := range chars {
+			chars[i] = binary.BigEndian.Uint16(valueArray.Values[i*2:])
+		}
+		return string(utf16.Decode(chars)), nil
+	case hprofdata.HProfValueType_BYTE:
+		if coder == 0 { // LATIN1
+			runes := make([]rune, len(valueArray.Values))
+			for i, b := range valueArray.Values {
+				runes[i] = rune(b)
+			}
+			return string(runes), nil
+		}
+		// UTF16 in the native byte order. The heap dumps are taken on little endian machines mostly.
+		chars := make([]uint16, len(valueArray.Values)/2)
+		for i := range chars {
+			chars[i] = binary.LittleEndian.Uint16(valueArray.Values[i*2:])
+		}
+		return string(utf16.Decode(chars)), nil
+	}
+	return "", fmt.Errorf("unexpected String.value type: objectId=%v type=%v", objectId, valueArray.ElementType)
+}
+
+func (h HProf) getStringFields(objectId uint64) (*hprofdata.HProfPrimitiveArrayDump, uint64, error) {
+	instanceDump := h.objectId2instanceDump[objectId]
+	if instanceDump == nil {
+		return nil, 0, fmt.Errorf("not an instance: objectId=%v", objectId)
+	}
+	fields, err := h.GetInstanceFields(instanceDump)
+	if err != nil {
+		return nil, 0, err
+	}
+	var valueArray *hprofdata.HProfPrimitiveArrayDump
+	coder := uint64(0)
+	for _, field := range fields {
+		switch field.Name {
+		case "value":
+			valueArray = h.arrayObjectId2primitiveArrayDump[field.Value]
+		case "coder":
+			coder = field.Value
+		}
+	}
+	return valueArray, coder, nil
+}
+
+// ForEachString calls fn for each java/lang/String instance.
+func (a HeapDumpAnalyzer) ForEachString(fn func(objectId uint64, value string) error) error {
+	classObjectIds, err := a.FindClassObjectIdsByName(javaLangString)
+	if err != nil {
+		return err
+	}
+	for _, classObjectId := range classObjectIds {
+		err := a.ForEachInstance(classObjectId, func(objectId uint64) error {
+			value, err := a.hprof.GetStringValue(objectId)
+			if err != nil {
+				return err
+			}
+			return fn(objectId, value)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DuplicateStrings returns the string values held by multiple instances, ordered by the wasted size descending.
+func (a HeapDumpAnalyzer) DuplicateStrings() ([]*DuplicateString, error) {
+	value2objectIds := make(map[string][]uint64)
+	err := a.ForEachString(func(objectId uint64, value string) error {
+		value2objectIds[value] = append(value2objectIds[value], objectId)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*DuplicateString
+	for value, objectIds := range value2objectIds {
+		if len(objectIds) < 2 {
+			continue
+		}
+		duplicate := &DuplicateString{Value: value, ObjectIds: objectIds}
+		for _, objectId := range objectIds[1:] {
+			size, err := a.GetShallowSize(objectId)
+			if err != nil {
+				return nil, err
+			}
+			duplicate.WastedSize += uint64(size)
+			valueArray, _, err := a.hprof.getStringFields(objectId)
+			if err != nil {
+				return nil, err
+			}
+			if valueArray != nil {
+				size, err := a.GetShallowSize(valueArray.ArrayObjectId)
+				if err != nil {
+					return nil, err
+				}
+				duplicate.WastedSize += uint64(size)
+			}
+		}
+		result = append(result, duplicate)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].WastedSize != result[j].WastedSize {
+			return result[i].WastedSize > result[j].WastedSize
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result, nil
+}
@@ -1,4 +1,4 @@
-package main
+package heapdump
 
 import (
 	"log"
@@ -74,7 +74,3 @@ func (a *Logger) Error(msg string, v ...interface{}) {
 func (a *Logger) IsDebugEnabled() bool {
 	return a.level <= LogLevel_DEBUG
 }
-
-func (a *Logger) Fatalf(msg string, v ...interface{}) {
-	log.Fatalf("[ERROR] "+a.spaces()+msg, v...)
-}
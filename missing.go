@@ -0,0 +1,78 @@
+package heapdump
+
+import (
+	"errors"
+	"sort"
+)
+
+// errObjectNotFound is returned when the object ID isn't in any of the instance, array or class maps.
+// Real heap dumps contain such references, e.g. to the objects in unparsed regions.
+var errObjectNotFound = errors.New("object not found in heap dump")
+
+// errStopIteration stops the iteration in the callbacks. It's never returned to the callers.
+var errStopIteration = errors.New("stop iteration")
+
+// MissingReference is a reference from a field of the referrer to an object which isn't in the heap dump.
+type MissingReference struct {
+	ReferrerObjectId uint64
+	Field            string
+	ObjectId         uint64
+}
+
+type MissingObjects struct {
+	references map[uint64][]*MissingReference // missing object ID -> references
+}
+
+func NewMissingObjects() *MissingObjects {
+	m := new(MissingObjects)
+	m.references = make(map[uint64][]*MissingReference)
+	return m
+}
+
+func (m MissingObjects) Add(referrerObjectId uint64, field string, objectId uint64) {
+	m.references[objectId] = append(m.references[objectId], &MissingReference{
+		ReferrerObjectId: referrerObjectId,
+		Field:            field,
+		ObjectId:         objectId,
+	})
+}
+
+func (m MissingObjects) HasKey(objectId uint64) bool {
+	_, ok := m.references[objectId]
+	return ok
+}
+
+// Size returns the number of the missing objects.
+func (m MissingObjects) Size() int {
+	return len(m.references)
+}
+
+// References returns all the missing references, ordered by the missing object ID.
+func (m MissingObjects) References() []*MissingReference {
+	var objectIds []uint64
+	for objectId := range m.references {
+		objectIds = append(objectIds, objectId)
+	}
+	sort.Slice(objectIds, func(i, j int) bool {
+		return objectIds[i] < objectIds[j]
+	})
+
+	var result []*MissingReference
+	for _, objectId := range objectIds {
+		result = append(result, m.references[objectId]...)
+	}
+	return result
+}
+
+func (m MissingObjects) LogSummary(logger *Logger) {
+	if m.Size() == 0 {
+		return
+	}
+	references := m.References()
+	logger.Warn("%v references point to %v missing objects. They are excluded from the size totals.",
+		len(references), m.Size())
+	for _, ref := range references {
+		logger.Debug("missing object: objectId=%v referrer=%v field=%v",
+			ref.ObjectId, ref.ReferrerObjectId, ref.Field)
+	}
+}
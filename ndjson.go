@@ -0,0 +1,215 @@
+package heapdump
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// NDJSONObject is a line written by WriteNDJSON. Kind is the kind of the object, e.g. "instance", or "root" for the GC roots.
+type NDJSONObject struct {
+	ObjectId      uint64 `json:"id"`
+	Kind          string `json:"kind"`
+	ClassObjectId uint64 `json:"classId,omitempty"`
+	ClassName     string `json:"className,omitempty"`
+	ShallowSize   int    `json:"shallowSize"`
+	// Fields are the instance fields for the instances, and the static fields for the classes.
+	Fields []*NDJSONField `json:"fields,omitempty"`
+	// Length and ElementType are only for the arrays.
+	Length      *int   `json:"length,omitempty"`
+	ElementType string `json:"elementType,omitempty"`
+	// References are the non-null outgoing references.
+	References []*NDJSONReference `json:"references,omitempty"`
+	// RootKind is the kind of the GC root, e.g. "JNI global". Only for the roots.
+	RootKind string `json:"rootKind,omitempty"`
+}
+
+// NDJSONField is a decoded field. Value is the number, the boolean, the string of the char, or the object ID.
+type NDJSONField struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// NDJSONReference is an outgoing reference, named as GetReferenceName, e.g. "next", "[3]", "static cache", "<super>".
+type NDJSONReference struct {
+	Name     string `json:"name"`
+	ObjectId uint64 `json:"objectId"`
+}
+
+// ndjsonWriter keeps the names and the class dumps, which are written before the objects by the JVM.
+type ndjsonWriter struct {
+	encoder        *json.Encoder
+	identifierSize int
+	names          map[uint64]string
+	// class object ID -> class name ID
+	classNameIds map[uint64]uint64
+	classes      map[uint64]*hprofdata.HProfClassDump
+}
+
+// WriteNDJSON reads the hprof file and writes a JSON line for each object and GC root in the order of the heap dump.
+// It keeps only the strings and the class dumps in memory, so it works on the heap dumps bigger than the memory,
+// without the index. The fields of the instances whose class dump is written after them are not decoded.
+func WriteNDJSON(logger *Logger, hprofPath string, w io.Writer) error {
+	f, err := os.Open(hprofPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p := parser.NewParser(f)
+	header, err := p.ParseHeader()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	n := &ndjsonWriter{
+		encoder:        json.NewEncoder(bw),
+		identifierSize: int(header.IdentifierSize),
+		names:          make(map[uint64]string),
+		classNameIds:   make(map[uint64]uint64),
+		classes:        make(map[uint64]*hprofdata.HProfClassDump),
+	}
+	n.encoder.SetEscapeHTML(false)
+	for {
+		record, err := p.ParseRecord()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			logger.Warn("Got parsing issue: %v", err)
+			continue
+		}
+		if err := n.write(record); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (n *ndjsonWriter) className(classObjectId uint64) string {
+	return n.names[n.classNameIds[classObjectId]]
+}
+
+func (n *ndjsonWriter) valueSize(valueType hprofdata.HProfValueType) int {
+	if valueType == hprofdata.HProfValueType_OBJECT {
+		return n.identifierSize
+	}
+	return parser.ValueSize[valueType]
+}
+
+func (n *ndjsonWriter) field(name string, valueType hprofdata.HProfValueType, value uint64) *NDJSONField {
+	f := &Field{Name: name, Type: valueType, Value: value}
+	v := fieldValue(f)
+	switch v := v.(type) {
+	case queryObject:
+		return &NDJSONField{Name: name, Type: valueType.String(), Value: uint64(v)}
+	case float64:
+		// JSON doesn't have NaN and Infinity.
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return &NDJSONField{Name: name, Type: valueType.String(), Value: f.String()}
+		}
+	}
+	return &NDJSONField{Name: name, Type: valueType.String(), Value: v}
+}
+
+func (n *ndjsonWriter) root(objectId uint64, kind string) error {
+	return n.encoder.Encode(&NDJSONObject{ObjectId: objectId, Kind: "root", RootKind: kind})
+}
+
+func (n *ndjsonWriter) write(record interface{}) error {
+	switch o := record.(type) {
+	case *hprofdata.HProfRecordUTF8:
+		n.names[o.NameId] = string(o.Name)
+	case *hprofdata.HProfRecordLoadClass:
+		n.classNameIds[o.ClassObjectId] = o.ClassNameId
+	case *hprofdata.HProfClassDump:
+		n.classes[o.ClassObjectId] = o
+		object := &NDJSONObject{ObjectId: o.ClassObjectId, Kind: ObjectKind_CLASS.String(), ClassName: n.className(o.ClassObjectId)}
+		for _, field := range o.StaticFields {
+			size := n.valueSize(field.Type)
+			object.ShallowSize += size
+			// the parser stores the value left aligned in 8 bytes.
+			value := field.Value >> (uint(8-size) * 8)
+			name := n.names[field.NameId]
+			object.Fields = append(object.Fields, n.field(name, field.Type, value))
+			if field.Type == hprofdata.HProfValueType_OBJECT && value != 0 {
+				object.References = append(object.References, &NDJSONReference{Name: "static " + name, ObjectId: value})
+			}
+		}
+		if o.SuperClassObjectId != 0 {
+			object.References = append(object.References, &NDJSONReference{Name: "<super>", ObjectId: o.SuperClassObjectId})
+		}
+		return n.encoder.Encode(object)
+	case *hprofdata.HProfInstanceDump:
+		object := &NDJSONObject{
+			ObjectId:      o.ObjectId,
+			Kind:          ObjectKind_INSTANCE.String(),
+			ClassObjectId: o.ClassObjectId,
+			ClassName:     n.className(o.ClassObjectId),
+			ShallowSize:   16 + len(o.Values),
+		}
+		idx := 0
+		for classDump := n.classes[o.ClassObjectId]; classDump != nil; classDump = n.classes[classDump.SuperClassObjectId] {
+			for _, field := range classDump.InstanceFields {
+				size := n.valueSize(field.Type)
+				if idx+size > len(o.Values) {
+					break
+				}
+				value := readValue(o.Values[idx:], size)
+				idx += size
+				name := n.names[field.NameId]
+				object.Fields = append(object.Fields, n.field(name, field.Type, value))
+				if field.Type == hprofdata.HProfValueType_OBJECT && value != 0 {
+					object.References = append(object.References, &NDJSONReference{Name: name, ObjectId: value})
+				}
+			}
+		}
+		return n.encoder.Encode(object)
+	case *hprofdata.HProfObjectArrayDump:
+		length := len(o.ElementObjectIds)
+		object := &NDJSONObject{
+			ObjectId:      o.ArrayObjectId,
+			Kind:          ObjectKind_OBJECT_ARRAY.String(),
+			ClassObjectId: o.ArrayClassObjectId,
+			ClassName:     n.className(o.ArrayClassObjectId),
+			ShallowSize:   length * n.identifierSize,
+			Length:        &length,
+			ElementType:   hprofdata.HProfValueType_OBJECT.String(),
+		}
+		for i, objectId := range o.ElementObjectIds {
+			if objectId != 0 {
+				object.References = append(object.References, &NDJSONReference{Name: "[" + strconv.Itoa(i) + "]", ObjectId: objectId})
+			}
+		}
+		return n.encoder.Encode(object)
+	case *hprofdata.HProfPrimitiveArrayDump:
+		length := len(o.Values) / parser.ValueSize[o.ElementType]
+		return n.encoder.Encode(&NDJSONObject{
+			ObjectId:    o.ArrayObjectId,
+			Kind:        ObjectKind_PRIMITIVE_ARRAY.String(),
+			ClassName:   GetPrimitiveArrayTypeName(o.ElementType),
+			ShallowSize: len(o.Values),
+			Length:      &length,
+			ElementType: o.ElementType.String(),
+		})
+	case *hprofdata.HProfRootJNIGlobal:
+		return n.root(o.ObjectId, "JNI global")
+	case *hprofdata.HProfRootJNILocal:
+		return n.root(o.ObjectId, "JNI local")
+	case *hprofdata.HProfRootJavaFrame:
+		return n.root(o.ObjectId, "Java frame")
+	case *hprofdata.HProfRootStickyClass:
+		return n.root(o.ObjectId, "sticky class")
+	case *hprofdata.HProfRootThreadObj:
+		return n.root(o.ThreadObjectId, "thread object")
+	case *hprofdata.HProfRootMonitorUsed:
+		return n.root(o.ObjectId, "monitor used")
+	}
+	return nil
+}
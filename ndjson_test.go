@@ -0,0 +1,55 @@
+package heapdump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteNDJSON(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+	object1 := tester.FindInstance("Object1")
+	object2 := tester.FindInstance("Object2")
+
+	var buf bytes.Buffer
+	if err := WriteNDJSON(tester.analyzer.logger, "testdata/object/heapdump.hprof", &buf); err != nil {
+		t.Fatal(err)
+	}
+	objects := make(map[uint64]*NDJSONObject)
+	roots := make(map[uint64]bool)
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var object NDJSONObject
+		if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+			t.Fatalf("%v: %q", err, scanner.Text())
+		}
+		if object.Kind == "root" {
+			roots[object.ObjectId] = true
+			continue
+		}
+		objects[object.ObjectId] = &object
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != len(tester.analyzer.hprof.RootObjectIds()) {
+		t.Errorf("%v roots should be written. But %v", len(tester.analyzer.hprof.RootObjectIds()), len(roots))
+	}
+
+	object := objects[object1]
+	if object == nil || object.ClassName != "Object1" || object.Kind != "instance" {
+		t.Fatalf("Object1 should be written. But %+v", object)
+	}
+	if shallowSize, err := tester.analyzer.GetShallowSize(object1); err != nil || object.ShallowSize != shallowSize {
+		t.Errorf("The shallow size of Object1 should be %v. But %v", shallowSize, object.ShallowSize)
+	}
+	if len(object.References) != 1 || object.References[0].Name != "o2" || object.References[0].ObjectId != object2 {
+		t.Errorf("Object1 should refer Object2 by o2. But %+v", object.References)
+	}
+	if len(object.Fields) != 1 || object.Fields[0].Type != "OBJECT" || object.Fields[0].Value != float64(object2) {
+		t.Errorf("The field o2 should be decoded. But %+v", object.Fields)
+	}
+}
@@ -0,0 +1,260 @@
+package heapdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type ObjectKind int
+
+const (
+	ObjectKind_INSTANCE ObjectKind = iota
+	ObjectKind_OBJECT_ARRAY
+	ObjectKind_PRIMITIVE_ARRAY
+	ObjectKind_CLASS
+)
+
+func (k ObjectKind) String() string {
+	switch k {
+	case ObjectKind_INSTANCE:
+		return "instance"
+	case ObjectKind_OBJECT_ARRAY:
+		return "object array"
+	case ObjectKind_PRIMITIVE_ARRAY:
+		return "primitive array"
+	case ObjectKind_CLASS:
+		return "class"
+	}
+	return "unknown"
+}
+
+// Field is a decoded field value of an instance or a class.
+type Field struct {
+	Name string
+	Type hprofdata.HProfValueType
+	// Value is the raw bits of the value, right aligned. e.g. the object ID for the OBJECT type.
+	Value uint64
+}
+
+// String returns the Java representation of the value. Object references are shown as the object ID.
+func (f *Field) String() string {
+	return formatValue(f.Type, f.Value)
+}
+
+// Object is a decoded object in the heap dump.
+type Object struct {
+	ObjectId uint64
+	Kind     ObjectKind
+	// ClassObjectId is the class of the instance or the object array. It's 0 for the primitive arrays.
+	ClassObjectId uint64
+	ClassName     string
+	// Fields are the instance fields for the instances, and the static fields for the classes.
+	Fields []*Field
+	// Length is the number of the elements for the arrays.
+	Length int
+	// ElementType is the type of the elements for the arrays.
+	ElementType hprofdata.HProfValueType
+	// ElementObjectIds are the elements of the object arrays.
+	ElementObjectIds []uint64
+	// Values are the raw bytes of the primitive arrays.
+	Values []byte
+}
+
+// Element returns the i-th element of the primitive array.
+func (o *Object) Element(i int) *Field {
+	size := parser.ValueSize[o.ElementType]
+	return &Field{
+		Name:  "[" + strconv.Itoa(i) + "]",
+		Type:  o.ElementType,
+		Value: readValue(o.Values[i*size:], size),
+	}
+}
+
+// GetField returns the field by name. It returns nil if the object doesn't have the field.
+func (o *Object) GetField(name string) *Field {
+	for _, field := range o.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+func readValue(values []byte, size int) uint64 {
+	var buf [8]byte
+	copy(buf[8-size:], values[:size])
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+func formatValue(valueType hprofdata.HProfValueType, value uint64) string {
+	switch valueType {
+	case hprofdata.HProfValueType_OBJECT:
+		if value == 0 {
+			return "null"
+		}
+		return strconv.FormatUint(value, 10)
+	case hprofdata.HProfValueType_BOOLEAN:
+		return strconv.FormatBool(value != 0)
+	case hprofdata.HProfValueType_CHAR:
+		return strconv.QuoteRune(rune(uint16(value)))
+	case hprofdata.HProfValueType_FLOAT:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(value))), 'g', -1, 32)
+	case hprofdata.HProfValueType_DOUBLE:
+		return strconv.FormatFloat(math.Float64frombits(value), 'g', -1, 64)
+	case hprofdata.HProfValueType_BYTE:
+		return strconv.Itoa(int(int8(value)))
+	case hprofdata.HProfValueType_SHORT:
+		return strconv.Itoa(int(int16(value)))
+	case hprofdata.HProfValueType_INT:
+		return strconv.Itoa(int(int32(value)))
+	case hprofdata.HProfValueType_LONG:
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return fmt.Sprintf("<unknown type %v>", valueType)
+}
+
+// GetPrimitiveArrayTypeName returns the Java name of the primitive array type. e.g. "char[]".
+func GetPrimitiveArrayTypeName(elementType hprofdata.HProfValueType) string {
+	return strings.ToLower(elementType.String()) + "[]"
+}
+
+// GetInstanceFields returns the instance fields of the instance, including the fields of the super classes.
+func (h HProf) GetInstanceFields(instanceDump *hprofdata.HProfInstanceDump) ([]*Field, error) {
+	classDump, err := h.GetClassDumpByClassObjectId(instanceDump.ClassObjectId)
+	if err != nil {
+		return nil, err
+	}
+	values := instanceDump.GetValues()
+	idx := 0
+	var fields []*Field
+	for classDump != nil {
+		for _, instanceField := range classDump.InstanceFields {
+			size := parser.ValueSize[instanceField.Type]
+			if instanceField.Type == hprofdata.HProfValueType_OBJECT {
+				size = h.identifierSize
+			}
+			if idx+size > len(values) {
+				return nil, fmt.Errorf("instance %v is shorter than its fields", instanceDump.ObjectId)
+			}
+			fields = append(fields, &Field{
+				Name:  h.GetFieldName(instanceField.NameId),
+				Type:  instanceField.Type,
+				Value: readValue(values[idx:], size),
+			})
+			idx += size
+		}
+		classDump, err = h.GetClassDumpByClassObjectId(classDump.SuperClassObjectId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// GetStaticFields returns the static fields of the class.
+func (h HProf) GetStaticFields(classDump *hprofdata.HProfClassDump) []*Field {
+	var fields []*Field
+	for _, staticField := range classDump.StaticFields {
+		value := staticField.Value
+		// the parser stores the value left aligned in 8 bytes.
+		if size := parser.ValueSize[staticField.Type]; staticField.Type != hprofdata.HProfValueType_OBJECT && size > 0 {
+			value >>= uint(8-size) * 8
+		}
+		fields = append(fields, &Field{
+			Name:  h.GetFieldName(staticField.NameId),
+			Type:  staticField.Type,
+			Value: value,
+		})
+	}
+	return fields
+}
+
+// GetObject returns the decoded object. It returns nil if the object isn't in the heap dump.
+func (h HProf) GetObject(objectId uint64) (*Object, error) {
+	if instanceDump := h.objectId2instanceDump[objectId]; instanceDump != nil {
+		name, err := h.GetClassNameByClassObjectId(instanceDump.ClassObjectId)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := h.GetInstanceFields(instanceDump)
+		if err != nil {
+			return nil, err
+		}
+		return &Object{
+			ObjectId:      objectId,
+			Kind:          ObjectKind_INSTANCE,
+			ClassObjectId: instanceDump.ClassObjectId,
+			ClassName:     name,
+			Fields:        fields,
+		}, nil
+	}
+
+	if objectArrayDump := h.arrayObjectId2objectArrayDump[objectId]; objectArrayDump != nil {
+		name, err := h.GetClassNameByClassObjectId(objectArrayDump.ArrayClassObjectId)
+		if err != nil {
+			return nil, err
+		}
+		return &Object{
+			ObjectId:         objectId,
+			Kind:             ObjectKind_OBJECT_ARRAY,
+			ClassObjectId:    objectArrayDump.ArrayClassObjectId,
+			ClassName:        name,
+			Length:           len(objectArrayDump.ElementObjectIds),
+			ElementType:      hprofdata.HProfValueType_OBJECT,
+			ElementObjectIds: objectArrayDump.ElementObjectIds,
+		}, nil
+	}
+
+	if primitiveArrayDump := h.arrayObjectId2primitiveArrayDump[objectId]; primitiveArrayDump != nil {
+		return &Object{
+			ObjectId:    objectId,
+			Kind:        ObjectKind_PRIMITIVE_ARRAY,
+			ClassName:   GetPrimitiveArrayTypeName(primitiveArrayDump.ElementType),
+			Length:      len(primitiveArrayDump.Values) / parser.ValueSize[primitiveArrayDump.ElementType],
+			ElementType: primitiveArrayDump.ElementType,
+			Values:      primitiveArrayDump.Values,
+		}, nil
+	}
+
+	classDump, err := h.GetClassDumpByClassObjectId(objectId)
+	if err != nil {
+		return nil, err
+	}
+	if classDump != nil {
+		name, err := h.GetClassNameByClassObjectId(objectId)
+		if err != nil {
+			return nil, err
+		}
+		return &Object{
+			ObjectId:      objectId,
+			Kind:          ObjectKind_CLASS,
+			ClassObjectId: objectId,
+			ClassName:     name,
+			Fields:        h.GetStaticFields(classDump),
+		}, nil
+	}
+	return nil, nil
+}
+
+// GetObjectClassName returns the class name of the object. For the classes, it returns "class <name>".
+func (h HProf) GetObjectClassName(objectId uint64) (string, error) {
+	if instanceDump := h.objectId2instanceDump[objectId]; instanceDump != nil {
+		return h.GetClassNameByClassObjectId(instanceDump.ClassObjectId)
+	}
+	if objectArrayDump := h.arrayObjectId2objectArrayDump[objectId]; objectArrayDump != nil {
+		return h.GetClassNameByClassObjectId(objectArrayDump.ArrayClassObjectId)
+	}
+	if primitiveArrayDump := h.arrayObjectId2primitiveArrayDump[objectId]; primitiveArrayDump != nil {
+		return GetPrimitiveArrayTypeName(primitiveArrayDump.ElementType), nil
+	}
+	name, err := h.GetClassNameByClassObjectId(objectId)
+	if err != nil {
+		return "", errObjectNotFound
+	}
+	return "class " + name, nil
+}
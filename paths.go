@@ -0,0 +1,90 @@
+package heapdump
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PathElement is an object on the path from a GC root.
+type PathElement struct {
+	ObjectId  uint64 `json:"id"`
+	ClassName string `json:"className"`
+	// Reference is the name of the reference from the previous element. It's empty for the GC root.
+	Reference string `json:"reference,omitempty"`
+	// RootKinds are the kinds of the GC root. Only for the first element.
+	RootKinds []string `json:"rootKinds,omitempty"`
+}
+
+// WritePath writes the path from the GC root as the plain text, indenting each reference.
+func WritePath(w io.Writer, path []*PathElement, indent string) {
+	for i, element := range path {
+		if i == 0 {
+			fmt.Fprintf(w, "%s%s (%d) [GC root: %s]\n",
+				indent, element.ClassName, element.ObjectId, strings.Join(element.RootKinds, ", "))
+		} else {
+			fmt.Fprintf(w, "%s%s%s -> %s (%d)\n",
+				indent, strings.Repeat("  ", i), element.Reference, element.ClassName, element.ObjectId)
+		}
+	}
+}
+
+// FindPathFromRoot returns the shortest path from the GC roots to the object.
+// It returns nil if the object isn't reachable from the GC roots.
+func (a HeapDumpAnalyzer) FindPathFromRoot(objectId uint64) ([]*PathElement, error) {
+	type parent struct {
+		objectId  uint64
+		reference *Reference
+	}
+	parents := make(map[uint64]*parent)
+	var queue []uint64
+	for _, rootObjectId := range a.hprof.RootObjectIds() {
+		parents[rootObjectId] = nil
+		queue = append(queue, rootObjectId)
+	}
+
+	found := false
+	if _, ok := parents[objectId]; ok {
+		found = true
+	}
+	for len(queue) > 0 && !found {
+		current := queue[0]
+		queue = queue[1:]
+		err := a.hprof.ForEachReference(current, func(ref *Reference) error {
+			if _, ok := parents[ref.ObjectId]; ok {
+				return nil
+			}
+			parents[ref.ObjectId] = &parent{objectId: current, reference: ref}
+			if ref.ObjectId == objectId {
+				found = true
+				return errStopIteration
+			}
+			queue = append(queue, ref.ObjectId)
+			return nil
+		})
+		if err != nil && err != errStopIteration {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	var path []*PathElement
+	for current := objectId; ; {
+		name, err := a.hprof.GetObjectClassName(current)
+		if err != nil {
+			return nil, err
+		}
+		element := &PathElement{ObjectId: current, ClassName: name}
+		path = append([]*PathElement{element}, path...)
+		p := parents[current]
+		if p == nil {
+			element.RootKinds = a.hprof.GetRootKinds(current)
+			break
+		}
+		element.Reference = a.hprof.GetReferenceName(p.reference)
+		current = p.objectId
+	}
+	return path, nil
+}
@@ -0,0 +1,193 @@
+package heapdump
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+	"time"
+)
+
+// pprofSample is a sample of the profile, which aggregates the objects with the same stack.
+type pprofSample struct {
+	locationIds []uint64
+	// objects, shallow size, retained size
+	values [3]int64
+}
+
+// pprofBuilder builds the profile.proto of pprof.
+// See https://github.com/google/pprof/blob/master/proto/profile.proto
+type pprofBuilder struct {
+	strings     []string
+	stringIndex map[string]int64
+	// function name -> location ID. Each function has a location with the same ID.
+	locations map[string]uint64
+	samples   map[string]*pprofSample
+	keys      []string
+}
+
+func newPprofBuilder() *pprofBuilder {
+	m := new(pprofBuilder)
+	m.stringIndex = make(map[string]int64)
+	m.locations = make(map[string]uint64)
+	m.samples = make(map[string]*pprofSample)
+	m.string("")
+	return m
+}
+
+func (b *pprofBuilder) string(s string) int64 {
+	if index, ok := b.stringIndex[s]; ok {
+		return index
+	}
+	index := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.stringIndex[s] = index
+	return index
+}
+
+func (b *pprofBuilder) location(function string) uint64 {
+	if id, ok := b.locations[function]; ok {
+		return id
+	}
+	id := uint64(len(b.locations) + 1)
+	b.locations[function] = id
+	b.string(function)
+	return id
+}
+
+// add adds the object with the frames from the root.
+func (b *pprofBuilder) add(frames []string, shallowSize uint64, retainedSize uint64) {
+	key := strings.Join(frames, "\x00")
+	sample, ok := b.samples[key]
+	if !ok {
+		sample = new(pprofSample)
+		// the leaf comes first in pprof.
+		for i := len(frames) - 1; i >= 0; i-- {
+			sample.locationIds = append(sample.locationIds, b.location(frames[i]))
+		}
+		b.samples[key] = sample
+		b.keys = append(b.keys, key)
+	}
+	sample.values[0]++
+	sample.values[1] += int64(shallowSize)
+	sample.values[2] += int64(retainedSize)
+}
+
+func (b *pprofBuilder) write(w io.Writer) error {
+	var p protoBuffer
+	for _, sampleType := range [][2]string{{"objects", "count"}, {"shallow", "bytes"}, {"retained", "bytes"}} {
+		var valueType protoBuffer
+		valueType.int64(1, b.string(sampleType[0]))
+		valueType.int64(2, b.string(sampleType[1]))
+		p.message(1, &valueType)
+	}
+	for _, key := range b.keys {
+		sample := b.samples[key]
+		var s protoBuffer
+		s.packedUint64(1, sample.locationIds)
+		s.packedInt64(2, sample.values[:])
+		p.message(2, &s)
+	}
+	functions := make([]string, len(b.locations))
+	for function, id := range b.locations {
+		functions[id-1] = function
+	}
+	for i := range functions {
+		id := uint64(i + 1)
+		var line protoBuffer
+		line.uint64(1, id)
+		var location protoBuffer
+		location.uint64(1, id)
+		location.message(4, &line)
+		p.message(4, &location)
+	}
+	for i, function := range functions {
+		var f protoBuffer
+		f.uint64(1, uint64(i+1))
+		f.int64(2, b.string(function))
+		f.int64(3, b.string(function))
+		p.message(5, &f)
+	}
+	defaultSampleType := b.string("shallow")
+	for _, s := range b.strings {
+		p.bytes(6, []byte(s))
+	}
+	p.int64(9, time.Now().UnixNano())
+	p.int64(14, defaultSampleType)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(p.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// WriteProfile writes the reachable objects as the gzipped profile.proto of pprof. The frames are the classes and the
+// references on the tree from ForEachHeapStack, and the sample values are the number of the objects, the shallow size
+// and the retained size. The default sample value is the shallow size, whose cumulative value is the retained size
+// on the dominator tree. The cumulative retained size counts the nested objects twice, so use it in the flat view.
+func (a HeapDumpAnalyzer) WriteProfile(w io.Writer, tree *DominatorTree, kind HeapStackTree, maxDepth int) error {
+	b := newPprofBuilder()
+	err := a.ForEachHeapStack(tree, kind, maxDepth, func(frames []string, objectId uint64) error {
+		b.add(frames, tree.ShallowSize(objectId), tree.RetainedSize(objectId))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return b.write(w)
+}
+
+// protoBuffer is the minimal protocol buffers encoder.
+type protoBuffer struct {
+	data []byte
+}
+
+func (p *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		p.data = append(p.data, byte(v)|0x80)
+		v >>= 7
+	}
+	p.data = append(p.data, byte(v))
+}
+
+func (p *protoBuffer) tag(field int, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.tag(field, 0)
+	p.varint(v)
+}
+
+func (p *protoBuffer) int64(field int, v int64) {
+	p.uint64(field, uint64(v))
+}
+
+func (p *protoBuffer) bytes(field int, data []byte) {
+	p.tag(field, 2)
+	p.varint(uint64(len(data)))
+	p.data = append(p.data, data...)
+}
+
+func (p *protoBuffer) message(field int, m *protoBuffer) {
+	p.bytes(field, m.data)
+}
+
+func (p *protoBuffer) packedUint64(field int, values []uint64) {
+	var packed protoBuffer
+	for _, v := range values {
+		packed.varint(v)
+	}
+	p.bytes(field, packed.data)
+}
+
+func (p *protoBuffer) packedInt64(field int, values []int64) {
+	var packed protoBuffer
+	for _, v := range values {
+		packed.varint(uint64(v))
+	}
+	p.bytes(field, packed.data)
+}
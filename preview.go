@@ -0,0 +1,141 @@
+package heapdump
+
+import (
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const previewStringLength = 20
+
+// InstanceRetainedSize is the retained size of an instance.
+type InstanceRetainedSize struct {
+	ObjectId     uint64
+	ClassName    string
+	ShallowSize  int
+	RetainedSize uint64
+}
+
+// TopInstances returns the biggest `n` instances by the retained size among the classes.
+func (a HeapDumpAnalyzer) TopInstances(classes []*ClassInstancesRetainedSize, n int) ([]*InstanceRetainedSize, error) {
+	var result []*InstanceRetainedSize
+	for _, sizes := range classes {
+		for objectId, size := range sizes.RetainedSizes {
+			result = append(result, &InstanceRetainedSize{
+				ObjectId:     objectId,
+				ClassName:    sizes.Class.Name,
+				RetainedSize: size,
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].RetainedSize != result[j].RetainedSize {
+			return result[i].RetainedSize > result[j].RetainedSize
+		}
+		return result[i].ObjectId < result[j].ObjectId
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	for _, instance := range result {
+		size, err := a.GetShallowSize(instance.ObjectId)
+		if err != nil {
+			return nil, err
+		}
+		instance.ShallowSize = size
+	}
+	return result, nil
+}
+
+// GetObjectPreview returns the short description of the non-null fields, e.g. `size=3, table=HashMap$Node[]@123(length=16)`.
+// The description is truncated around maxLength characters.
+func (a HeapDumpAnalyzer) GetObjectPreview(objectId uint64, maxLength int) (string, error) {
+	object, err := a.hprof.GetObject(objectId)
+	if err != nil {
+		return "", err
+	}
+	if object == nil {
+		return "<missing>", nil
+	}
+
+	switch object.Kind {
+	case ObjectKind_OBJECT_ARRAY, ObjectKind_PRIMITIVE_ARRAY:
+		return "length=" + strconv.Itoa(object.Length), nil
+	case ObjectKind_INSTANCE:
+		if object.ClassName == javaLangString {
+			return a.previewValue(&Field{Type: hprofdata.HProfValueType_OBJECT, Value: objectId})
+		}
+	}
+
+	var b strings.Builder
+	for _, field := range object.Fields {
+		if field.Type == hprofdata.HProfValueType_OBJECT && field.Value == 0 {
+			continue // null fields are not interesting.
+		}
+		if b.Len() >= maxLength {
+			b.WriteString(", ...")
+			break
+		}
+		value, err := a.previewValue(field)
+		if err != nil {
+			return "", err
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(field.Name + "=" + value)
+	}
+	return b.String(), nil
+}
+
+func (a HeapDumpAnalyzer) previewValue(field *Field) (string, error) {
+	if field.Type != hprofdata.HProfValueType_OBJECT || field.Value == 0 {
+		return field.String(), nil
+	}
+
+	objectId := field.Value
+	if instanceDump := a.hprof.objectId2instanceDump[objectId]; instanceDump != nil {
+		name, err := a.hprof.GetClassNameByClassObjectId(instanceDump.ClassObjectId)
+		if err != nil {
+			return "", err
+		}
+		if name == javaLangString {
+			value, err := a.hprof.GetStringValue(objectId)
+			if err != nil {
+				return "", err
+			}
+			if runes := []rune(value); len(runes) > previewStringLength {
+				return strconv.Quote(string(runes[:previewStringLength])) + "...", nil
+			}
+			return strconv.Quote(value), nil
+		}
+		return fmt.Sprintf("%s@%d", shortClassName(name), objectId), nil
+	}
+	if dump := a.hprof.arrayObjectId2primitiveArrayDump[objectId]; dump != nil {
+		length := len(dump.Values) / parser.ValueSize[dump.ElementType]
+		return fmt.Sprintf("%s[%d]", strings.ToLower(dump.ElementType.String()), length), nil
+	}
+	if dump := a.hprof.arrayObjectId2objectArrayDump[objectId]; dump != nil {
+		name, err := a.hprof.GetClassNameByClassObjectId(dump.ArrayClassObjectId)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s@%d(length=%d)", shortClassName(name), objectId, len(dump.ElementObjectIds)), nil
+	}
+	name, err := a.hprof.GetClassNameByClassObjectId(objectId)
+	if err != nil {
+		return fmt.Sprintf("<missing>@%d", objectId), nil
+	}
+	return fmt.Sprintf("class %s@%d", shortClassName(name), objectId), nil
+}
+
+// shortClassName strips the package name. e.g. "java/util/HashMap$Node" to "HashMap$Node".
+func shortClassName(name string) string {
+	if i := strings.LastIndexAny(name, "/."); i >= 0 && !strings.HasPrefix(name, "[") {
+		return name[i+1:]
+	}
+	return name
+}
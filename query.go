@@ -0,0 +1,1087 @@
+package heapdump
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Query is the parsed query. See ParseQuery for the syntax.
+type Query struct {
+	selects    []*selectItem // nil for SELECT *
+	from       *ClassPattern
+	instanceOf bool
+	alias      string
+	where      queryExpr
+	groupBy    []queryExpr
+	orderBy    []*orderItem
+	limit      int
+	aggregates []*aggregateExpr
+}
+
+type selectItem struct {
+	expr queryExpr
+	name string
+}
+
+type orderItem struct {
+	// column is the index of the SELECT clause, or -1 to evaluate the expr.
+	column     int
+	expr       queryExpr
+	descending bool
+}
+
+// ObjectReference is an object in the query result.
+type ObjectReference struct {
+	ObjectId  uint64 `json:"id"`
+	ClassName string `json:"className"`
+}
+
+func (r *ObjectReference) String() string {
+	return fmt.Sprintf("%s@%d", r.ClassName, r.ObjectId)
+}
+
+// QueryResult is the result of the query. Values are nil, bool, int64, float64, string or *ObjectReference.
+type QueryResult struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// queryObject is the object reference in the evaluation.
+type queryObject uint64
+
+type queryContext struct {
+	analyzer  HeapDumpAnalyzer
+	query     *Query
+	tree      *DominatorTree // built on demand.
+	referrers *Referrers     // built on demand.
+	patterns  map[string]*ClassPattern
+	// objectId is the object being evaluated. 0 if there's no object.
+	objectId uint64
+	// aggregateValues are the results of the aggregate functions of the current group.
+	aggregateValues []interface{}
+	// the last decoded object, since the expressions often refer the same object many times.
+	lastObject *Object
+}
+
+type queryExpr interface {
+	eval(c *queryContext) (interface{}, error)
+}
+
+var queryAttributes = []string{"@objectId", "@className", "@shallowSize", "@retainedSize", "@length"}
+
+type queryFunction struct {
+	arity int
+	fn    func(c *queryContext, args []interface{}) (interface{}, error)
+}
+
+var queryFunctions = map[string]*queryFunction{
+	"tostring": {1, func(c *queryContext, args []interface{}) (interface{}, error) {
+		return c.toString(args[0])
+	}},
+	"length": {1, func(c *queryContext, args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case string:
+			return int64(len([]rune(v))), nil
+		case queryObject:
+			object, err := c.getObject(v)
+			if err != nil || object == nil {
+				return nil, err
+			}
+			if object.ClassName == javaLangString {
+				s, err := c.toString(v)
+				if err != nil {
+					return nil, err
+				}
+				return int64(len([]rune(s.(string)))), nil
+			}
+			return c.attribute(v, "@length")
+		}
+		return nil, nil
+	}},
+	"classof": {1, func(c *queryContext, args []interface{}) (interface{}, error) {
+		return c.attribute(args[0], "@className")
+	}},
+	"referencedby": {2, func(c *queryContext, args []interface{}) (interface{}, error) {
+		return c.referencedBy(args[0], args[1])
+	}},
+}
+
+// aggregator accumulates the values of an aggregate function. Null values are ignored.
+type aggregator interface {
+	add(v interface{}) error
+	result() interface{}
+}
+
+var queryAggregateFunctions = map[string]func() aggregator{
+	"count": func() aggregator { return new(countAggregator) },
+	"sum":   func() aggregator { return &numberAggregator{function: "sum"} },
+	"avg":   func() aggregator { return &numberAggregator{function: "avg"} },
+	"min":   func() aggregator { return &extremeAggregator{max: false} },
+	"max":   func() aggregator { return &extremeAggregator{max: true} },
+}
+
+type countAggregator struct {
+	count int64
+}
+
+func (a *countAggregator) add(v interface{}) error {
+	if v != nil {
+		a.count++
+	}
+	return nil
+}
+
+func (a *countAggregator) result() interface{} {
+	return a.count
+}
+
+type numberAggregator struct {
+	function string
+	count    int64
+	sum      interface{}
+}
+
+func (a *numberAggregator) add(v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	if a.sum == nil {
+		a.sum = int64(0)
+	}
+	sum, err := arithmetic("+", a.sum, v)
+	if err != nil {
+		return fmt.Errorf("%v: %v", a.function, err)
+	}
+	a.sum = sum
+	a.count++
+	return nil
+}
+
+func (a *numberAggregator) result() interface{} {
+	if a.function == "avg" {
+		if a.count == 0 {
+			return nil
+		}
+		f, _ := toFloat(a.sum)
+		return f / float64(a.count)
+	}
+	return a.sum
+}
+
+type extremeAggregator struct {
+	max   bool
+	value interface{}
+}
+
+func (a *extremeAggregator) add(v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	if a.value == nil {
+		a.value = v
+		return nil
+	}
+	n, err := compareValues(v, a.value)
+	if err != nil {
+		return err
+	}
+	if a.max && n > 0 || !a.max && n < 0 {
+		a.value = v
+	}
+	return nil
+}
+
+func (a *extremeAggregator) result() interface{} {
+	return a.value
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+func (e *literalExpr) eval(c *queryContext) (interface{}, error) {
+	return e.value, nil
+}
+
+// thisExpr is the object being evaluated.
+type thisExpr struct{}
+
+func (e *thisExpr) eval(c *queryContext) (interface{}, error) {
+	if c.objectId == 0 {
+		return nil, nil
+	}
+	return queryObject(c.objectId), nil
+}
+
+// identExpr is the alias of the FROM clause, or the field of the object being evaluated.
+type identExpr struct {
+	name string
+}
+
+func (e *identExpr) eval(c *queryContext) (interface{}, error) {
+	this, _ := (&thisExpr{}).eval(c)
+	if e.name == c.query.alias {
+		return this, nil
+	}
+	return c.field(this, e.name)
+}
+
+type fieldExpr struct {
+	target queryExpr
+	name   string
+}
+
+func (e *fieldExpr) eval(c *queryContext) (interface{}, error) {
+	target, err := e.target.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	return c.field(target, e.name)
+}
+
+type attributeExpr struct {
+	target queryExpr
+	name   string
+}
+
+func (e *attributeExpr) eval(c *queryContext) (interface{}, error) {
+	target, err := e.target.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	return c.attribute(target, e.name)
+}
+
+type indexExpr struct {
+	target queryExpr
+	index  queryExpr
+}
+
+func (e *indexExpr) eval(c *queryContext) (interface{}, error) {
+	target, err := e.target.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	index, err := e.index.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	object, err := c.getObject(target)
+	if err != nil || object == nil {
+		return nil, err
+	}
+	i, ok := index.(int64)
+	if !ok {
+		return nil, fmt.Errorf("array index should be an integer: %v", index)
+	}
+	if i < 0 || int(i) >= object.Length {
+		return nil, nil
+	}
+	switch object.Kind {
+	case ObjectKind_OBJECT_ARRAY:
+		if object.ElementObjectIds[i] == 0 {
+			return nil, nil
+		}
+		return queryObject(object.ElementObjectIds[i]), nil
+	case ObjectKind_PRIMITIVE_ARRAY:
+		return fieldValue(object.Element(int(i))), nil
+	}
+	return nil, fmt.Errorf("%s@%d is not an array", object.ClassName, object.ObjectId)
+}
+
+type binaryExpr struct {
+	op string
+	x  queryExpr
+	y  queryExpr
+}
+
+func (e *binaryExpr) eval(c *queryContext) (interface{}, error) {
+	x, err := e.x.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	y, err := e.y.eval(c)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "+", "-", "*", "/", "%":
+		if e.op == "+" {
+			// concatenate the strings.
+			_, xs := x.(string)
+			_, ys := y.(string)
+			if xs || ys {
+				if x, err = c.toString(x); err != nil {
+					return nil, err
+				}
+				if y, err = c.toString(y); err != nil {
+					return nil, err
+				}
+				if x == nil || y == nil {
+					return nil, nil
+				}
+				return x.(string) + y.(string), nil
+			}
+		}
+		return arithmetic(e.op, x, y)
+	}
+
+	if x == nil || y == nil {
+		switch e.op {
+		case "=":
+			return x == nil && y == nil, nil
+		case "!=":
+			return x != nil || y != nil, nil
+		}
+		return false, nil
+	}
+	// compare the java.lang.String instance with the string literal.
+	if _, ok := x.(string); ok {
+		if y, err = c.toString(y); err != nil {
+			return nil, err
+		}
+	} else if _, ok := y.(string); ok {
+		if x, err = c.toString(x); err != nil {
+			return nil, err
+		}
+	}
+	n, err := compareValues(x, y)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "=":
+		return n == 0, nil
+	case "!=":
+		return n != 0, nil
+	case "<":
+		return n < 0, nil
+	case "<=":
+		return n <= 0, nil
+	case ">":
+		return n > 0, nil
+	case ">=":
+		return n >= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator: %v", e.op)
+}
+
+type logicalExpr struct {
+	and bool
+	x   queryExpr
+	y   queryExpr
+}
+
+func (e *logicalExpr) eval(c *queryContext) (interface{}, error) {
+	x, err := evalBool(c, e.x)
+	if err != nil {
+		return nil, err
+	}
+	// short circuit
+	if e.and && !x || !e.and && x {
+		return x, nil
+	}
+	return evalBool(c, e.y)
+}
+
+type notExpr struct {
+	x queryExpr
+}
+
+func (e *notExpr) eval(c *queryContext) (interface{}, error) {
+	x, err := evalBool(c, e.x)
+	if err != nil {
+		return nil, err
+	}
+	return !x, nil
+}
+
+type isNullExpr struct {
+	x queryExpr
+}
+
+func (e *isNullExpr) eval(c *queryContext) (interface{}, error) {
+	x, err := e.x.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	return x == nil, nil
+}
+
+type likeExpr struct {
+	x  queryExpr
+	re *regexp.Regexp
+}
+
+func (e *likeExpr) eval(c *queryContext) (interface{}, error) {
+	x, err := e.x.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.toString(x)
+	if err != nil || s == nil {
+		return false, err
+	}
+	return e.re.MatchString(s.(string)), nil
+}
+
+type callExpr struct {
+	function *queryFunction
+	args     []queryExpr
+}
+
+func (e *callExpr) eval(c *queryContext) (interface{}, error) {
+	var args []interface{}
+	for _, arg := range e.args {
+		v, err := arg.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return e.function.fn(c, args)
+}
+
+// aggregateExpr is the aggregate function. The arg is nil for count(*).
+type aggregateExpr struct {
+	function string
+	arg      queryExpr
+	index    int
+}
+
+func (e *aggregateExpr) eval(c *queryContext) (interface{}, error) {
+	if c.aggregateValues == nil {
+		return nil, fmt.Errorf("aggregate function %v is not allowed here", e.function)
+	}
+	return c.aggregateValues[e.index], nil
+}
+
+func evalBool(c *queryContext, e queryExpr) (bool, error) {
+	v, err := e.eval(c)
+	if err != nil {
+		return false, err
+	}
+	switch v := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	}
+	return false, fmt.Errorf("%v is not a boolean", v)
+}
+
+// fieldValue converts the field value to the value of the query.
+func fieldValue(field *Field) interface{} {
+	switch field.Type {
+	case hprofdata.HProfValueType_OBJECT:
+		if field.Value == 0 {
+			return nil
+		}
+		return queryObject(field.Value)
+	case hprofdata.HProfValueType_BOOLEAN:
+		return field.Value != 0
+	case hprofdata.HProfValueType_CHAR:
+		return string(rune(uint16(field.Value)))
+	case hprofdata.HProfValueType_FLOAT:
+		return float64(math.Float32frombits(uint32(field.Value)))
+	case hprofdata.HProfValueType_DOUBLE:
+		return math.Float64frombits(field.Value)
+	case hprofdata.HProfValueType_BYTE:
+		return int64(int8(field.Value))
+	case hprofdata.HProfValueType_SHORT:
+		return int64(int16(field.Value))
+	case hprofdata.HProfValueType_INT:
+		return int64(int32(field.Value))
+	}
+	return int64(field.Value)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func arithmetic(op string, x interface{}, y interface{}) (interface{}, error) {
+	if x == nil || y == nil {
+		return nil, nil
+	}
+	xi, xok := x.(int64)
+	yi, yok := y.(int64)
+	if xok && yok {
+		switch op {
+		case "+":
+			return xi + yi, nil
+		case "-":
+			return xi - yi, nil
+		case "*":
+			return xi * yi, nil
+		case "/":
+			if yi == 0 {
+				return nil, nil
+			}
+			return xi / yi, nil
+		case "%":
+			if yi == 0 {
+				return nil, nil
+			}
+			return xi % yi, nil
+		}
+	}
+	xf, xok := toFloat(x)
+	yf, yok := toFloat(y)
+	if !xok || !yok {
+		return nil, fmt.Errorf("%v %v %v: not a number", x, op, y)
+	}
+	switch op {
+	case "+":
+		return xf + yf, nil
+	case "-":
+		return xf - yf, nil
+	case "*":
+		return xf * yf, nil
+	case "/":
+		if yf == 0 {
+			return nil, nil
+		}
+		return xf / yf, nil
+	case "%":
+		if yf == 0 {
+			return nil, nil
+		}
+		return math.Mod(xf, yf), nil
+	}
+	return nil, fmt.Errorf("unknown operator: %v", op)
+}
+
+// compareValues compares the non-null values of the same type.
+func compareValues(x interface{}, y interface{}) (int, error) {
+	if xi, ok := x.(int64); ok {
+		if yi, ok := y.(int64); ok {
+			if xi < yi {
+				return -1, nil
+			} else if xi > yi {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if xf, ok := toFloat(x); ok {
+		if yf, ok := toFloat(y); ok {
+			if xf < yf {
+				return -1, nil
+			} else if xf > yf {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	switch x := x.(type) {
+	case string:
+		if y, ok := y.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := y.(bool); ok {
+			if x == y {
+				return 0, nil
+			} else if !x {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	case queryObject:
+		if y, ok := y.(queryObject); ok {
+			if x < y {
+				return -1, nil
+			} else if x > y {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %v and %v", x, y)
+}
+
+// orderValues is the order of the rows. Nulls come first, and the values of the different types are ordered by the type.
+func orderValues(x interface{}, y interface{}) int {
+	if x == nil || y == nil {
+		if x == nil && y == nil {
+			return 0
+		} else if x == nil {
+			return -1
+		}
+		return 1
+	}
+	if n, err := compareValues(x, y); err == nil {
+		return n
+	}
+	return strings.Compare(fmt.Sprintf("%T", x), fmt.Sprintf("%T", y))
+}
+
+func (c *queryContext) getObject(v interface{}) (*Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case queryObject:
+		if c.lastObject != nil && c.lastObject.ObjectId == uint64(v) {
+			return c.lastObject, nil
+		}
+		object, err := c.analyzer.GetObject(uint64(v))
+		if err != nil {
+			return nil, err
+		}
+		c.lastObject = object
+		return object, nil
+	}
+	return nil, fmt.Errorf("%v is not an object", v)
+}
+
+// field returns the field of the object. It returns nil if the object doesn't have the field,
+// since FROM INSTANCEOF mixes the classes which have the different fields.
+func (c *queryContext) field(v interface{}, name string) (interface{}, error) {
+	object, err := c.getObject(v)
+	if err != nil || object == nil {
+		return nil, err
+	}
+	field := object.GetField(name)
+	if field == nil {
+		return nil, nil
+	}
+	return fieldValue(field), nil
+}
+
+func (c *queryContext) attribute(v interface{}, name string) (interface{}, error) {
+	object, err := c.getObject(v)
+	if err != nil || object == nil {
+		return nil, err
+	}
+	switch name {
+	case "@objectId":
+		return int64(object.ObjectId), nil
+	case "@className":
+		return c.className(object.ObjectId)
+	case "@shallowSize":
+		size, err := c.analyzer.GetShallowSize(object.ObjectId)
+		return int64(size), err
+	case "@retainedSize":
+		if c.tree == nil {
+			if c.tree, err = c.analyzer.BuildDominatorTree(); err != nil {
+				return nil, err
+			}
+		}
+		return int64(c.tree.RetainedSize(object.ObjectId)), nil
+	case "@length":
+		if object.Kind == ObjectKind_OBJECT_ARRAY || object.Kind == ObjectKind_PRIMITIVE_ARRAY {
+			return int64(object.Length), nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown attribute: %v", name)
+}
+
+// className returns the class name of the object in the Java style.
+func (c *queryContext) className(objectId uint64) (string, error) {
+	name, err := c.analyzer.GetObjectClassName(objectId)
+	if err != nil {
+		return "", err
+	}
+	if arrayName := javaArrayTypeName(name); arrayName != "" {
+		return arrayName, nil
+	}
+	return ToJavaClassName(name), nil
+}
+
+func (c *queryContext) toString(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return v, nil
+	case queryObject:
+		object, err := c.getObject(v)
+		if err != nil {
+			return nil, err
+		}
+		if object != nil && object.Kind == ObjectKind_INSTANCE && object.ClassName == javaLangString {
+			return c.analyzer.GetStringValue(uint64(v))
+		}
+		name, err := c.className(uint64(v))
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("%s@%d", name, v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+func (c *queryContext) referencedBy(v interface{}, p interface{}) (interface{}, error) {
+	s, ok := p.(string)
+	if !ok {
+		return nil, fmt.Errorf("referencedBy: class pattern should be a string: %v", p)
+	}
+	pattern, ok := c.patterns[s]
+	if !ok {
+		var err error
+		if pattern, err = NewClassPattern(s); err != nil {
+			return nil, err
+		}
+		c.patterns[s] = pattern
+	}
+	if c.referrers == nil {
+		var err error
+		if c.referrers, err = c.analyzer.BuildReferrers(); err != nil {
+			return nil, err
+		}
+	}
+
+	objectId, ok := v.(queryObject)
+	if !ok {
+		return false, nil
+	}
+	for _, referrer := range c.referrers.Get(uint64(objectId)) {
+		name, err := c.className(referrer)
+		if err != nil {
+			return nil, err
+		}
+		if pattern.Match(name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// javaArrayTypeName converts the JVM internal name of the array class to the Java style, e.g. "[Ljava/lang/Object;"
+// to "java.lang.Object[]" and "[[I" to "int[][]". It returns "" for the other classes.
+func javaArrayTypeName(name string) string {
+	dimensions := 0
+	for dimensions < len(name) && name[dimensions] == '[' {
+		dimensions++
+	}
+	if dimensions == 0 || dimensions == len(name) {
+		return ""
+	}
+	elementName := name[dimensions:]
+	switch elementName {
+	case "Z":
+		elementName = "boolean"
+	case "C":
+		elementName = "char"
+	case "F":
+		elementName = "float"
+	case "D":
+		elementName = "double"
+	case "B":
+		elementName = "byte"
+	case "S":
+		elementName = "short"
+	case "I":
+		elementName = "int"
+	case "J":
+		elementName = "long"
+	default:
+		if !strings.HasPrefix(elementName, "L") || !strings.HasSuffix(elementName, ";") {
+			return ""
+		}
+		elementName = ToJavaClassName(elementName[1 : len(elementName)-1])
+	}
+	return elementName + strings.Repeat("[]", dimensions)
+}
+
+// candidates returns the object IDs of the instances and the arrays of the FROM clause, in ascending order.
+func (c *queryContext) candidates() ([]uint64, error) {
+	hprof := c.analyzer.hprof
+	superClasses := make(map[uint64]uint64)
+	matched := make(map[uint64]bool)
+	err := hprof.ForEachClassDump(func(classDump *hprofdata.HProfClassDump) error {
+		superClasses[classDump.ClassObjectId] = classDump.SuperClassObjectId
+		name, err := hprof.GetClassNameByClassObjectId(classDump.ClassObjectId)
+		if err != nil {
+			return err
+		}
+		if c.query.from.Match(name) {
+			matched[classDump.ClassObjectId] = true
+		} else if arrayName := javaArrayTypeName(name); arrayName != "" && c.query.from.Match(arrayName) {
+			matched[classDump.ClassObjectId] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if c.query.instanceOf {
+		for classObjectId := range superClasses {
+			for super := superClasses[classObjectId]; super != 0 && !matched[classObjectId]; super = superClasses[super] {
+				if matched[super] {
+					matched[classObjectId] = true
+				}
+			}
+		}
+	}
+
+	var objectIds []uint64
+	for classObjectId := range matched {
+		objectIds = append(objectIds, hprof.classObjectId2objectIds[classObjectId]...)
+	}
+	for objectId, dump := range hprof.arrayObjectId2objectArrayDump {
+		if matched[dump.ArrayClassObjectId] {
+			objectIds = append(objectIds, objectId)
+		}
+	}
+	for objectId, dump := range hprof.arrayObjectId2primitiveArrayDump {
+		if c.query.from.Match(GetPrimitiveArrayTypeName(dump.ElementType)) {
+			objectIds = append(objectIds, objectId)
+		}
+	}
+	sort.Slice(objectIds, func(i, j int) bool {
+		return objectIds[i] < objectIds[j]
+	})
+	return objectIds, nil
+}
+
+type queryGroup struct {
+	objectId    uint64 // the first object of the group.
+	aggregators []aggregator
+}
+
+// RunQuery runs the query over the instances and the arrays of the classes in the FROM clause.
+func (a HeapDumpAnalyzer) RunQuery(q *Query) (*QueryResult, error) {
+	c := &queryContext{analyzer: a, query: q, patterns: make(map[string]*ClassPattern)}
+	objectIds, err := c.candidates()
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(QueryResult)
+	if q.selects == nil {
+		name := q.alias
+		if name == "" {
+			name = "object"
+		}
+		result.Columns = []string{name}
+	}
+	for _, item := range q.selects {
+		result.Columns = append(result.Columns, item.name)
+	}
+
+	// rows have the values of the SELECT clause, followed by the values of the ORDER BY clause.
+	var rows [][]interface{}
+	evalRow := func() error {
+		var row []interface{}
+		if q.selects == nil {
+			this, _ := (&thisExpr{}).eval(c)
+			row = append(row, this)
+		}
+		for _, item := range q.selects {
+			v, err := item.expr.eval(c)
+			if err != nil {
+				return err
+			}
+			row = append(row, v)
+		}
+		for _, item := range q.orderBy {
+			if item.column >= 0 {
+				row = append(row, row[item.column])
+				continue
+			}
+			v, err := item.expr.eval(c)
+			if err != nil {
+				return err
+			}
+			row = append(row, v)
+		}
+		rows = append(rows, row)
+		return nil
+	}
+
+	aggregate := len(q.aggregates) > 0 || len(q.groupBy) > 0
+	groups := make(map[string]*queryGroup)
+	var groupKeys []string
+	for _, objectId := range objectIds {
+		c.objectId = objectId
+		if q.where != nil {
+			ok, err := evalBool(c, q.where)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		if !aggregate {
+			if err := evalRow(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var key strings.Builder
+		for _, e := range q.groupBy {
+			v, err := e.eval(c)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&key, "%T:%v\x00", v, v)
+		}
+		group, ok := groups[key.String()]
+		if !ok {
+			group = &queryGroup{objectId: objectId}
+			for _, e := range q.aggregates {
+				group.aggregators = append(group.aggregators, queryAggregateFunctions[e.function]())
+			}
+			groups[key.String()] = group
+			groupKeys = append(groupKeys, key.String())
+		}
+		for i, e := range q.aggregates {
+			var v interface{} = true // for count(*)
+			if e.arg != nil {
+				if v, err = e.arg.eval(c); err != nil {
+					return nil, err
+				}
+			}
+			if err := group.aggregators[i].add(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if aggregate {
+		// without GROUP BY, the aggregate functions return a row even if no object matches.
+		if len(groupKeys) == 0 && len(q.groupBy) == 0 {
+			group := new(queryGroup)
+			for _, e := range q.aggregates {
+				group.aggregators = append(group.aggregators, queryAggregateFunctions[e.function]())
+			}
+			groups[""] = group
+			groupKeys = append(groupKeys, "")
+		}
+		// the other expressions are evaluated with the first object of the group.
+		for _, key := range groupKeys {
+			group := groups[key]
+			c.objectId = group.objectId
+			c.aggregateValues = nil
+			for _, aggregator := range group.aggregators {
+				c.aggregateValues = append(c.aggregateValues, aggregator.result())
+			}
+			if c.aggregateValues == nil {
+				c.aggregateValues = []interface{}{}
+			}
+			if err := evalRow(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	columns := len(result.Columns)
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, item := range q.orderBy {
+			n := orderValues(rows[i][columns+k], rows[j][columns+k])
+			if n != 0 {
+				return n < 0 != item.descending
+			}
+		}
+		return false
+	})
+	if q.limit > 0 && len(rows) > q.limit {
+		rows = rows[:q.limit]
+	}
+
+	for _, row := range rows {
+		row = row[:columns]
+		for i, v := range row {
+			if objectId, ok := v.(queryObject); ok {
+				name, err := c.className(uint64(objectId))
+				if err != nil {
+					return nil, err
+				}
+				row[i] = &ObjectReference{ObjectId: uint64(objectId), ClassName: name}
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, nil
+}
+
+// Query parses and runs the query.
+func (a HeapDumpAnalyzer) Query(query string) (*QueryResult, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return a.RunQuery(q)
+}
+
+func formatQueryValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// WriteTable writes the result as the text table.
+func (r *QueryResult) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%s\t\n", strings.Join(r.Columns, "\t"))
+	for _, row := range r.Rows {
+		for _, v := range row {
+			// the tabs and the newlines break the table.
+			fmt.Fprintf(tw, "%s\t", strings.NewReplacer("\t", `\t`, "\n", `\n`).Replace(formatQueryValue(v)))
+		}
+		fmt.Fprintf(tw, "\n")
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the result as the JSON array of the rows. Each row is the object keyed by the column names,
+// in the order of the columns.
+func (r *QueryResult) WriteJSON(w io.Writer) error {
+	// the rows are encoded before writing, not to leave the broken JSON on the error.
+	var b strings.Builder
+	b.WriteString("[")
+	for i, row := range r.Rows {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  {")
+		for j, v := range row {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			// JSON doesn't have NaN and Infinity.
+			if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+				v = formatQueryValue(f)
+			}
+			name, _ := json.Marshal(r.Columns[j])
+			value, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			b.Write(name)
+			b.WriteString(": ")
+			b.Write(value)
+		}
+		b.WriteString("}")
+	}
+	b.WriteString("\n]\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,793 @@
+package heapdump
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type queryTokenKind int
+
+const (
+	queryTokenKind_EOF queryTokenKind = iota
+	queryTokenKind_IDENT
+	queryTokenKind_ATTRIBUTE // e.g. @retainedSize
+	queryTokenKind_NUMBER
+	queryTokenKind_STRING
+	queryTokenKind_SYMBOL
+)
+
+type queryToken struct {
+	kind  queryTokenKind
+	text  string
+	value interface{} // for the numbers and the strings.
+	pos   int
+	end   int
+}
+
+var queryKeywords = map[string]bool{
+	"SELECT": true, "FROM": true, "INSTANCEOF": true, "WHERE": true, "GROUP": true, "BY": true,
+	"ORDER": true, "ASC": true, "DESC": true, "LIMIT": true, "AS": true, "AND": true, "OR": true,
+	"NOT": true, "IS": true, "NULL": true, "TRUE": true, "FALSE": true, "LIKE": true,
+}
+
+var querySizeSuffixes = map[string]int64{"KB": 1024, "MB": 1024 * 1024, "GB": 1024 * 1024 * 1024}
+
+// queryParser is the recursive descent parser of the query.
+type queryParser struct {
+	input  string
+	pos    int
+	peeked *queryToken
+	// aggregates are the aggregate functions found in the SELECT and ORDER BY clauses.
+	aggregates     []*aggregateExpr
+	allowAggregate bool
+}
+
+func (p *queryParser) errorf(pos int, format string, v ...interface{}) error {
+	return fmt.Errorf("query error at %d: %s", pos+1, fmt.Sprintf(format, v...))
+}
+
+func (p *queryParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *queryParser) scan() (*queryToken, error) {
+	p.skipSpaces()
+	start := p.pos
+	if p.pos >= len(p.input) {
+		return &queryToken{kind: queryTokenKind_EOF, pos: start, end: start}, nil
+	}
+
+	c := p.input[p.pos]
+	isIdent := func(c byte) bool {
+		return c == '_' || c == '$' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+	}
+	switch {
+	case c == '@' || isIdent(c) && !unicode.IsDigit(rune(c)):
+		p.pos++
+		for p.pos < len(p.input) && isIdent(p.input[p.pos]) {
+			p.pos++
+		}
+		kind := queryTokenKind_IDENT
+		if c == '@' {
+			kind = queryTokenKind_ATTRIBUTE
+		}
+		return &queryToken{kind: kind, text: p.input[start:p.pos], pos: start, end: p.pos}, nil
+	case unicode.IsDigit(rune(c)):
+		for p.pos < len(p.input) && (unicode.IsDigit(rune(p.input[p.pos])) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		text := p.input[start:p.pos]
+		var value interface{}
+		if strings.Contains(text, ".") {
+			f, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, p.errorf(start, "invalid number: %v", text)
+			}
+			value = f
+		} else {
+			n, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return nil, p.errorf(start, "invalid number: %v", text)
+			}
+			value = n
+		}
+		if p.pos+2 <= len(p.input) {
+			if multiplier, ok := querySizeSuffixes[strings.ToUpper(p.input[p.pos:p.pos+2])]; ok {
+				p.pos += 2
+				if f, ok := value.(float64); ok {
+					value = int64(f * float64(multiplier))
+				} else {
+					value = value.(int64) * multiplier
+				}
+			}
+		}
+		if p.pos < len(p.input) && isIdent(p.input[p.pos]) {
+			return nil, p.errorf(start, "invalid number: %v", p.input[start:p.pos+1])
+		}
+		return &queryToken{kind: queryTokenKind_NUMBER, text: p.input[start:p.pos], value: value, pos: start, end: p.pos}, nil
+	case c == '\'' || c == '"':
+		var b strings.Builder
+		p.pos++
+		for {
+			if p.pos >= len(p.input) {
+				return nil, p.errorf(start, "unterminated string")
+			}
+			if p.input[p.pos] == c {
+				// the quote is escaped by doubling it, as SQL.
+				if p.pos+1 < len(p.input) && p.input[p.pos+1] == c {
+					b.WriteByte(c)
+					p.pos += 2
+					continue
+				}
+				p.pos++
+				break
+			}
+			b.WriteByte(p.input[p.pos])
+			p.pos++
+		}
+		return &queryToken{kind: queryTokenKind_STRING, text: p.input[start:p.pos], value: b.String(), pos: start, end: p.pos}, nil
+	}
+
+	for _, symbol := range []string{"!=", "<>", "<=", ">=", "=", "<", ">", "(", ")", ",", ".", "[", "]", "*", "+", "-", "/", "%"} {
+		if strings.HasPrefix(p.input[p.pos:], symbol) {
+			p.pos += len(symbol)
+			return &queryToken{kind: queryTokenKind_SYMBOL, text: symbol, pos: start, end: p.pos}, nil
+		}
+	}
+	return nil, p.errorf(start, "unexpected character %q", c)
+}
+
+func (p *queryParser) peek() (*queryToken, error) {
+	if p.peeked == nil {
+		token, err := p.scan()
+		if err != nil {
+			return nil, err
+		}
+		p.peeked = token
+	}
+	return p.peeked, nil
+}
+
+func (p *queryParser) next() (*queryToken, error) {
+	token, err := p.peek()
+	p.peeked = nil
+	return token, err
+}
+
+// isKeyword returns true if the token is the keyword. Keywords are case-insensitive.
+func (t *queryToken) isKeyword(keyword string) bool {
+	return t.kind == queryTokenKind_IDENT && strings.EqualFold(t.text, keyword)
+}
+
+func (t *queryToken) isSymbol(symbol string) bool {
+	return t.kind == queryTokenKind_SYMBOL && t.text == symbol
+}
+
+// accept consumes the next token if it's the keyword or the symbol.
+func (p *queryParser) accept(text string) (bool, error) {
+	token, err := p.peek()
+	if err != nil {
+		return false, err
+	}
+	if token.isKeyword(text) || token.isSymbol(text) {
+		p.peeked = nil
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *queryParser) expect(text string) error {
+	ok, err := p.accept(text)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		token, _ := p.peek()
+		return p.errorf(token.pos, "%v is expected", text)
+	}
+	return nil
+}
+
+// ParseQuery parses the query:
+//
+//	SELECT <expr> [AS <name>], ... | *
+//	FROM [INSTANCEOF] <class pattern> [<alias>]
+//	[WHERE <expr>]
+//	[GROUP BY <expr>, ...]
+//	[ORDER BY <expr> [ASC|DESC], ...]
+//	[LIMIT <n>]
+//
+// The class pattern is the same as ClassPattern, e.g. java.util.HashMap, com.example.*, "/Cache$/" or byte[].
+// INSTANCEOF includes the instances of the sub classes.
+//
+// Expressions are evaluated for each object. `name` and `alias.name` are the fields of the object,
+// `a.b.c` follows the references, and `a[i]` is the element of the array. Attributes are:
+//
+//	@objectId, @className, @shallowSize, @retainedSize, @length (for the arrays)
+//
+// Operators are +, -, *, /, %, =, != (<>), <, <=, >, >=, LIKE (SQL style, % and _), IS [NOT] NULL, AND, OR and NOT.
+// Numbers accept the KB, MB and GB suffixes. Functions are:
+//
+//	toString(x)            the value of java.lang.String
+//	length(x)              the length of the string or the array
+//	classof(x)             the class name of the object
+//	referencedBy(x, 'p')   true if x is referenced by an instance of the classes matching the class pattern p
+//	count(*), count(x), sum(x), avg(x), min(x), max(x)    aggregate functions
+func ParseQuery(query string) (*Query, error) {
+	p := &queryParser{input: query}
+	q := new(Query)
+
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	star, err := p.accept("*")
+	if err != nil {
+		return nil, err
+	}
+	if !star {
+		p.allowAggregate = true
+		for {
+			item, err := p.parseSelectItem()
+			if err != nil {
+				return nil, err
+			}
+			q.selects = append(q.selects, item)
+			if ok, err := p.accept(","); err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+		}
+		p.allowAggregate = false
+	}
+
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	if err := p.parseFrom(q); err != nil {
+		return nil, err
+	}
+
+	if ok, err := p.accept("WHERE"); err != nil {
+		return nil, err
+	} else if ok {
+		if q.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if ok, err := p.accept("GROUP"); err != nil {
+		return nil, err
+	} else if ok {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			q.groupBy = append(q.groupBy, e)
+			if ok, err := p.accept(","); err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+		}
+	}
+
+	if ok, err := p.accept("ORDER"); err != nil {
+		return nil, err
+	} else if ok {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			item, err := p.parseOrderItem(q)
+			if err != nil {
+				return nil, err
+			}
+			q.orderBy = append(q.orderBy, item)
+			if ok, err := p.accept(","); err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+		}
+	}
+
+	if ok, err := p.accept("LIMIT"); err != nil {
+		return nil, err
+	} else if ok {
+		token, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		n, ok := token.value.(int64)
+		if token.kind != queryTokenKind_NUMBER || !ok || n <= 0 {
+			return nil, p.errorf(token.pos, "LIMIT should be a positive integer")
+		}
+		q.limit = int(n)
+	}
+
+	token, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if token.kind != queryTokenKind_EOF {
+		return nil, p.errorf(token.pos, "unexpected %q", token.text)
+	}
+
+	q.aggregates = p.aggregates
+	if (len(q.aggregates) > 0 || len(q.groupBy) > 0) && star {
+		return nil, fmt.Errorf("query error: SELECT * can't be used with the aggregation")
+	}
+	return q, nil
+}
+
+func (p *queryParser) parseSelectItem() (*selectItem, error) {
+	token, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	item := &selectItem{expr: e, name: strings.TrimSpace(p.input[token.pos:p.endOfLastToken()])}
+	if ok, err := p.accept("AS"); err != nil {
+		return nil, err
+	} else if ok {
+		token, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch token.kind {
+		case queryTokenKind_IDENT:
+			item.name = token.text
+		case queryTokenKind_STRING:
+			item.name = token.value.(string)
+		default:
+			return nil, p.errorf(token.pos, "column name is expected")
+		}
+	}
+	return item, nil
+}
+
+// endOfLastToken returns the end position of the consumed tokens.
+func (p *queryParser) endOfLastToken() int {
+	if p.peeked != nil {
+		return p.peeked.pos
+	}
+	return p.pos
+}
+
+// parseFrom parses the class pattern. The pattern is read as a raw word since it contains ".", "*", "[" and so on.
+func (p *queryParser) parseFrom(q *Query) error {
+	readWord := func() (string, int, error) {
+		p.skipSpaces()
+		start := p.pos
+		if p.pos < len(p.input) && (p.input[p.pos] == '\'' || p.input[p.pos] == '"') {
+			token, err := p.scan()
+			if err != nil {
+				return "", start, err
+			}
+			return token.value.(string), start, nil
+		}
+		for p.pos < len(p.input) && !unicode.IsSpace(rune(p.input[p.pos])) {
+			p.pos++
+		}
+		return p.input[start:p.pos], start, nil
+	}
+
+	word, pos, err := readWord()
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(word, "INSTANCEOF") {
+		q.instanceOf = true
+		if word, pos, err = readWord(); err != nil {
+			return err
+		}
+	}
+	if word == "" {
+		return p.errorf(pos, "class pattern is expected")
+	}
+	if q.from, err = NewClassPattern(word); err != nil {
+		return p.errorf(pos, "%v", err)
+	}
+
+	token, err := p.peek()
+	if err != nil {
+		return err
+	}
+	if token.kind == queryTokenKind_IDENT && !queryKeywords[strings.ToUpper(token.text)] {
+		q.alias = token.text
+		p.peeked = nil
+	}
+	return nil
+}
+
+func (p *queryParser) parseOrderItem(q *Query) (*orderItem, error) {
+	token, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	item := &orderItem{column: -1}
+	// ORDER BY accepts the column number, the column name or the expression.
+	if token.kind == queryTokenKind_NUMBER {
+		n, ok := token.value.(int64)
+		if !ok || n < 1 || int(n) > len(q.selects) {
+			return nil, p.errorf(token.pos, "invalid column number: %v", token.text)
+		}
+		p.peeked = nil
+		item.column = int(n) - 1
+	} else {
+		p.allowAggregate = true
+		e, err := p.parseExpr()
+		p.allowAggregate = false
+		if err != nil {
+			return nil, err
+		}
+		text := strings.TrimSpace(p.input[token.pos:p.endOfLastToken()])
+		for i, s := range q.selects {
+			if s.name == text {
+				item.column = i
+			}
+		}
+		if item.column < 0 {
+			item.expr = e
+		}
+	}
+
+	if ok, err := p.accept("DESC"); err != nil {
+		return nil, err
+	} else if ok {
+		item.descending = true
+	} else if _, err := p.accept("ASC"); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (p *queryParser) parseExpr() (queryExpr, error) {
+	return p.parseOr()
+}
+
+func (p *queryParser) parseOr() (queryExpr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		ok, err := p.accept("OR")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return x, nil
+		}
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &logicalExpr{and: false, x: x, y: y}
+	}
+}
+
+func (p *queryParser) parseAnd() (queryExpr, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		ok, err := p.accept("AND")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return x, nil
+		}
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &logicalExpr{and: true, x: x, y: y}
+	}
+}
+
+func (p *queryParser) parseNot() (queryExpr, error) {
+	if ok, err := p.accept("NOT"); err != nil {
+		return nil, err
+	} else if ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (queryExpr, error) {
+	x, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	token, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case token.isKeyword("IS"):
+		p.peeked = nil
+		not, err := p.accept("NOT")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		var e queryExpr = &isNullExpr{x}
+		if not {
+			e = &notExpr{e}
+		}
+		return e, nil
+	case token.isKeyword("LIKE"):
+		p.peeked = nil
+		token, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if token.kind != queryTokenKind_STRING {
+			return nil, p.errorf(token.pos, "LIKE pattern should be a string")
+		}
+		return &likeExpr{x: x, re: likePattern(token.value.(string))}, nil
+	case token.kind == queryTokenKind_SYMBOL:
+		switch token.text {
+		case "=", "!=", "<>", "<", "<=", ">", ">=":
+			p.peeked = nil
+			y, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			op := token.text
+			if op == "<>" {
+				op = "!="
+			}
+			return &binaryExpr{op: op, x: x, y: y}, nil
+		}
+	}
+	return x, nil
+}
+
+// likePattern converts the SQL LIKE pattern to the regular expression. "%" matches any characters and "_" matches a character.
+func likePattern(pattern string) *regexp.Regexp {
+	var re strings.Builder
+	re.WriteString("(?s)^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			re.WriteString(".*")
+		case '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String())
+}
+
+func (p *queryParser) parseAdditive() (queryExpr, error) {
+	x, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !token.isSymbol("+") && !token.isSymbol("-") {
+			return x, nil
+		}
+		p.peeked = nil
+		y, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: token.text, x: x, y: y}
+	}
+}
+
+func (p *queryParser) parseMultiplicative() (queryExpr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !token.isSymbol("*") && !token.isSymbol("/") && !token.isSymbol("%") {
+			return x, nil
+		}
+		p.peeked = nil
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: token.text, x: x, y: y}
+	}
+}
+
+func (p *queryParser) parseUnary() (queryExpr, error) {
+	if ok, err := p.accept("-"); err != nil {
+		return nil, err
+	} else if ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryExpr{op: "-", x: &literalExpr{int64(0)}, y: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *queryParser) parsePostfix() (queryExpr, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if ok, err := p.accept("."); err != nil {
+			return nil, err
+		} else if ok {
+			token, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			switch token.kind {
+			case queryTokenKind_IDENT:
+				x = &fieldExpr{target: x, name: token.text}
+			case queryTokenKind_ATTRIBUTE:
+				if x, err = p.newAttributeExpr(x, token); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, p.errorf(token.pos, "field name is expected")
+			}
+			continue
+		}
+		if ok, err := p.accept("["); err != nil {
+			return nil, err
+		} else if ok {
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexExpr{target: x, index: index}
+			continue
+		}
+		return x, nil
+	}
+}
+
+func (p *queryParser) newAttributeExpr(target queryExpr, token *queryToken) (queryExpr, error) {
+	for _, name := range queryAttributes {
+		if strings.EqualFold(token.text, name) {
+			return &attributeExpr{target: target, name: name}, nil
+		}
+	}
+	return nil, p.errorf(token.pos, "unknown attribute: %v", token.text)
+}
+
+func (p *queryParser) parsePrimary() (queryExpr, error) {
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch token.kind {
+	case queryTokenKind_NUMBER, queryTokenKind_STRING:
+		return &literalExpr{token.value}, nil
+	case queryTokenKind_ATTRIBUTE:
+		return p.newAttributeExpr(&thisExpr{}, token)
+	case queryTokenKind_SYMBOL:
+		if token.text == "(" {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	case queryTokenKind_IDENT:
+		switch strings.ToUpper(token.text) {
+		case "NULL":
+			return &literalExpr{nil}, nil
+		case "TRUE":
+			return &literalExpr{true}, nil
+		case "FALSE":
+			return &literalExpr{false}, nil
+		}
+		if queryKeywords[strings.ToUpper(token.text)] {
+			break
+		}
+		if ok, err := p.accept("("); err != nil {
+			return nil, err
+		} else if ok {
+			return p.parseCall(token)
+		}
+		return &identExpr{name: token.text}, nil
+	case queryTokenKind_EOF:
+		return nil, p.errorf(token.pos, "unexpected end of the query")
+	}
+	return nil, p.errorf(token.pos, "unexpected %q", token.text)
+}
+
+func (p *queryParser) parseCall(name *queryToken) (queryExpr, error) {
+	function := strings.ToLower(name.text)
+	if _, ok := queryAggregateFunctions[function]; ok {
+		if !p.allowAggregate {
+			return nil, p.errorf(name.pos, "aggregate function %v is not allowed here", name.text)
+		}
+		e := &aggregateExpr{function: function, index: len(p.aggregates)}
+		if function == "count" {
+			if ok, err := p.accept("*"); err != nil {
+				return nil, err
+			} else if ok {
+				p.aggregates = append(p.aggregates, e)
+				return e, p.expect(")")
+			}
+		}
+		// aggregate functions can't be nested.
+		p.allowAggregate = false
+		arg, err := p.parseExpr()
+		p.allowAggregate = true
+		if err != nil {
+			return nil, err
+		}
+		e.arg = arg
+		p.aggregates = append(p.aggregates, e)
+		return e, p.expect(")")
+	}
+
+	f, ok := queryFunctions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, p.errorf(name.pos, "unknown function: %v", name.text)
+	}
+	var args []queryExpr
+	if ok, err := p.accept(")"); err != nil {
+		return nil, err
+	} else if !ok {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if ok, err := p.accept(","); err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) != f.arity {
+		return nil, p.errorf(name.pos, "%v takes %d argument(s)", name.text, f.arity)
+	}
+	return &callExpr{function: f, args: args}, nil
+}
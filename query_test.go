@@ -0,0 +1,142 @@
+package heapdump
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseQueryError(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"SELECT",
+		"SELECT x",
+		"SELECT x FROM",
+		"SELECT x FROM Object1 WHERE",
+		"SELECT x FROM Object1 WHERE count(*) > 1",
+		"SELECT sum(sum(x)) FROM Object1",
+		"SELECT unknown(x) FROM Object1",
+		"SELECT @unknown FROM Object1",
+		"SELECT * FROM Object1 GROUP BY x",
+		"SELECT x FROM Object1 LIMIT 0",
+		"SELECT x FROM Object1 ORDER BY 2",
+		"SELECT 'x FROM Object1",
+		"SELECT x FROM /(/",
+		"SELECT x FROM Object1 y z",
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("%q should be an error", query)
+		}
+	}
+}
+
+func (a *Tester) Query(query string) *QueryResult {
+	result, err := a.analyzer.Query(query)
+	if err != nil {
+		a.t.Fatalf("%v: %v", query, err)
+	}
+	return result
+}
+
+func TestQuery(t *testing.T) {
+	tester := NewTester("testdata/array/heapdump.hprof", t)
+	defer tester.Close()
+
+	result := tester.Query("SELECT o, o.r2.@length, length(o.o2), o.o2[0] IS NOT NULL, o.o2[10] FROM Object1 o")
+	if len(result.Rows) != 1 {
+		t.Fatalf("Only one Object1 exists: %v", result.Rows)
+	}
+	row := result.Rows[0]
+	if row[0].(*ObjectReference).ClassName != "Object1" || row[1] != int64(10) || row[2] != int64(10) ||
+		row[3] != true || row[4] != nil {
+		t.Fatalf("Unexpected row: %v", row)
+	}
+
+	result = tester.Query("SELECT count(*), sum(@shallowSize) AS size FROM Object2")
+	if result.Columns[1] != "size" || result.Rows[0][0] != int64(10) {
+		t.Fatalf("Unexpected result: %v %v", result.Columns, result.Rows)
+	}
+
+	result = tester.Query("SELECT a FROM Object2[] a")
+	if len(result.Rows) != 1 || result.Rows[0][0].(*ObjectReference).ClassName != "Object2[]" {
+		t.Fatalf("Unexpected result: %v", result.Rows)
+	}
+
+	// INSTANCEOF includes Object1, Object2 and so on.
+	result = tester.Query("SELECT classof(o), count(*) FROM INSTANCEOF java.lang.Object o " +
+		"WHERE classof(o) LIKE 'Object_' GROUP BY classof(o) ORDER BY 2 DESC, 1")
+	if len(result.Rows) < 2 || result.Rows[0][0] != "Object2" || result.Rows[0][1] != int64(10) {
+		t.Fatalf("Unexpected result: %v", result.Rows)
+	}
+
+	result = tester.Query("SELECT count(*) FROM Object1 WHERE 1 = 2")
+	if len(result.Rows) != 1 || result.Rows[0][0] != int64(0) {
+		t.Fatalf("count(*) should be 0: %v", result.Rows)
+	}
+}
+
+func TestQueryString(t *testing.T) {
+	tester := NewTester("testdata/string/heapdump.hprof", t)
+	defer tester.Close()
+
+	result := tester.Query("SELECT o.stringEntry, length(o.stringEntry) FROM Object1 o WHERE o.stringEntry = 'abcdefghijklmnopqrstuvwxyz'")
+	if len(result.Rows) != 1 || result.Rows[0][1] != int64(26) {
+		t.Fatalf("Unexpected result: %v", result.Rows)
+	}
+
+	var buf bytes.Buffer
+	if err := result.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"length(o.stringEntry)": 26`) {
+		t.Fatalf("Unexpected JSON: %v", buf.String())
+	}
+}
+
+func TestQueryArithmetic(t *testing.T) {
+	for _, test := range []struct {
+		op       string
+		x, y     interface{}
+		expected interface{}
+	}{
+		{"/", int64(7), int64(2), int64(3)},
+		{"/", int64(7), int64(0), nil},
+		{"%", int64(7), int64(0), nil},
+		{"/", 7.0, 2.0, 3.5},
+		{"/", 7.0, 0.0, nil},
+		{"%", 7.0, 0.0, nil},
+		{"/", int64(7), 0.0, nil},
+		{"+", int64(7), nil, nil},
+	} {
+		if v, err := arithmetic(test.op, test.x, test.y); err != nil || v != test.expected {
+			t.Errorf("%v %v %v should be %v. But %v, %v", test.x, test.op, test.y, test.expected, v, err)
+		}
+	}
+}
+
+func TestQueryResultWriteJSON(t *testing.T) {
+	result := &QueryResult{
+		Columns: []string{"f", "nan", "inf"},
+		Rows:    [][]interface{}{{1.5, math.NaN(), math.Inf(-1)}},
+	}
+	var buf bytes.Buffer
+	if err := result.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("Invalid JSON: %v: %v", err, buf.String())
+	}
+	if len(rows) != 1 || rows[0]["f"] != 1.5 || rows[0]["nan"] != "NaN" || rows[0]["inf"] != "-Inf" {
+		t.Errorf("NaN and Infinity should be the strings. But %v", rows)
+	}
+
+	// nothing is written on the error.
+	result.Rows = append(result.Rows, []interface{}{1.0, 2.0, make(chan int)})
+	buf.Reset()
+	if err := result.WriteJSON(&buf); err == nil || buf.Len() != 0 {
+		t.Errorf("The unsupported value should be an error without the output. But %v, %q", err, buf.String())
+	}
+}
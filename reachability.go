@@ -0,0 +1,142 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+)
+
+// ReferenceStrength is the strength of a reference, from the strongest to the weakest.
+// The `referent` field of the subclasses of java/lang/ref/Reference is not strong, and the others are strong.
+type ReferenceStrength int
+
+const (
+	ReferenceStrength_STRONG ReferenceStrength = iota
+	ReferenceStrength_SOFT
+	ReferenceStrength_WEAK
+	// ReferenceStrength_FINALIZER is the referent of java/lang/ref/Finalizer, which waits for finalize() to be called.
+	ReferenceStrength_FINALIZER
+	ReferenceStrength_PHANTOM
+)
+
+var referenceStrengthNames = []string{"strong", "soft", "weak", "finalizer", "phantom"}
+
+func (s ReferenceStrength) String() string {
+	return referenceStrengthNames[s]
+}
+
+// WeakReferenceStrengths are the strengths other than strong, from the strongest.
+var WeakReferenceStrengths = []ReferenceStrength{
+	ReferenceStrength_SOFT,
+	ReferenceStrength_WEAK,
+	ReferenceStrength_FINALIZER,
+	ReferenceStrength_PHANTOM,
+}
+
+// referenceClassStrengths are the classes which define the strength of the referent of their subclasses.
+var referenceClassStrengths = map[string]ReferenceStrength{
+	"java/lang/ref/SoftReference":    ReferenceStrength_SOFT,
+	"java/lang/ref/WeakReference":    ReferenceStrength_WEAK,
+	"java/lang/ref/FinalReference":   ReferenceStrength_FINALIZER,
+	"java/lang/ref/PhantomReference": ReferenceStrength_PHANTOM,
+}
+
+// scanReferenceClasses finds the subclasses of java/lang/ref/Reference and the `referent` field, for ForEachReference.
+func (h *HProf) scanReferenceClasses() error {
+	supers := make(map[uint64]uint64)
+	strengths := make(map[uint64]ReferenceStrength)
+	err := h.ForEachClassDump(func(classDump *hprofdata.HProfClassDump) error {
+		supers[classDump.ClassObjectId] = classDump.SuperClassObjectId
+		name, err := h.GetClassNameByClassObjectId(classDump.ClassObjectId)
+		if err != nil {
+			// the class without the name can't be the reference class.
+			return nil
+		}
+		if name == "java/lang/ref/Reference" {
+			h.referenceClassObjectId = classDump.ClassObjectId
+			for _, field := range classDump.InstanceFields {
+				if h.GetFieldName(field.NameId) == "referent" {
+					h.referentNameId = field.NameId
+				}
+			}
+		}
+		if strength, ok := referenceClassStrengths[name]; ok {
+			strengths[classDump.ClassObjectId] = strength
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for classObjectId := range supers {
+		for current := classObjectId; current != 0; current = supers[current] {
+			if strength, ok := strengths[current]; ok {
+				h.referenceClasses[classObjectId] = strength
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// referentStrength returns the strength of the instance field of the instance. The field is declared by declaringClassObjectId.
+func (h HProf) referentStrength(instanceDump *hprofdata.HProfInstanceDump, declaringClassObjectId uint64, nameId uint64) ReferenceStrength {
+	if declaringClassObjectId != h.referenceClassObjectId || nameId != h.referentNameId {
+		return ReferenceStrength_STRONG
+	}
+	return h.referenceClasses[instanceDump.ClassObjectId]
+}
+
+// Reachability is the strength of the strongest path from the GC roots to each object. The strength of a path is the
+// weakest reference on it, e.g. the objects only referred by a WeakReference, directly or indirectly, are weakly reachable.
+// The unreachable objects are not included.
+type Reachability map[uint64]ReferenceStrength
+
+// ScanReachability follows the references from the GC roots, from the strongest references to the weakest ones.
+func (a HeapDumpAnalyzer) ScanReachability() (Reachability, error) {
+	reachability := make(Reachability)
+	// pending[strength] are the objects referred by the references of the strength, to visit at the strength.
+	pending := make([][]uint64, len(referenceStrengthNames))
+	pending[ReferenceStrength_STRONG] = a.hprof.RootObjectIds()
+	for strength := ReferenceStrength_STRONG; int(strength) < len(pending); strength++ {
+		stack := pending[strength]
+		for len(stack) > 0 {
+			objectId := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if _, ok := reachability[objectId]; ok {
+				continue
+			}
+			ok, err := a.hprof.HasObject(objectId)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			reachability[objectId] = strength
+			err = a.hprof.ForEachReference(objectId, func(ref *Reference) error {
+				if _, ok := reachability[ref.ObjectId]; ok {
+					return nil
+				}
+				if ref.Strength > strength {
+					pending[ref.Strength] = append(pending[ref.Strength], ref.ObjectId)
+				} else {
+					stack = append(stack, ref.ObjectId)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return reachability, nil
+}
+
+// ReachabilityHistogram returns the histogram of the objects reachable at the strength, e.g. the objects only softly
+// reachable, which are not included in the retained sizes.
+func (a HeapDumpAnalyzer) ReachabilityHistogram(reachability Reachability, strength ReferenceStrength) ([]*HistogramEntry, error) {
+	return a.histogram(func(objectId uint64) bool {
+		s, ok := reachability[objectId]
+		return ok && s == strength
+	})
+}
@@ -0,0 +1,115 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"testing"
+)
+
+// referenceClasses declares java/lang/ref/Reference and its subclasses, by the class name.
+func referenceClasses(b *HProfBuilder, object uint64) map[string]uint64 {
+	reference := b.AddClass("java/lang/ref/Reference", object,
+		HProfBuilderField{"referent", hprofdata.HProfValueType_OBJECT},
+		HProfBuilderField{"queue", hprofdata.HProfValueType_OBJECT})
+	finalReference := b.AddClass("java/lang/ref/FinalReference", reference)
+	return map[string]uint64{
+		"java/lang/ref/SoftReference":    b.AddClass("java/lang/ref/SoftReference", reference),
+		"java/lang/ref/WeakReference":    b.AddClass("java/lang/ref/WeakReference", reference),
+		"java/lang/ref/PhantomReference": b.AddClass("java/lang/ref/PhantomReference", reference),
+		"java/lang/ref/Finalizer": b.AddClass("java/lang/ref/Finalizer", finalReference,
+			HProfBuilderField{"next", hprofdata.HProfValueType_OBJECT},
+			HProfBuilderField{"prev", hprofdata.HProfValueType_OBJECT}),
+	}
+}
+
+func TestReferenceStrength(t *testing.T) {
+	b := NewHProfBuilder(8)
+	node := nodeClass(b)
+	references := referenceClasses(b, b.AddClass("java/lang/Object", 0))
+
+	// WeakReference -> Node(weak) -> Node(weak), SoftReference -> Node(soft) -> Node(strong)
+	weakReference := b.AddInstance(references["java/lang/ref/WeakReference"])
+	weak1 := b.AddInstance(node)
+	weak2 := b.AddInstance(node)
+	b.SetField(weakReference, "referent", weak1)
+	b.SetField(weak1, "next", weak2)
+	softReference := b.AddInstance(references["java/lang/ref/SoftReference"])
+	soft := b.AddInstance(node)
+	strong := b.AddInstance(node)
+	b.SetField(softReference, "referent", soft)
+	b.SetField(soft, "next", strong)
+	// Finalizer -> Node(finalizer), with the strong reference to the queue
+	finalizer := b.AddInstance(references["java/lang/ref/Finalizer"])
+	finalizable := b.AddInstance(node)
+	queue := b.AddInstance(node)
+	b.SetField(finalizer, "referent", finalizable)
+	b.SetField(finalizer, "queue", queue)
+	// SoftReference(weakly reachable) -> Node(weak)
+	weakSoftReference := b.AddInstance(references["java/lang/ref/SoftReference"])
+	weakSoft := b.AddInstance(node)
+	b.SetField(weak2, "next", weakSoftReference)
+	b.SetField(weakSoftReference, "referent", weakSoft)
+	phantomReference := b.AddInstance(references["java/lang/ref/PhantomReference"])
+	phantom := b.AddInstance(node)
+	b.SetField(phantomReference, "referent", phantom)
+	for _, objectId := range []uint64{weakReference, softReference, finalizer, phantomReference, strong} {
+		b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, objectId)
+	}
+	tester := builderTestData(t, b)
+	defer tester.Close()
+
+	reachability, err := tester.analyzer.ScanReachability()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for objectId, expected := range map[uint64]ReferenceStrength{
+		weakReference:     ReferenceStrength_STRONG,
+		weak1:             ReferenceStrength_WEAK,
+		weak2:             ReferenceStrength_WEAK,
+		weakSoftReference: ReferenceStrength_WEAK,
+		weakSoft:          ReferenceStrength_WEAK,
+		soft:              ReferenceStrength_SOFT,
+		strong:            ReferenceStrength_STRONG,
+		finalizable:       ReferenceStrength_FINALIZER,
+		queue:             ReferenceStrength_STRONG,
+		phantom:           ReferenceStrength_PHANTOM,
+	} {
+		if strength, ok := reachability[objectId]; !ok || strength != expected {
+			t.Errorf("%v should be %v reachable. But %v", objectId, expected, strength)
+		}
+	}
+
+	histogram, err := tester.analyzer.ReachabilityHistogram(reachability, ReferenceStrength_WEAK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, entry := range histogram {
+		counts[entry.Name] = entry.Count
+	}
+	if len(counts) != 2 || counts["Node"] != 3 || counts["java/lang/ref/SoftReference"] != 1 {
+		t.Errorf("3 Nodes and a SoftReference should be weakly reachable. But %v", counts)
+	}
+
+	// Reference: 16 + referent + queue, Finalizer: Reference + next + prev
+	assertRetainedSizes(t, tester, map[uint64]uint64{
+		weakReference: 32,
+		softReference: 32,
+		finalizer:     48 + 28,
+	})
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Contains(weak1) || tree.Contains(soft) || tree.Contains(phantom) {
+		t.Errorf("The referents shouldn't be strongly reachable")
+	}
+
+	rootScanner := NewRootScanner(tester.analyzer.logger)
+	if err := rootScanner.ScanAll(tester.analyzer); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := tester.analyzer.GetRetainedSize(weakReference, rootScanner); err != nil || size != 32 {
+		t.Errorf("WeakReference should retain 32 bytes. But %v, %v", size, err)
+	}
+}
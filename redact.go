@@ -0,0 +1,539 @@
+package heapdump
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+type RedactMode int
+
+const (
+	// RedactMode_ZERO fills the values with zeros.
+	RedactMode_ZERO RedactMode = iota
+	// RedactMode_HASH replaces the values with the keyed hash, so the same values are still the same after the redaction.
+	RedactMode_HASH
+)
+
+// Redactor rewrites the hprof file with the primitive values redacted. The class structure, the references and
+// the sizes are kept, so the redacted heap dump can be analyzed as same as the original one.
+//
+// The contents of the primitive arrays and the primitive instance and static fields are redacted, except:
+//
+//   - the fields of the allowed classes, and the primitive arrays referred by the instances of them, directly or
+//     through a java.lang.String. e.g. allow "java.lang.Thread" to keep the thread names.
+//   - the primitive arrays whose type is allowed, e.g. "int[]".
+type Redactor struct {
+	mode    RedactMode
+	salt    []byte
+	allowed []*ClassPattern
+
+	idSize int
+	// class object ID -> class name
+	classNames map[uint64]string
+	// class object ID -> class dump, with the instance fields only
+	classes map[uint64]*redactorClass
+	// the objects referred by the instances of the allowed classes. The primitive arrays in it are kept.
+	allowedArrays map[uint64]bool
+	// java/lang/String object ID -> value array ID
+	stringValues map[uint64]uint64
+}
+
+type redactorClass struct {
+	superClassObjectId uint64
+	fieldTypes         []hprofdata.HProfValueType
+	// keptFields are the fields never redacted, e.g. String.coder which is needed to decode the redacted value.
+	keptFields []bool
+	allowed    bool
+	isString   bool
+}
+
+// NewRedactor creates the redactor. salt is the key of the hash for RedactMode_HASH.
+func NewRedactor(mode RedactMode, salt []byte) *Redactor {
+	m := new(Redactor)
+	m.mode = mode
+	m.salt = salt
+	return m
+}
+
+// Allow keeps the values of the classes matching the pattern.
+func (r *Redactor) Allow(pattern *ClassPattern) {
+	r.allowed = append(r.allowed, pattern)
+}
+
+func (r *Redactor) isAllowed(className string) bool {
+	for _, pattern := range r.allowed {
+		if pattern.Match(className) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact reads the hprof file and writes the redacted one. It reads the file twice, to know the classes before
+// redacting the instances, and three times if any classes are allowed, to find the arrays referred by them.
+func (r *Redactor) Redact(hprofPath string, w io.Writer) error {
+	r.classNames = make(map[uint64]string)
+	r.classes = make(map[uint64]*redactorClass)
+	r.allowedArrays = make(map[uint64]bool)
+	r.stringValues = make(map[uint64]uint64)
+
+	passes := []*redactorPass{{collectClasses: true}}
+	if len(r.allowed) > 0 {
+		passes = append(passes, &redactorPass{collectAllowedArrays: true})
+	}
+	passes = append(passes, &redactorPass{redact: true})
+	for _, pass := range passes {
+		f, err := os.Open(hprofPath)
+		if err != nil {
+			return err
+		}
+		out := ioutil.Discard
+		if pass.redact {
+			out = w
+		}
+		pass.redactor = r
+		pass.r = bufio.NewReaderSize(f, 1024*1024)
+		pass.w = bufio.NewWriterSize(out, 1024*1024)
+		err = pass.run()
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if pass.collectAllowedArrays {
+			// the strings referred by the allowed instances.
+			for objectId := range r.allowedArrays {
+				if value, ok := r.stringValues[objectId]; ok {
+					r.allowedArrays[value] = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// redactorPass reads the hprof file once, and copies it to w with redacting the values if redact is true.
+type redactorPass struct {
+	redactor             *Redactor
+	r                    *bufio.Reader
+	w                    *bufio.Writer
+	collectClasses       bool
+	collectAllowedArrays bool
+	redact               bool
+	// class name string ID -> name
+	names map[uint64]string
+	// the number of the bytes read
+	offset int64
+}
+
+func (p *redactorPass) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	p.offset += int64(n)
+	return b, nil
+}
+
+func (p *redactorPass) write(b []byte) error {
+	_, err := p.w.Write(b)
+	return err
+}
+
+func (p *redactorPass) copy(n int) ([]byte, error) {
+	b, err := p.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return b, p.write(b)
+}
+
+func (p *redactorPass) copyUint(n int) (uint64, error) {
+	b, err := p.copy(n)
+	if err != nil {
+		return 0, err
+	}
+	return decodeUint(b), nil
+}
+
+func (p *redactorPass) copyId() (uint64, error) {
+	return p.copyUint(p.redactor.idSize)
+}
+
+func decodeUint(b []byte) uint64 {
+	v := uint64(0)
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func (p *redactorPass) valueSize(valueType hprofdata.HProfValueType) (int, error) {
+	if valueType == hprofdata.HProfValueType_OBJECT {
+		return p.redactor.idSize, nil
+	}
+	size, ok := parser.ValueSize[valueType]
+	if !ok {
+		return 0, fmt.Errorf("unknown value type: %d", valueType)
+	}
+	return size, nil
+}
+
+func (p *redactorPass) run() error {
+	header, err := p.r.ReadBytes(0)
+	if err != nil {
+		return fmt.Errorf("invalid hprof header: %v", err)
+	}
+	if err := p.write(header); err != nil {
+		return err
+	}
+	idSize, err := p.copyUint(4)
+	if err != nil {
+		return err
+	}
+	if idSize != 4 && idSize != 8 {
+		return fmt.Errorf("unsupported identifier size: %d", idSize)
+	}
+	p.redactor.idSize = int(idSize)
+	// timestamp
+	if _, err := p.copy(8); err != nil {
+		return err
+	}
+	p.names = make(map[uint64]string)
+
+	for {
+		tag, err := p.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.write([]byte{tag}); err != nil {
+			return err
+		}
+		// time
+		if _, err := p.copy(4); err != nil {
+			return err
+		}
+		length, err := p.copyUint(4)
+		if err != nil {
+			return err
+		}
+
+		switch parser.HProfRecordType(tag) {
+		case parser.HProfRecordTypeUTF8:
+			body, err := p.copy(int(length))
+			if err != nil {
+				return err
+			}
+			if p.collectClasses {
+				p.names[decodeUint(body[:p.redactor.idSize])] = string(body[p.redactor.idSize:])
+			}
+		case parser.HProfRecordTypeLoadClass:
+			body, err := p.copy(int(length))
+			if err != nil {
+				return err
+			}
+			if p.collectClasses {
+				idSize := p.redactor.idSize
+				classObjectId := decodeUint(body[4 : 4+idSize])
+				nameId := decodeUint(body[4+idSize+4 : 4+idSize+4+idSize])
+				p.redactor.classNames[classObjectId] = p.names[nameId]
+			}
+		case parser.HProfRecordTypeHeapDump, parser.HProfRecordTypeHeapDumpSegment:
+			if err := p.heapDump(int64(length)); err != nil {
+				return err
+			}
+		default:
+			if _, err := io.CopyN(p.w, p.r, int64(length)); err != nil {
+				return err
+			}
+		}
+	}
+	return p.w.Flush()
+}
+
+func (p *redactorPass) heapDump(length int64) error {
+	idSize := p.redactor.idSize
+	end := p.offset + length
+	for p.offset < end {
+		tag, err := p.copy(1)
+		if err != nil {
+			return err
+		}
+		switch parser.HProfHDRecordType(tag[0]) {
+		case parser.HProfHDRecordTypeRootUnknown, parser.HProfHDRecordTypeRootStickyClass, parser.HProfHDRecordTypeRootMonitorUsed:
+			_, err = p.copy(idSize)
+		case parser.HProfHDRecordTypeRootJNIGlobal:
+			_, err = p.copy(idSize * 2)
+		case parser.HProfHDRecordTypeRootJNILocal, parser.HProfHDRecordTypeRootJavaFrame, parser.HProfHDRecordTypeRootThreadObj:
+			_, err = p.copy(idSize + 8)
+		case parser.HProfHDRecordTypeRootNativeStack, parser.HProfHDRecordTypeRootThreadBlock:
+			_, err = p.copy(idSize + 4)
+		case parser.HProfHDRecordTypeClassDump:
+			err = p.classDump()
+		case parser.HProfHDRecordTypeInstanceDump:
+			err = p.instanceDump()
+		case parser.HProfHDRecordTypeObjectArrayDump:
+			err = p.objectArrayDump()
+		case parser.HProfHDRecordTypePrimitiveArrayDump:
+			err = p.primitiveArrayDump()
+		default:
+			return fmt.Errorf("unsupported heap dump record type: 0x%x", tag[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if p.offset != end {
+		return fmt.Errorf("heap dump record overruns its length")
+	}
+	return nil
+}
+
+func (p *redactorPass) classDump() error {
+	idSize := p.redactor.idSize
+	classObjectId, err := p.copyId()
+	if err != nil {
+		return err
+	}
+	// stack trace serial number
+	if _, err := p.copy(4); err != nil {
+		return err
+	}
+	superClassObjectId, err := p.copyId()
+	if err != nil {
+		return err
+	}
+	// class loader, signers, protection domain, reserved * 2 and instance size
+	if _, err := p.copy(idSize*5 + 4); err != nil {
+		return err
+	}
+	class := p.redactor.classes[classObjectId]
+	if p.collectClasses {
+		class = &redactorClass{
+			superClassObjectId: superClassObjectId,
+			allowed:            p.redactor.isAllowed(p.redactor.classNames[classObjectId]),
+			isString:           p.redactor.classNames[classObjectId] == "java/lang/String",
+		}
+		p.redactor.classes[classObjectId] = class
+	}
+	if class == nil {
+		return fmt.Errorf("class %d is not found", classObjectId)
+	}
+
+	constantPoolSize, err := p.copyUint(2)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(constantPoolSize); i++ {
+		// constant pool index
+		if _, err := p.copy(2); err != nil {
+			return err
+		}
+		if _, err := p.value(false); err != nil {
+			return err
+		}
+	}
+
+	staticFieldCount, err := p.copyUint(2)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(staticFieldCount); i++ {
+		// name
+		if _, err := p.copy(idSize); err != nil {
+			return err
+		}
+		if _, err := p.value(!class.allowed); err != nil {
+			return err
+		}
+	}
+
+	instanceFieldCount, err := p.copyUint(2)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(instanceFieldCount); i++ {
+		field, err := p.copy(idSize + 1)
+		if err != nil {
+			return err
+		}
+		if p.collectClasses {
+			class.fieldTypes = append(class.fieldTypes, hprofdata.HProfValueType(field[idSize]))
+			class.keptFields = append(class.keptFields, class.isString && p.names[decodeUint(field[:idSize])] == "coder")
+		}
+	}
+	return nil
+}
+
+// value copies the type and the value. The primitive value is redacted if redact is true.
+func (p *redactorPass) value(redact bool) (hprofdata.HProfValueType, error) {
+	t, err := p.copy(1)
+	if err != nil {
+		return 0, err
+	}
+	valueType := hprofdata.HProfValueType(t[0])
+	size, err := p.valueSize(valueType)
+	if err != nil {
+		return 0, err
+	}
+	b, err := p.read(size)
+	if err != nil {
+		return 0, err
+	}
+	if redact && p.redact && valueType != hprofdata.HProfValueType_OBJECT {
+		p.redactor.redactValue(b, valueType)
+	}
+	return valueType, p.write(b)
+}
+
+func (p *redactorPass) instanceDump() error {
+	objectId, err := p.copyId()
+	if err != nil {
+		return err
+	}
+	// stack trace serial number
+	if _, err := p.copy(4); err != nil {
+		return err
+	}
+	classObjectId, err := p.copyId()
+	if err != nil {
+		return err
+	}
+	length, err := p.copyUint(4)
+	if err != nil {
+		return err
+	}
+	values, err := p.read(int(length))
+	if err != nil {
+		return err
+	}
+	class := p.redactor.classes[classObjectId]
+	if class == nil {
+		return fmt.Errorf("class %d is not found", classObjectId)
+	}
+	// the strings referred by the allowed instances are kept too.
+	allowed := class.allowed || class.isString && p.redactor.allowedArrays[objectId]
+
+	offset := 0
+	for c := class; c != nil; c = p.redactor.classes[c.superClassObjectId] {
+		for i, fieldType := range c.fieldTypes {
+			size, err := p.valueSize(fieldType)
+			if err != nil {
+				return err
+			}
+			if offset+size > len(values) {
+				return fmt.Errorf("instance of class %d is shorter than its fields", classObjectId)
+			}
+			value := values[offset : offset+size]
+			if fieldType == hprofdata.HProfValueType_OBJECT {
+				if allowed && p.collectAllowedArrays {
+					p.redactor.allowedArrays[decodeUint(value)] = true
+				}
+				if class.isString && p.collectAllowedArrays {
+					p.redactor.stringValues[objectId] = decodeUint(value)
+				}
+			} else if !allowed && !c.keptFields[i] && p.redact {
+				p.redactor.redactValue(value, fieldType)
+			}
+			offset += size
+		}
+	}
+	return p.write(values)
+}
+
+func (p *redactorPass) objectArrayDump() error {
+	idSize := p.redactor.idSize
+	// object ID and stack trace serial number
+	if _, err := p.copy(idSize + 4); err != nil {
+		return err
+	}
+	length, err := p.copyUint(4)
+	if err != nil {
+		return err
+	}
+	// array class ID and elements
+	_, err = io.CopyN(p.w, p.r, int64(idSize)*int64(length+1))
+	p.offset += int64(idSize) * int64(length+1)
+	return err
+}
+
+func (p *redactorPass) primitiveArrayDump() error {
+	arrayObjectId, err := p.copyId()
+	if err != nil {
+		return err
+	}
+	// stack trace serial number
+	if _, err := p.copy(4); err != nil {
+		return err
+	}
+	length, err := p.copyUint(4)
+	if err != nil {
+		return err
+	}
+	t, err := p.copy(1)
+	if err != nil {
+		return err
+	}
+	elementType := hprofdata.HProfValueType(t[0])
+	size, err := p.valueSize(elementType)
+	if err != nil {
+		return err
+	}
+	elements, err := p.read(size * int(length))
+	if err != nil {
+		return err
+	}
+	if p.redact && !p.redactor.allowedArrays[arrayObjectId] && !p.redactor.isAllowed(GetPrimitiveArrayTypeName(elementType)) {
+		p.redactor.redactArray(elements, elementType)
+	}
+	return p.write(elements)
+}
+
+// redactValue overwrites the primitive value in place.
+func (r *Redactor) redactValue(b []byte, valueType hprofdata.HProfValueType) {
+	if r.mode == RedactMode_ZERO {
+		for i := range b {
+			b[i] = 0
+		}
+		return
+	}
+	digest := r.hash(valueType, b)
+	copy(b, digest)
+	if valueType == hprofdata.HProfValueType_BOOLEAN {
+		b[0] &= 1
+	}
+}
+
+// redactArray overwrites the elements of the primitive array in place. In RedactMode_HASH, the elements are filled
+// with the hash of the whole array, so the same arrays, e.g. the duplicated strings, are still the same.
+func (r *Redactor) redactArray(b []byte, elementType hprofdata.HProfValueType) {
+	if r.mode == RedactMode_ZERO || elementType == hprofdata.HProfValueType_BOOLEAN {
+		for i := range b {
+			b[i] = 0
+		}
+		return
+	}
+	digest := r.hash(elementType, b)
+	for i := 0; i < len(b); i += len(digest) {
+		copy(b[i:], digest)
+	}
+}
+
+func (r *Redactor) hash(valueType hprofdata.HProfValueType, b []byte) []byte {
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte{byte(valueType)})
+	mac.Write(b)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,75 @@
+package heapdump
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func redactTestData(t *testing.T, path string, redactor *Redactor) *Tester {
+	dir, err := ioutil.TempDir("", "redact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the heap dump is read into the index by NewTester.
+	defer os.RemoveAll(dir)
+	redactedPath := filepath.Join(dir, "heapdump.hprof")
+	f, err := os.Create(redactedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := redactor.Redact(path, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return NewTester(redactedPath, t)
+}
+
+func (a *Tester) QueryString(query string) string {
+	result := a.Query(query)
+	if len(result.Rows) != 1 {
+		a.t.Fatalf("%v should return a row. But %v", query, len(result.Rows))
+	}
+	s, _ := result.Rows[0][0].(string)
+	return s
+}
+
+func TestRedactZero(t *testing.T) {
+	tester := redactTestData(t, "testdata/string/heapdump.hprof", NewRedactor(RedactMode_ZERO, nil))
+	defer tester.Close()
+
+	query := "SELECT toString(o.stringEntry) FROM Object1 o"
+	if s := tester.QueryString(query); s != "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("The string should be zeroed. But %q", s)
+	}
+}
+
+func TestRedactHash(t *testing.T) {
+	redactor := NewRedactor(RedactMode_HASH, []byte("salt"))
+	pattern, err := NewClassPattern("java.lang.Thread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor.Allow(pattern)
+	tester := redactTestData(t, "testdata/string/heapdump.hprof", redactor)
+	defer tester.Close()
+
+	s := tester.QueryString("SELECT toString(o.stringEntry) FROM Object1 o")
+	if s == "abcdefghijklmnopqrstuvwxyz" || len([]rune(s)) != 26 {
+		t.Errorf("The string should be hashed with the same length. But %q", s)
+	}
+	if s := tester.QueryString("SELECT toString(t.name) FROM java.lang.Thread t WHERE toString(t.name) = 'main'"); s != "main" {
+		t.Errorf("The name of the allowed Thread should be kept. But %q", s)
+	}
+}
+
+func TestRedactKeepsStructure(t *testing.T) {
+	tester := redactTestData(t, "testdata/object/heapdump.hprof", NewRedactor(RedactMode_HASH, nil))
+	defer tester.Close()
+
+	tester.AssertSize("Object1", 66)
+	tester.AssertSize("Object2", 42)
+}
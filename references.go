@@ -0,0 +1,130 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"strconv"
+)
+
+type ReferenceKind int
+
+const (
+	ReferenceKind_INSTANCE_FIELD ReferenceKind = iota
+	ReferenceKind_STATIC_FIELD
+	ReferenceKind_ARRAY_ELEMENT
+	ReferenceKind_SUPER_CLASS
+)
+
+// Reference is an outgoing reference from an object.
+type Reference struct {
+	Kind     ReferenceKind
+	NameId   uint64 // field name ID. Only for the fields.
+	Index    int    // element index. Only for the array elements.
+	ObjectId uint64 // referenced object ID. Never be 0.
+	// Strength is not strong for the referent of the subclasses of java/lang/ref/Reference, e.g. WeakReference.
+	Strength ReferenceStrength
+}
+
+// ForEachReference calls fn for each non-null reference from the object.
+// It follows the same edges as RootScanner: instance fields(including the super classes' fields),
+// static fields, the super class and the elements of the object arrays. The referents of the references
+// like WeakReference are included with their Strength, though RootScanner doesn't follow them.
+func (h HProf) ForEachReference(objectId uint64, fn func(ref *Reference) error) error {
+	instanceDump := h.objectId2instanceDump[objectId]
+	if instanceDump != nil {
+		classDump, err := h.GetClassDumpByClassObjectId(instanceDump.ClassObjectId)
+		if err != nil {
+			return err
+		}
+		values := instanceDump.GetValues()
+		idx := 0
+		for classDump != nil {
+			for _, field := range classDump.InstanceFields {
+				if field.Type == hprofdata.HProfValueType_OBJECT {
+					childObjectId := h.readObjectId(values[idx:])
+					if childObjectId != 0 {
+						err := fn(&Reference{
+							Kind:     ReferenceKind_INSTANCE_FIELD,
+							NameId:   field.NameId,
+							ObjectId: childObjectId,
+							Strength: h.referentStrength(instanceDump, classDump.ClassObjectId, field.NameId),
+						})
+						if err != nil {
+							return err
+						}
+					}
+					idx += h.identifierSize
+				} else {
+					idx += parser.ValueSize[field.Type]
+				}
+			}
+			classDump, err = h.GetClassDumpByClassObjectId(classDump.SuperClassObjectId)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	objectArrayDump := h.arrayObjectId2objectArrayDump[objectId]
+	if objectArrayDump != nil {
+		for i, childObjectId := range objectArrayDump.ElementObjectIds {
+			if childObjectId != 0 {
+				err := fn(&Reference{
+					Kind:     ReferenceKind_ARRAY_ELEMENT,
+					Index:    i,
+					ObjectId: childObjectId,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if _, ok := h.arrayObjectId2primitiveArrayDump[objectId]; ok {
+		return nil
+	}
+
+	classDump, err := h.GetClassDumpByClassObjectId(objectId)
+	if err != nil {
+		return err
+	}
+	if classDump != nil {
+		for _, field := range classDump.StaticFields {
+			if field.Type == hprofdata.HProfValueType_OBJECT && field.Value != 0 {
+				err := fn(&Reference{
+					Kind:     ReferenceKind_STATIC_FIELD,
+					NameId:   field.NameId,
+					ObjectId: field.Value,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if classDump.SuperClassObjectId != 0 {
+			return fn(&Reference{
+				Kind:     ReferenceKind_SUPER_CLASS,
+				ObjectId: classDump.SuperClassObjectId,
+			})
+		}
+	}
+	return nil
+}
+
+// GetReferenceName returns the human readable name of the reference, e.g. "next", "[3]", "<super>".
+func (h HProf) GetReferenceName(ref *Reference) string {
+	switch ref.Kind {
+	case ReferenceKind_INSTANCE_FIELD:
+		return h.GetFieldName(ref.NameId)
+	case ReferenceKind_STATIC_FIELD:
+		return "static " + h.GetFieldName(ref.NameId)
+	case ReferenceKind_ARRAY_ELEMENT:
+		return "[" + strconv.Itoa(ref.Index) + "]"
+	case ReferenceKind_SUPER_CLASS:
+		return "<super>"
+	}
+	return "<unknown>"
+}
@@ -0,0 +1,83 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/hprofdata"
+	"sort"
+)
+
+// Referrers is the index of the incoming references.
+type Referrers struct {
+	referrers map[uint64][]uint64 // object ID -> referrer object IDs
+}
+
+// IncomingReference is a reference to the object.
+type IncomingReference struct {
+	ReferrerObjectId uint64
+	// Reference is the name of the reference in the referrer, e.g. "next", "[3]".
+	Reference string
+}
+
+// BuildReferrers scans all the objects in the heap dump and builds the index of the incoming references.
+func (a HeapDumpAnalyzer) BuildReferrers() (*Referrers, error) {
+	m := new(Referrers)
+	m.referrers = make(map[uint64][]uint64)
+
+	add := func(referrerObjectId uint64) error {
+		return a.hprof.ForEachReference(referrerObjectId, func(ref *Reference) error {
+			referrers := m.referrers[ref.ObjectId]
+			// an object can refer the same object from the multiple fields.
+			if len(referrers) == 0 || referrers[len(referrers)-1] != referrerObjectId {
+				m.referrers[ref.ObjectId] = append(referrers, referrerObjectId)
+			}
+			return nil
+		})
+	}
+	for objectId := range a.hprof.objectId2instanceDump {
+		if err := add(objectId); err != nil {
+			return nil, err
+		}
+	}
+	for objectId := range a.hprof.arrayObjectId2objectArrayDump {
+		if err := add(objectId); err != nil {
+			return nil, err
+		}
+	}
+	err := a.hprof.ForEachClassDump(func(classDump *hprofdata.HProfClassDump) error {
+		return add(classDump.ClassObjectId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, referrers := range m.referrers {
+		sort.Slice(referrers, func(i, j int) bool {
+			return referrers[i] < referrers[j]
+		})
+	}
+	return m, nil
+}
+
+// Get returns the object IDs which refer the object, in ascending order.
+func (r *Referrers) Get(objectId uint64) []uint64 {
+	return r.referrers[objectId]
+}
+
+// GetIncomingReferences returns the references to the object, with the names of the references.
+func (a HeapDumpAnalyzer) GetIncomingReferences(referrers *Referrers, objectId uint64) ([]*IncomingReference, error) {
+	var result []*IncomingReference
+	for _, referrerObjectId := range referrers.Get(objectId) {
+		err := a.hprof.ForEachReference(referrerObjectId, func(ref *Reference) error {
+			if ref.ObjectId == objectId {
+				result = append(result, &IncomingReference{
+					ReferrerObjectId: referrerObjectId,
+					Reference:        a.hprof.GetReferenceName(ref),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
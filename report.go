@@ -0,0 +1,132 @@
+package heapdump
+
+import (
+	"html/template"
+	"io"
+	"time"
+)
+
+// DominatorEntry is an object in the dominator tree.
+type DominatorEntry struct {
+	ObjectId     uint64 `json:"id"`
+	ClassName    string `json:"className"`
+	ShallowSize  uint64 `json:"shallowSize"`
+	RetainedSize uint64 `json:"retainedSize"`
+}
+
+// Report is the summary of the heap dump.
+type Report struct {
+	GeneratedAt       time.Time
+	ObjectCount       int
+	TotalShallowSize  uint64
+	MissingReferences int
+	Histogram         []*HistogramEntry
+	// Ranking is ordered by the retained size descending.
+	Ranking    []*ClassRetainedSize
+	Dominators []*DominatorEntry
+}
+
+// GetDominatorEntries returns the objects immediately dominated by the object, ordered by the retained size.
+// Pass 0 to get the biggest objects dominated by the virtual root.
+func (a HeapDumpAnalyzer) GetDominatorEntries(tree *DominatorTree, objectId uint64, limit int) ([]*DominatorEntry, error) {
+	var result []*DominatorEntry
+	for _, child := range tree.Children(objectId) {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		name, err := a.hprof.GetObjectClassName(child)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &DominatorEntry{
+			ObjectId:     child,
+			ClassName:    name,
+			ShallowSize:  tree.ShallowSize(child),
+			RetainedSize: tree.RetainedSize(child),
+		})
+	}
+	return result, nil
+}
+
+// NewReport creates the report. Each table has `limit` rows at most.
+func (a HeapDumpAnalyzer) NewReport(rootScanner *RootScanner, limit int) (*Report, error) {
+	report := &Report{
+		GeneratedAt:       time.Now(),
+		MissingReferences: len(rootScanner.MissingReferences()),
+	}
+
+	histogram, err := a.Histogram()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range histogram {
+		report.ObjectCount += entry.Count
+		report.TotalShallowSize += entry.ShallowSize
+	}
+	if len(histogram) > limit {
+		histogram = histogram[:limit]
+	}
+	report.Histogram = histogram
+
+	ranking, err := a.InclusiveRanking(rootScanner)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(ranking) - 1; i >= 0 && len(report.Ranking) < limit; i-- {
+		report.Ranking = append(report.Ranking, ranking[i])
+	}
+
+	tree, err := a.BuildDominatorTree()
+	if err != nil {
+		return nil, err
+	}
+	report.Dominators, err = a.GetDominatorEntries(tree, 0, limit)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Heap dump report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; }
+td.num { text-align: right; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Heap dump report</h1>
+<p>Generated at {{.GeneratedAt.Format "2006-01-02 15:04:05"}}.
+{{.ObjectCount}} objects, {{.TotalShallowSize}} bytes.
+{{if .MissingReferences}}{{.MissingReferences}} references to missing objects are excluded.{{end}}</p>
+
+<h2>Retained size by class</h2>
+<table>
+<tr><th>Class</th><th>Count</th><th>Shallow size</th><th>Retained size</th></tr>
+{{range .Ranking}}<tr><td>{{.Class.Name}}</td><td class="num">{{.Class.InstanceCount}}</td><td class="num">{{.ShallowSize}}</td><td class="num">{{.RetainedSize}}</td></tr>
+{{end}}</table>
+
+<h2>Biggest objects</h2>
+<table>
+<tr><th>Object ID</th><th>Class</th><th>Shallow size</th><th>Retained size</th></tr>
+{{range .Dominators}}<tr><td class="num">{{.ObjectId}}</td><td>{{.ClassName}}</td><td class="num">{{.ShallowSize}}</td><td class="num">{{.RetainedSize}}</td></tr>
+{{end}}</table>
+
+<h2>Class histogram</h2>
+<table>
+<tr><th>Class</th><th>Count</th><th>Shallow size</th></tr>
+{{range .Histogram}}<tr><td>{{.Name}}</td><td class="num">{{.Count}}</td><td class="num">{{.ShallowSize}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a standalone HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
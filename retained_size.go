@@ -1,10 +1,8 @@
-package main
+package heapdump
 
 import (
-	"encoding/binary"
 	"github.com/google/hprof-parser/hprofdata"
 	"github.com/google/hprof-parser/parser"
-	"log"
 )
 
 type RetainedSizeCalculator struct {
@@ -81,13 +79,9 @@ func (a RetainedSizeCalculator) retainedSizeInstance(hprof *HProf, objectId uint
 		return a.calcClassSize(hprof, classDump, seen, rootScanner), nil
 	}
 
-	log.Fatalf(
-		"[ERROR] Unknown instance: objectId=%v instanceDump=%v primArray=%v objArray=%v",
-		objectId,
-		instanceDump,
-		hprof.arrayObjectId2primitiveArrayDump[objectId],
-		hprof.arrayObjectId2objectArrayDump[objectId])
-	return 0, nil // should not reach here
+	// The object isn't in the heap dump. RootScanner records it as a missing object.
+	a.logger.Debug("Missing object: objectId=%v", objectId)
+	return 0, nil
 }
 
 func (a RetainedSizeCalculator) getSizeCache(objectId uint64) (uint64, bool) {
@@ -167,9 +161,7 @@ func (a RetainedSizeCalculator) scanInstance(
 
 	for _, field := range fields {
 		if field.Type == hprofdata.HProfValueType_OBJECT {
-			// TODO 32bit support(特にやる気なし)
-			objectIdBytes := values[idx : idx+8]
-			childObjectId := binary.BigEndian.Uint64(objectIdBytes)
+			childObjectId := hprof.readObjectId(values[idx:])
 			if childObjectId == 0 {
 				// the field contains null
 				a.logger.Trace("object field: className=%v oid=NULL",
@@ -193,7 +185,7 @@ func (a RetainedSizeCalculator) scanInstance(
 						childObjectId)
 				}
 			}
-			idx += 8
+			idx += hprof.identifierSize
 		} else {
 			a.logger.Trace("Primitive Field")
 			idx += parser.ValueSize[field.Type]
@@ -209,8 +201,7 @@ func (a RetainedSizeCalculator) calcObjectArraySize(hprof *HProf, dump *hprofdat
 
 	objectIds := dump.GetElementObjectIds()
 	// TODO 24 バイトのヘッダがついてるっぽい。length 用だけなら 8 バイトで良さそうだが、なぜか？
-	// 8 = 64bit
-	r := uint64(24 + 8*len(dump.GetElementObjectIds()))
+	r := uint64(24 + hprof.identifierSize*len(dump.GetElementObjectIds()))
 	var sizeResult []uint64
 	for _, objectId := range objectIds {
 		if objectId != 0 {
@@ -231,11 +222,11 @@ func (a RetainedSizeCalculator) calcObjectArraySize(hprof *HProf, dump *hprofdat
 }
 
 func (a RetainedSizeCalculator) calcPrimitiveArraySize(dump *hprofdata.HProfPrimitiveArrayDump) uint64 {
-	size := parser.ValueSize[dump.ElementType]
 	// https://weekly-geekly.github.io/articles/447848/index.html
 	// On a 64-bit jvm, the object header consists of 16 bytes. Arrays are additionally 4 bytes.
 	// http://btoddb-java-sizing.blogspot.com/
-	retval := uint64(16 + 4 + 4 + (len(dump.Values) * size))
+	// the values are the raw bytes of the elements.
+	retval := uint64(16 + 4 + 4 + len(dump.Values))
 	a.logger.Debug("primitive array: %s %v",
 		dump.ElementType,
 		retval)
@@ -250,7 +241,7 @@ func (a RetainedSizeCalculator) calcClassSize(hprof *HProf, dump *hprofdata.HPro
 	for _, field := range dump.StaticFields {
 		if field.Type == hprofdata.HProfValueType_OBJECT {
 			childObjectId := field.Value
-			totalSize += 8
+			totalSize += uint64(hprof.identifierSize)
 			if childObjectId != 0 {
 				if rootScanner.IsRetained(dump.ClassObjectId, childObjectId) {
 					size, _ := a.retainedSizeInstance(hprof, childObjectId, seen, rootScanner)
@@ -0,0 +1,185 @@
+package heapdump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// PrimitiveArrayGroupName is the group of the primitive arrays, which have no package.
+	PrimitiveArrayGroupName = "<primitive>"
+	// DefaultPackageGroupName is the group of the classes in the default package.
+	DefaultPackageGroupName = "<default>"
+	// UnownedGroupName is the group of the classes which match no rule of the owner mapping.
+	UnownedGroupName = "<unowned>"
+)
+
+// GroupRetainedSize is the retained size of a group of the classes, e.g. a package or a team.
+type GroupRetainedSize struct {
+	Name string
+	// ClassCount is the number of the classes which have any reachable objects.
+	ClassCount    int
+	InstanceCount int
+	ShallowSize   uint64
+	// RetainedSize is retained by the objects of the group. See DominatorTree.WalkDominated.
+	// The objects of the other groups are included, so the sum of the groups can exceed the heap size.
+	RetainedSize uint64
+}
+
+// Grouper returns the group name of the class.
+type Grouper func(className string) string
+
+// ElementClassName returns the JVM internal name of the class, or of the element class for the object arrays.
+// e.g. "[Ljava/util/HashMap$Node;" to "java/util/HashMap$Node". It returns "" for the primitive arrays.
+// The "class " prefix of the class objects is removed.
+func ElementClassName(className string) string {
+	name := strings.TrimPrefix(className, "class ")
+	if strings.HasSuffix(name, "[]") {
+		return ""
+	}
+	if !strings.HasPrefix(name, "[") {
+		return name
+	}
+	name = strings.TrimLeft(name, "[")
+	if strings.HasPrefix(name, "L") && strings.HasSuffix(name, ";") {
+		return name[1 : len(name)-1]
+	}
+	return ""
+}
+
+// PackageGrouper groups the classes by the first `depth` elements of the Java package name, e.g. "com.example.billing".
+// All the elements are used if depth is 0. The object arrays belong to the package of the element class.
+func PackageGrouper(depth int) Grouper {
+	return func(className string) string {
+		name := ElementClassName(className)
+		if name == "" {
+			return PrimitiveArrayGroupName
+		}
+		elements := strings.Split(name, "/")
+		elements = elements[:len(elements)-1]
+		if len(elements) == 0 {
+			return DefaultPackageGroupName
+		}
+		if depth > 0 && len(elements) > depth {
+			elements = elements[:depth]
+		}
+		return strings.Join(elements, ".")
+	}
+}
+
+type ownerRule struct {
+	pattern *ClassPattern
+	owner   string
+}
+
+// OwnerMapping maps the classes to the owning teams or components.
+type OwnerMapping struct {
+	rules []*ownerRule
+}
+
+// ParseOwnerMapping parses the mapping from the class patterns to the owners. Each line is a class pattern,
+// as same as `-target`, and the owner name separated by the spaces. e.g.
+//
+//	# pattern               owner
+//	com.example.billing.**  billing-team
+//	/^com\.example\.(cart|checkout)\./  shop-team
+//
+// The rules are matched in order, and the first matched one wins. Empty lines and the lines starting with "#" are ignored.
+func ParseOwnerMapping(r io.Reader) (*OwnerMapping, error) {
+	m := new(OwnerMapping)
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: the owner is missing: %v", lineNumber, line)
+		}
+		pattern, err := NewClassPattern(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		m.rules = append(m.rules, &ownerRule{pattern: pattern, owner: strings.Join(fields[1:], " ")})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LoadOwnerMapping reads the owner mapping file. See ParseOwnerMapping for the format.
+func LoadOwnerMapping(path string) (*OwnerMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseOwnerMapping(f)
+}
+
+// Owner returns the owner of the class, or UnownedGroupName if no rule matches.
+// The object arrays belong to the owner of the element class.
+func (m *OwnerMapping) Owner(className string) string {
+	name := ElementClassName(className)
+	if name != "" {
+		for _, rule := range m.rules {
+			if rule.pattern.Match(name) {
+				return rule.owner
+			}
+		}
+	}
+	return UnownedGroupName
+}
+
+// RetainedSizeByGroup rolls up the reachable objects by the group of their classes, ordered by the retained size.
+// The retained sizes are summed up by DominatorTree.WalkDominated.
+func (a HeapDumpAnalyzer) RetainedSizeByGroup(tree *DominatorTree, grouper Grouper) ([]*GroupRetainedSize, error) {
+	groups := make(map[string]*GroupRetainedSize)
+	classes := make(map[string]map[string]bool)
+	err := tree.WalkDominated(tree.Children(0), func(objectId uint64) ([]interface{}, error) {
+		className, err := a.hprof.GetObjectClassName(objectId)
+		if err != nil {
+			return nil, err
+		}
+		name := grouper(className)
+		group, ok := groups[name]
+		if !ok {
+			group = &GroupRetainedSize{Name: name}
+			groups[name] = group
+			classes[name] = make(map[string]bool)
+		}
+		classes[name][strings.TrimPrefix(className, "class ")] = true
+		return []interface{}{group}, nil
+	}, func(objectId uint64, key interface{}, outermost bool) {
+		group := key.(*GroupRetainedSize)
+		group.InstanceCount++
+		group.ShallowSize += tree.ShallowSize(objectId)
+		if outermost {
+			group.RetainedSize += tree.RetainedSize(objectId)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*GroupRetainedSize
+	for name, group := range groups {
+		group.ClassCount = len(classes[name])
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].RetainedSize != result[j].RetainedSize {
+			return result[i].RetainedSize > result[j].RetainedSize
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
@@ -0,0 +1,90 @@
+package heapdump
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackageGrouper(t *testing.T) {
+	for _, test := range []struct {
+		depth     int
+		className string
+		expected  string
+	}{
+		{2, "com/example/billing/Invoice", "com.example"},
+		{3, "com/example/billing/Invoice", "com.example.billing"},
+		{0, "com/example/billing/Invoice$Line", "com.example.billing"},
+		{5, "com/example/billing/Invoice", "com.example.billing"},
+		{2, "[[Lcom/example/billing/Invoice;", "com.example"},
+		{2, "class com/example/billing/Invoice", "com.example"},
+		{2, "byte[]", PrimitiveArrayGroupName},
+		{2, "[[B", PrimitiveArrayGroupName},
+		{2, "Object1", DefaultPackageGroupName},
+	} {
+		if group := PackageGrouper(test.depth)(test.className); group != test.expected {
+			t.Errorf("%v at depth %v should be %v. But %v", test.className, test.depth, test.expected, group)
+		}
+	}
+}
+
+func TestOwnerMapping(t *testing.T) {
+	mapping, err := ParseOwnerMapping(strings.NewReader(`
+# pattern owner
+com.example.billing.** billing team
+/^com\.example\.(cart|checkout)\./ shop
+com.example.** platform
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for className, expected := range map[string]string{
+		"com/example/billing/Invoice":      "billing team",
+		"[Lcom/example/cart/Item;":         "shop",
+		"com/example/checkout/Flow$Step":   "shop",
+		"com/example/Main":                 "platform",
+		"java/util/HashMap":                UnownedGroupName,
+		"byte[]":                           UnownedGroupName,
+		"class com/example/billing/Ledger": "billing team",
+	} {
+		if owner := mapping.Owner(className); owner != expected {
+			t.Errorf("%v should be owned by %v. But %v", className, expected, owner)
+		}
+	}
+
+	for _, invalid := range []string{"com.example.**", "/(/ team"} {
+		if _, err := ParseOwnerMapping(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q should be an error", invalid)
+		}
+	}
+}
+
+func TestRetainedSizeByGroup(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := tester.analyzer.RetainedSizeByGroup(tree, PackageGrouper(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var defaultPackage *GroupRetainedSize
+	for _, group := range groups {
+		if group.Name == DefaultPackageGroupName {
+			defaultPackage = group
+		}
+	}
+	if defaultPackage == nil {
+		t.Fatal("The default package should be found")
+	}
+	// Object1, Object2 and the main class.
+	if defaultPackage.ClassCount != 3 {
+		t.Errorf("The default package should have 3 classes. But %v", defaultPackage.ClassCount)
+	}
+	// Object1 retains Object2.
+	if defaultPackage.RetainedSize < 66 {
+		t.Errorf("The default package should retain Object1. But %v", defaultPackage.RetainedSize)
+	}
+}
@@ -1,20 +1,21 @@
-package main
+package heapdump
 
 import (
-	"encoding/binary"
 	"github.com/google/hprof-parser/hprofdata"
 	"github.com/google/hprof-parser/parser"
-	"log"
+	"strconv"
 )
 
 type RootScanner struct {
 	parents map[uint64]uint64 // objectId -> count
+	missing *MissingObjects
 	logger  *Logger
 }
 
 func NewRootScanner(logger *Logger) *RootScanner {
 	m := new(RootScanner)
 	m.parents = make(map[uint64]uint64)
+	m.missing = NewMissingObjects()
 	m.logger = logger
 	return m
 }
@@ -25,7 +26,9 @@ func (r RootScanner) ScanRoot(a *HeapDumpAnalyzer, rootObjectIds []uint64) error
 	for _, rootObjectId := range rootObjectIds {
 		r.logger.Debug("rootObjectId=%v", rootObjectId)
 		err := r.scan(rootObjectId, a, seen)
-		if err != nil {
+		if err == errObjectNotFound {
+			r.missing.Add(0, "<root>", rootObjectId)
+		} else if err != nil {
 			return err
 		}
 	}
@@ -37,6 +40,9 @@ func (r RootScanner) scan(objectId uint64, a *HeapDumpAnalyzer, seen *Seen) erro
 	if objectId == 0 {
 		return nil // NULL
 	}
+	if r.missing.HasKey(objectId) {
+		return errObjectNotFound
+	}
 	if seen.HasKey(objectId) {
 		return nil
 	}
@@ -60,16 +66,21 @@ func (r RootScanner) scan(objectId uint64, a *HeapDumpAnalyzer, seen *Seen) erro
 		for {
 			for _, instanceField := range classDump.InstanceFields {
 				if instanceField.Type == hprofdata.HProfValueType_OBJECT {
-					// TODO 32bit support
 					r.logger.Trace("instance field = %v", instanceDump.ObjectId)
-					objectIdBytes := values[idx : idx+8]
-					childObjectId := binary.BigEndian.Uint64(objectIdBytes)
+					childObjectId := a.hprof.readObjectId(values[idx:])
+					if a.hprof.referentStrength(instanceDump, classDump.ClassObjectId, instanceField.NameId) != ReferenceStrength_STRONG {
+						// the referent of the references like WeakReference isn't retained.
+						idx += a.hprof.identifierSize
+						continue
+					}
 					r.RegisterParent(objectId, childObjectId)
 					err := r.scan(childObjectId, a, seen)
-					if err != nil {
+					if err == errObjectNotFound {
+						r.missing.Add(objectId, a.hprof.GetFieldName(instanceField.NameId), childObjectId)
+					} else if err != nil {
 						return err
 					}
-					idx += 8
+					idx += a.hprof.identifierSize
 				} else {
 					idx += parser.ValueSize[instanceField.Type]
 				}
@@ -99,10 +110,12 @@ func (r RootScanner) scan(objectId uint64, a *HeapDumpAnalyzer, seen *Seen) erro
 				childObjectId := field.GetValue()
 				r.RegisterParent(objectId, childObjectId)
 				err := r.scan(childObjectId, a, seen)
-				if err != nil {
+				if err == errObjectNotFound {
+					r.missing.Add(objectId, a.hprof.GetFieldName(field.NameId), childObjectId)
+				} else if err != nil {
 					return err
 				}
-				idx += 8
+				idx += a.hprof.identifierSize
 			} else {
 				idx += parser.ValueSize[field.Type]
 			}
@@ -127,10 +140,12 @@ func (r RootScanner) scan(objectId uint64, a *HeapDumpAnalyzer, seen *Seen) erro
 	objectArrayDump := a.hprof.arrayObjectId2objectArrayDump[objectId]
 	if objectArrayDump != nil {
 		r.logger.Debug("object array = %v", objectId)
-		for _, childObjectId := range objectArrayDump.ElementObjectIds {
+		for i, childObjectId := range objectArrayDump.ElementObjectIds {
 			r.RegisterParent(objectId, childObjectId)
 			err := r.scan(childObjectId, a, seen)
-			if err != nil {
+			if err == errObjectNotFound {
+				r.missing.Add(objectId, "["+strconv.Itoa(i)+"]", childObjectId)
+			} else if err != nil {
 				return err
 			}
 		}
@@ -144,12 +159,9 @@ func (r RootScanner) scan(objectId uint64, a *HeapDumpAnalyzer, seen *Seen) erro
 		return nil
 	}
 
-	log.Fatalf("SHOULD NOT REACH HERE: %v pa=%v oa=%v id=%v",
-		objectId,
-		a.hprof.arrayObjectId2primitiveArrayDump[objectId],
-		a.hprof.arrayObjectId2objectArrayDump[objectId],
-		a.hprof.objectId2instanceDump[objectId])
-	return nil
+	r.logger.Debug("missing object = %v", objectId)
+	seen.Remove(objectId)
+	return errObjectNotFound
 }
 
 func (r RootScanner) RegisterParent(parentObjectId uint64, childObjectId uint64) {
@@ -196,5 +208,11 @@ func (r RootScanner) ScanAll(analyzer *HeapDumpAnalyzer) error {
 			return err
 		}
 	}
+	r.missing.LogSummary(r.logger)
 	return nil
 }
+
+// MissingReferences returns the references to the objects which aren't in the heap dump.
+func (r RootScanner) MissingReferences() []*MissingReference {
+	return r.missing.References()
+}
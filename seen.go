@@ -1,4 +1,4 @@
-package main
+package heapdump
 
 type Seen struct {
 	seen map[uint64]bool
@@ -0,0 +1,537 @@
+package heapdump
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const serverPageSize = 100
+
+// Server is the web UI to browse the heap dump, and the JSON API under /api/. The dominator tree and the incoming
+// references are computed once in NewServer, so that many people can explore the heap dump without re-running the analysis.
+type Server struct {
+	logger    *Logger
+	analyzer  *HeapDumpAnalyzer
+	tree      *DominatorTree
+	referrers *Referrers
+	histogram []*HistogramEntry
+	mux       *http.ServeMux
+}
+
+func NewServer(logger *Logger, analyzer *HeapDumpAnalyzer) (*Server, error) {
+	m := new(Server)
+	m.logger = logger
+	m.analyzer = analyzer
+
+	start := time.Now()
+	histogram, err := analyzer.Histogram()
+	if err != nil {
+		return nil, err
+	}
+	m.histogram = histogram
+	m.tree, err = analyzer.BuildDominatorTree()
+	if err != nil {
+		return nil, err
+	}
+	m.referrers, err = analyzer.BuildReferrers()
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Prepared the server in %s.", time.Since(start))
+
+	m.mux = http.NewServeMux()
+	m.mux.HandleFunc("/", m.handleHistogram)
+	m.mux.HandleFunc("/class", m.handleClass)
+	m.mux.HandleFunc("/object", m.handleObject)
+	m.mux.HandleFunc("/path", m.handlePath)
+	m.mux.HandleFunc("/dominators", m.handleDominators)
+	m.mux.Handle("/api/", http.StripPrefix("/api", http.HandlerFunc(m.handleAPI)))
+	return m, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// objectLink is a link to the object page.
+type objectLink struct {
+	ObjectId uint64
+	Label    string
+}
+
+type fieldView struct {
+	Name  string
+	Type  string
+	Value string
+	Link  *objectLink // for the non-null object references only.
+}
+
+type pager struct {
+	Page     int
+	HasPrev  bool
+	HasNext  bool
+	PrevPage int
+	NextPage int
+	Total    int
+}
+
+func newPager(r *http.Request, total int) *pager {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 0 || page*serverPageSize >= total {
+		page = 0
+	}
+	return &pager{
+		Page:     page,
+		HasPrev:  page > 0,
+		HasNext:  (page+1)*serverPageSize < total,
+		PrevPage: page - 1,
+		NextPage: page + 1,
+		Total:    total,
+	}
+}
+
+func (p *pager) bounds() (int, int) {
+	start := p.Page * serverPageSize
+	end := start + serverPageSize
+	if end > p.Total {
+		end = p.Total
+	}
+	return start, end
+}
+
+func (s *Server) link(objectId uint64) (*objectLink, error) {
+	name, err := s.analyzer.GetObjectClassName(objectId)
+	if err == errObjectNotFound {
+		return &objectLink{ObjectId: objectId, Label: fmt.Sprintf("<missing>@%d", objectId)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &objectLink{ObjectId: objectId, Label: fmt.Sprintf("%s@%d", name, objectId)}, nil
+}
+
+func (s *Server) fieldView(field *Field) (*fieldView, error) {
+	view := &fieldView{Name: field.Name, Type: field.Type.String(), Value: field.String()}
+	if field.Type == hprofdata.HProfValueType_OBJECT && field.Value != 0 {
+		link, err := s.link(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		view.Link = link
+	}
+	return view, nil
+}
+
+func (s *Server) render(w http.ResponseWriter, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := serverTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		s.error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) error(w http.ResponseWriter, err error) {
+	s.logger.Error("%v", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func parseObjectIdParam(r *http.Request) (uint64, bool) {
+	objectId, err := strconv.ParseUint(r.URL.Query().Get("id"), 0, 64)
+	return objectId, err == nil
+}
+
+func (s *Server) handleHistogram(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	entries := append([]*HistogramEntry{}, s.histogram...)
+	sortKey := r.URL.Query().Get("sort")
+	switch sortKey {
+	case "count":
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Count > entries[j].Count })
+	case "name":
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	default:
+		sortKey = "size"
+	}
+	totalSize := uint64(0)
+	totalCount := 0
+	for _, entry := range entries {
+		totalSize += entry.ShallowSize
+		totalCount += entry.Count
+	}
+	s.render(w, "histogram", map[string]interface{}{
+		"Entries":       entries,
+		"Sort":          sortKey,
+		"TotalCount":    totalCount,
+		"TotalSize":     totalSize,
+		"ReachableSize": s.tree.RetainedSize(0),
+	})
+}
+
+type instanceView struct {
+	ObjectId     uint64 `json:"id"`
+	ShallowSize  uint64 `json:"shallowSize"`
+	RetainedSize uint64 `json:"retainedSize"`
+}
+
+func (s *Server) handleClass(w http.ResponseWriter, r *http.Request) {
+	classObjectId, ok := parseObjectIdParam(r)
+	if !ok {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	class, err := s.analyzer.GetClass(classObjectId)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	if class == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	instances, err := s.instances(classObjectId)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].RetainedSize != instances[j].RetainedSize {
+			return instances[i].RetainedSize > instances[j].RetainedSize
+		}
+		return instances[i].ObjectId < instances[j].ObjectId
+	})
+	p := newPager(r, len(instances))
+	start, end := p.bounds()
+
+	superClass, err := s.linkOrNil(class.SuperClassObjectId)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	classLoader, err := s.linkOrNil(class.ClassLoaderObjectId)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	s.render(w, "class", map[string]interface{}{
+		"Class":       class,
+		"SuperClass":  superClass,
+		"ClassLoader": classLoader,
+		"Instances":   instances[start:end],
+		"Pager":       p,
+	})
+}
+
+// instances returns the instances of the class, in no particular order.
+func (s *Server) instances(classObjectId uint64) ([]*instanceView, error) {
+	var instances []*instanceView
+	addInstance := func(objectId uint64) error {
+		size, err := s.analyzer.GetShallowSize(objectId)
+		if err != nil {
+			return err
+		}
+		instances = append(instances, &instanceView{
+			ObjectId:     objectId,
+			ShallowSize:  uint64(size),
+			RetainedSize: s.tree.RetainedSize(objectId),
+		})
+		return nil
+	}
+	if err := s.analyzer.ForEachInstance(classObjectId, addInstance); err != nil {
+		return nil, err
+	}
+	// the instances of the array classes are the object arrays.
+	for objectId, dump := range s.analyzer.hprof.arrayObjectId2objectArrayDump {
+		if dump.ArrayClassObjectId == classObjectId {
+			if err := addInstance(objectId); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return instances, nil
+}
+
+func (s *Server) linkOrNil(objectId uint64) (*objectLink, error) {
+	if objectId == 0 {
+		return nil, nil
+	}
+	return s.link(objectId)
+}
+
+func (s *Server) handleObject(w http.ResponseWriter, r *http.Request) {
+	objectId, ok := parseObjectIdParam(r)
+	if !ok {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	object, err := s.analyzer.GetObject(objectId)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	if object == nil {
+		http.NotFound(w, r)
+		return
+	}
+	shallowSize, err := s.analyzer.GetShallowSize(objectId)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+
+	var fields []*fieldView
+	for _, field := range object.Fields {
+		view, err := s.fieldView(field)
+		if err != nil {
+			s.error(w, err)
+			return
+		}
+		fields = append(fields, view)
+	}
+
+	p := newPager(r, object.Length)
+	start, end := p.bounds()
+	var elements []*fieldView
+	for i := start; i < end; i++ {
+		var element *Field
+		if object.Kind == ObjectKind_OBJECT_ARRAY {
+			element = &Field{Name: "[" + strconv.Itoa(i) + "]", Type: object.ElementType, Value: object.ElementObjectIds[i]}
+		} else {
+			element = object.Element(i)
+		}
+		view, err := s.fieldView(element)
+		if err != nil {
+			s.error(w, err)
+			return
+		}
+		elements = append(elements, view)
+	}
+
+	references, err := s.analyzer.GetIncomingReferences(s.referrers, objectId)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	var referrers []map[string]interface{}
+	for _, reference := range references {
+		link, err := s.link(reference.ReferrerObjectId)
+		if err != nil {
+			s.error(w, err)
+			return
+		}
+		referrers = append(referrers, map[string]interface{}{
+			"Link":      link,
+			"Reference": reference.Reference,
+		})
+	}
+
+	var stringValue *string
+	if object.Kind == ObjectKind_INSTANCE && object.ClassName == javaLangString {
+		value, err := s.analyzer.GetStringValue(objectId)
+		if err != nil {
+			s.error(w, err)
+			return
+		}
+		stringValue = &value
+	}
+
+	var dominator *objectLink
+	if idom, ok := s.tree.ImmediateDominator(objectId); ok && idom != 0 {
+		if dominator, err = s.link(idom); err != nil {
+			s.error(w, err)
+			return
+		}
+	}
+	s.render(w, "object", map[string]interface{}{
+		"Object":       object,
+		"IsArray":      object.Kind == ObjectKind_OBJECT_ARRAY || object.Kind == ObjectKind_PRIMITIVE_ARRAY,
+		"IsInstance":   object.Kind == ObjectKind_INSTANCE,
+		"ShallowSize":  shallowSize,
+		"Reachable":    s.tree.Contains(objectId),
+		"RetainedSize": s.tree.RetainedSize(objectId),
+		"Dominator":    dominator,
+		"RootKinds":    s.analyzer.hprof.GetRootKinds(objectId),
+		"StringValue":  stringValue,
+		"Fields":       fields,
+		"Elements":     elements,
+		"Pager":        p,
+		"Referrers":    referrers,
+	})
+}
+
+func (s *Server) handlePath(w http.ResponseWriter, r *http.Request) {
+	objectId, ok := parseObjectIdParam(r)
+	if !ok {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	path, err := s.analyzer.FindPathFromRoot(objectId)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	s.render(w, "path", map[string]interface{}{
+		"ObjectId": objectId,
+		"Path":     path,
+	})
+}
+
+func (s *Server) handleDominators(w http.ResponseWriter, r *http.Request) {
+	objectId, _ := parseObjectIdParam(r)
+	if objectId != 0 && !s.tree.Contains(objectId) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// the chain of the dominators from the virtual root.
+	var chain []*objectLink
+	for current := objectId; current != 0; {
+		link, err := s.link(current)
+		if err != nil {
+			s.error(w, err)
+			return
+		}
+		chain = append([]*objectLink{link}, chain...)
+		current, _ = s.tree.ImmediateDominator(current)
+	}
+
+	entries, err := s.analyzer.GetDominatorEntries(s.tree, objectId, 0)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
+	p := newPager(r, len(entries))
+	start, end := p.bounds()
+	s.render(w, "dominators", map[string]interface{}{
+		"ObjectId":     objectId,
+		"Chain":        chain,
+		"RetainedSize": s.tree.RetainedSize(objectId),
+		"Entries":      entries[start:end],
+		"Pager":        p,
+	})
+}
+
+var serverTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"percentage": func(n uint64, total uint64) string {
+		if total == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f%%", Percentage(n, total))
+	},
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}} - heapdump</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; }
+td.num { text-align: right; font-family: monospace; }
+nav a { margin-right: 1em; }
+</style>
+</head>
+<body>
+<nav><a href="/">Class histogram</a><a href="/dominators">Dominator tree</a></nav>
+<h1>{{.}}</h1>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "link"}}<a href="/object?id={{.ObjectId}}">{{.Label}}</a>{{end}}
+
+{{define "histogram"}}{{template "header" "Class histogram"}}
+<p>{{.TotalCount}} objects, {{.TotalSize}} bytes. {{.ReachableSize}} bytes are retained by the GC roots, including the classes.</p>
+<table>
+<tr><th><a href="/?sort=name">Class</a></th><th><a href="/?sort=count">Count</a></th><th><a href="/?sort=size">Shallow size</a></th></tr>
+{{range .Entries}}<tr>
+<td>{{if .ClassObjectId}}<a href="/class?id={{.ClassObjectId}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td>
+<td class="num">{{.Count}}</td><td class="num">{{.ShallowSize}}</td>
+</tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "class"}}{{template "header" .Class.Name}}
+<ul>
+<li>class object: <a href="/object?id={{.Class.ClassObjectId}}">{{.Class.ClassObjectId}}</a></li>
+{{with .SuperClass}}<li>super class: {{template "link" .}}</li>{{end}}
+{{with .ClassLoader}}<li>class loader: {{template "link" .}}</li>{{end}}
+<li>instances: {{.Pager.Total}}</li>
+</ul>
+<h2>Instances</h2>
+<table>
+<tr><th>Object ID</th><th>Shallow size</th><th>Retained size</th></tr>
+{{range .Instances}}<tr><td class="num"><a href="/object?id={{.ObjectId}}">{{.ObjectId}}</a></td><td class="num">{{.ShallowSize}}</td><td class="num">{{.RetainedSize}}</td></tr>
+{{end}}</table>
+{{with .Pager}}{{if .HasPrev}}<a href="/class?id={{$.Class.ClassObjectId}}&amp;page={{.PrevPage}}">prev</a>{{end}}
+{{if .HasNext}}<a href="/class?id={{$.Class.ClassObjectId}}&amp;page={{.NextPage}}">next</a>{{end}}{{end}}
+{{template "footer"}}{{end}}
+
+{{define "object"}}{{template "header" (printf "%s@%d" .Object.ClassName .Object.ObjectId)}}
+<ul>
+<li>kind: {{.Object.Kind}}</li>
+<li>class: {{if .Object.ClassObjectId}}<a href="/class?id={{.Object.ClassObjectId}}">{{.Object.ClassName}}</a>{{else}}{{.Object.ClassName}}{{end}}</li>
+<li>shallow size: {{.ShallowSize}}</li>
+{{if .Reachable}}<li>retained size: {{.RetainedSize}}</li>
+{{with .Dominator}}<li>immediate dominator: {{template "link" .}}</li>{{end}}
+<li><a href="/path?id={{.Object.ObjectId}}">path from the GC roots</a></li>
+<li><a href="/dominators?id={{.Object.ObjectId}}">dominated objects</a></li>
+{{else}}<li>not reachable from the GC roots</li>{{end}}
+{{with .RootKinds}}<li>GC root: {{range $i, $kind := .}}{{if $i}}, {{end}}{{$kind}}{{end}}</li>{{end}}
+{{with .StringValue}}<li>value: <code>{{.}}</code></li>{{end}}
+</ul>
+{{with .Fields}}<h2>{{if $.IsInstance}}Fields{{else}}Static fields{{end}}</h2>
+<table>
+<tr><th>Name</th><th>Type</th><th>Value</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{with .Link}}{{template "link" .}}{{else}}{{.Value}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{if .IsArray}}<h2>Elements (length={{.Object.Length}})</h2>
+<table>
+{{range .Elements}}<tr><td>{{.Name}}</td><td>{{with .Link}}{{template "link" .}}{{else}}{{.Value}}{{end}}</td></tr>
+{{end}}</table>
+{{with .Pager}}{{if .HasPrev}}<a href="/object?id={{$.Object.ObjectId}}&amp;page={{.PrevPage}}">prev</a>{{end}}
+{{if .HasNext}}<a href="/object?id={{$.Object.ObjectId}}&amp;page={{.NextPage}}">next</a>{{end}}{{end}}
+{{end}}
+<h2>Incoming references</h2>
+{{if .Referrers}}<table>
+<tr><th>Referrer</th><th>Reference</th></tr>
+{{range .Referrers}}<tr><td>{{template "link" .Link}}</td><td>{{.Reference}}</td></tr>
+{{end}}</table>{{else}}<p>none</p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "path"}}{{template "header" (printf "Path from the GC roots to %d" .ObjectId)}}
+{{if .Path}}<ol>
+{{range .Path}}<li>{{with .Reference}}{{.}} &rarr; {{end}}<a href="/object?id={{.ObjectId}}">{{.ClassName}}@{{.ObjectId}}</a>
+{{with .RootKinds}}(GC root: {{range $i, $kind := .}}{{if $i}}, {{end}}{{$kind}}{{end}}){{end}}</li>
+{{end}}</ol>{{else}}<p>The object is not reachable from the GC roots.</p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "dominators"}}{{template "header" "Dominator tree"}}
+<p><a href="/dominators">GC roots</a>{{range .Chain}} &gt; <a href="/dominators?id={{.ObjectId}}">{{.Label}}</a>{{end}}</p>
+<p>retained size: {{.RetainedSize}}</p>
+<table>
+<tr><th>Object</th><th>Shallow size</th><th>Retained size</th><th>Percentage</th><th></th></tr>
+{{range .Entries}}<tr>
+<td><a href="/object?id={{.ObjectId}}">{{.ClassName}}@{{.ObjectId}}</a></td>
+<td class="num">{{.ShallowSize}}</td><td class="num">{{.RetainedSize}}</td><td class="num">{{percentage .RetainedSize $.RetainedSize}}</td>
+<td>{{if gt .RetainedSize .ShallowSize}}<a href="/dominators?id={{.ObjectId}}">drill down</a>{{end}}</td>
+</tr>
+{{end}}</table>
+{{with .Pager}}{{if .HasPrev}}<a href="/dominators?id={{$.ObjectId}}&amp;page={{.PrevPage}}">prev</a>{{end}}
+{{if .HasNext}}<a href="/dominators?id={{$.ObjectId}}&amp;page={{.NextPage}}">next</a>{{end}}{{end}}
+{{template "footer"}}{{end}}
+`))
@@ -0,0 +1,68 @@
+package heapdump
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func (a *Tester) NewServer() *Server {
+	server, err := NewServer(a.analyzer.logger, a.analyzer)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return server
+}
+
+func (a *Tester) Get(server http.Handler, url string) (int, string) {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestServerObjectPage(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+	server := tester.NewServer()
+
+	object1 := tester.FindInstance("Object1")
+	object2 := tester.FindInstance("Object2")
+
+	code, body := tester.Get(server, fmt.Sprintf("/object?id=%d", object2))
+	if code != http.StatusOK {
+		t.Fatalf("Unexpected status: %v", code)
+	}
+	// the incoming reference from Object1.o2
+	if !strings.Contains(body, fmt.Sprintf(`<a href="/object?id=%d">Object1@%d</a></td><td>o2</td>`, object1, object1)) {
+		t.Fatalf("The page should have the incoming reference from Object1: %v", body)
+	}
+	if !strings.Contains(body, "retained size: 42") {
+		t.Fatalf("The page should have the retained size: %v", body)
+	}
+}
+
+func TestServerPages(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+	server := tester.NewServer()
+
+	object2 := tester.FindInstance("Object2")
+	for _, url := range []string{
+		"/",
+		"/?sort=count",
+		fmt.Sprintf("/path?id=%d", object2),
+		"/dominators",
+		fmt.Sprintf("/dominators?id=%d", object2),
+	} {
+		if code, _ := tester.Get(server, url); code != http.StatusOK {
+			t.Fatalf("%v: unexpected status: %v", url, code)
+		}
+	}
+	for _, url := range []string{"/object?id=1", "/class?id=1", "/unknown"} {
+		if code, _ := tester.Get(server, url); code != http.StatusNotFound {
+			t.Fatalf("%v: should be not found. But %v", url, code)
+		}
+	}
+}
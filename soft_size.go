@@ -1,4 +1,4 @@
-package main
+package heapdump
 
 import (
 	"github.com/google/hprof-parser/hprofdata"
@@ -41,7 +41,7 @@ func (s SoftSizeCalculator) CalcSoftSizeByObjectId(hprof *HProf, objectId uint64
 		idx := 0
 		for _, field := range classDump.StaticFields {
 			if field.Type == hprofdata.HProfValueType_OBJECT {
-				idx += 8
+				idx += hprof.identifierSize
 			} else {
 				idx += parser.ValueSize[field.Type]
 			}
@@ -52,19 +52,17 @@ func (s SoftSizeCalculator) CalcSoftSizeByObjectId(hprof *HProf, objectId uint64
 	// object array
 	objectArrayDump := hprof.arrayObjectId2objectArrayDump[objectId]
 	if objectArrayDump != nil {
-		return len(objectArrayDump.ElementObjectIds) * 8, nil
+		return len(objectArrayDump.ElementObjectIds) * hprof.identifierSize, nil
 	}
 
 	// primitive array
 	primitiveArrayDump := hprof.arrayObjectId2primitiveArrayDump[objectId]
 	if primitiveArrayDump != nil {
-		return len(primitiveArrayDump.Values) * parser.ValueSize[primitiveArrayDump.ElementType], nil
+		// the values are the raw bytes of the elements.
+		return len(primitiveArrayDump.Values), nil
 	}
 
-	s.logger.Fatalf("SHOULD NOT REACH HERE: %v pa=%v oa=%v id=%v",
-		objectId,
-		hprof.arrayObjectId2primitiveArrayDump[objectId],
-		hprof.arrayObjectId2objectArrayDump[objectId],
-		hprof.objectId2instanceDump[objectId])
-	return -1, nil // should not reach here
+	// The object isn't in the heap dump. It doesn't take any space.
+	s.logger.Debug("Missing object: objectId=%v", objectId)
+	return 0, nil
 }
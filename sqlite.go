@@ -0,0 +1,587 @@
+package heapdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/hprof-parser/hprofdata"
+	"github.com/google/hprof-parser/parser"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// sqlitePageSize is the page size of the database written by sqliteWriter.
+const sqlitePageSize = 4096
+
+// sqliteTables are the tables written by WriteSQLite. The object IDs are the primary keys, and the sizes of the objects
+// unreachable from the GC roots are NULL.
+var sqliteTables = []struct {
+	name string
+	sql  string
+}{
+	{"classes", "CREATE TABLE classes(id INTEGER PRIMARY KEY, name TEXT, super_id INTEGER, loader_id INTEGER, instance_size INTEGER, " +
+		"instance_count INTEGER, shallow_size INTEGER, retained_size INTEGER, dominator_id INTEGER)"},
+	{"fields", "CREATE TABLE fields(class_id INTEGER, name TEXT, type TEXT, is_static INTEGER, value)"},
+	{"instances", "CREATE TABLE instances(id INTEGER PRIMARY KEY, class_id INTEGER, shallow_size INTEGER, retained_size INTEGER, " +
+		"dominator_id INTEGER)"},
+	{"arrays", "CREATE TABLE arrays(id INTEGER PRIMARY KEY, class_id INTEGER, element_type TEXT, length INTEGER, " +
+		"shallow_size INTEGER, retained_size INTEGER, dominator_id INTEGER)"},
+	{"refs", "CREATE TABLE refs(from_id INTEGER, to_id INTEGER, kind TEXT, name TEXT, array_index INTEGER)"},
+	{"strings", "CREATE TABLE strings(id INTEGER PRIMARY KEY, value TEXT)"},
+	{"roots", "CREATE TABLE roots(object_id INTEGER, kind TEXT)"},
+}
+
+// WriteSQLite writes the heap dump as the SQLite database, to analyze it by SQL. The tables are:
+//
+//	classes(id, name, super_id, loader_id, instance_size, instance_count, shallow_size, retained_size, dominator_id)
+//	fields(class_id, name, type, is_static, value) -- value is the value of the static field
+//	instances(id, class_id, shallow_size, retained_size, dominator_id)
+//	arrays(id, class_id, element_type, length, shallow_size, retained_size, dominator_id) -- class_id is NULL for the primitive arrays
+//	refs(from_id, to_id, kind, name, array_index) -- kind is field, static, element or super
+//	strings(id, value)
+//	roots(object_id, kind)
+//
+// The database doesn't have any indexes except the primary keys. Create them by CREATE INDEX if needed.
+func (a HeapDumpAnalyzer) WriteSQLite(w io.WriteSeeker, tree *DominatorTree) error {
+	s, err := newSQLiteWriter(w)
+	if err != nil {
+		return err
+	}
+	tables := make(map[string]*sqliteTable)
+	for _, table := range sqliteTables {
+		tables[table.name] = s.createTable(table.name, table.sql)
+	}
+	nullable := func(v uint64, ok bool) interface{} {
+		if !ok || v == 0 {
+			return nil
+		}
+		return int64(v)
+	}
+	sizes := func(objectId uint64) ([]interface{}, error) {
+		shallowSize, err := a.GetShallowSize(objectId)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{int64(shallowSize), nullable(tree.RetainedSize(objectId), tree.Contains(objectId)),
+			nullable(tree.ImmediateDominator(objectId))}, nil
+	}
+	writeReferences := func(objectId uint64) error {
+		return a.hprof.ForEachReference(objectId, func(ref *Reference) error {
+			var kind string
+			var name, index interface{}
+			switch ref.Kind {
+			case ReferenceKind_INSTANCE_FIELD:
+				kind, name = "field", a.hprof.GetFieldName(ref.NameId)
+			case ReferenceKind_STATIC_FIELD:
+				kind, name = "static", a.hprof.GetFieldName(ref.NameId)
+			case ReferenceKind_ARRAY_ELEMENT:
+				kind, index = "element", int64(ref.Index)
+			case ReferenceKind_SUPER_CLASS:
+				kind = "super"
+			}
+			return tables["refs"].insert(0, int64(objectId), int64(ref.ObjectId), kind, name, index)
+		})
+	}
+
+	var classObjectIds []uint64
+	err = a.hprof.ForEachClassDump(func(classDump *hprofdata.HProfClassDump) error {
+		classObjectIds = append(classObjectIds, classDump.ClassObjectId)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sortObjectIds(classObjectIds)
+	for _, classObjectId := range classObjectIds {
+		classDump, err := a.hprof.GetClassDumpByClassObjectId(classObjectId)
+		if err != nil {
+			return err
+		}
+		name, err := a.hprof.GetClassNameByClassObjectId(classObjectId)
+		if err != nil {
+			return err
+		}
+		values := []interface{}{nil, name, nullable(classDump.SuperClassObjectId, true), nullable(classDump.ClassLoaderObjectId, true),
+			int64(classDump.InstanceSize), int64(len(a.hprof.classObjectId2objectIds[classObjectId]))}
+		size, err := sizes(classObjectId)
+		if err != nil {
+			return err
+		}
+		if err := tables["classes"].insert(int64(classObjectId), append(values, size...)...); err != nil {
+			return err
+		}
+		for _, field := range a.hprof.GetStaticFields(classDump) {
+			value := fieldValue(field)
+			switch v := value.(type) {
+			case queryObject:
+				value = int64(v)
+			case bool:
+				value = int64(0)
+				if v {
+					value = int64(1)
+				}
+			}
+			err := tables["fields"].insert(0, int64(classObjectId), field.Name, valueTypeName(field.Type), int64(1), value)
+			if err != nil {
+				return err
+			}
+		}
+		for _, field := range classDump.InstanceFields {
+			err := tables["fields"].insert(0, int64(classObjectId), a.hprof.GetFieldName(field.NameId), valueTypeName(field.Type), int64(0), nil)
+			if err != nil {
+				return err
+			}
+		}
+		if err := writeReferences(classObjectId); err != nil {
+			return err
+		}
+	}
+
+	var objectIds []uint64
+	for objectId := range a.hprof.objectId2instanceDump {
+		objectIds = append(objectIds, objectId)
+	}
+	sortObjectIds(objectIds)
+	for _, objectId := range objectIds {
+		size, err := sizes(objectId)
+		if err != nil {
+			return err
+		}
+		values := append([]interface{}{nil, int64(a.hprof.objectId2instanceDump[objectId].ClassObjectId)}, size...)
+		if err := tables["instances"].insert(int64(objectId), values...); err != nil {
+			return err
+		}
+		if err := writeReferences(objectId); err != nil {
+			return err
+		}
+	}
+
+	objectIds = objectIds[:0]
+	for objectId := range a.hprof.arrayObjectId2objectArrayDump {
+		objectIds = append(objectIds, objectId)
+	}
+	for objectId := range a.hprof.arrayObjectId2primitiveArrayDump {
+		objectIds = append(objectIds, objectId)
+	}
+	sortObjectIds(objectIds)
+	for _, objectId := range objectIds {
+		var values []interface{}
+		if objectArrayDump, ok := a.hprof.arrayObjectId2objectArrayDump[objectId]; ok {
+			values = []interface{}{nil, int64(objectArrayDump.ArrayClassObjectId), valueTypeName(hprofdata.HProfValueType_OBJECT),
+				int64(len(objectArrayDump.ElementObjectIds))}
+		} else {
+			primitiveArrayDump := a.hprof.arrayObjectId2primitiveArrayDump[objectId]
+			values = []interface{}{nil, nil, valueTypeName(primitiveArrayDump.ElementType),
+				int64(len(primitiveArrayDump.Values) / parser.ValueSize[primitiveArrayDump.ElementType])}
+		}
+		size, err := sizes(objectId)
+		if err != nil {
+			return err
+		}
+		if err := tables["arrays"].insert(int64(objectId), append(values, size...)...); err != nil {
+			return err
+		}
+		if err := writeReferences(objectId); err != nil {
+			return err
+		}
+	}
+
+	objectIds = objectIds[:0]
+	stringClassObjectIds, err := a.FindClassObjectIdsByName(javaLangString)
+	if err != nil {
+		return err
+	}
+	for _, classObjectId := range stringClassObjectIds {
+		objectIds = append(objectIds, a.hprof.classObjectId2objectIds[classObjectId]...)
+	}
+	sortObjectIds(objectIds)
+	for _, objectId := range objectIds {
+		value, err := a.hprof.GetStringValue(objectId)
+		if err != nil {
+			return err
+		}
+		if err := tables["strings"].insert(int64(objectId), nil, value); err != nil {
+			return err
+		}
+	}
+
+	for _, objectId := range a.hprof.RootObjectIds() {
+		for _, kind := range a.hprof.GetRootKinds(objectId) {
+			if err := tables["roots"].insert(0, int64(objectId), kind); err != nil {
+				return err
+			}
+		}
+	}
+	return s.close()
+}
+
+func sortObjectIds(objectIds []uint64) {
+	sort.Slice(objectIds, func(i, j int) bool {
+		return objectIds[i] < objectIds[j]
+	})
+}
+
+// valueTypeName returns the name of the value type, e.g. "object", "int".
+func valueTypeName(valueType hprofdata.HProfValueType) string {
+	return strings.ToLower(valueType.String())
+}
+
+// sqliteWriter writes the SQLite database file of the tables without the indexes.
+// The rows are appended in the order of the rowid, and the pages are written as soon as they are filled.
+// See https://www.sqlite.org/fileformat2.html
+type sqliteWriter struct {
+	w io.WriteSeeker
+	// the number of the pages written, including the page 1.
+	pages  uint32
+	tables []*sqliteTable
+}
+
+// sqliteTable is the table b-tree being written.
+type sqliteTable struct {
+	s       *sqliteWriter
+	name    string
+	sql     string
+	lastRow int64
+	// the leaf page being filled
+	cells [][]byte
+	used  int
+	// the pages of the lowest level, and the max rowids of them.
+	children []sqliteChild
+}
+
+type sqliteChild struct {
+	page   uint32
+	maxRow int64
+}
+
+func newSQLiteWriter(w io.WriteSeeker) (*sqliteWriter, error) {
+	m := new(sqliteWriter)
+	m.w = w
+	// the page 1 is written at last, with the schema.
+	if _, err := w.Write(make([]byte, sqlitePageSize)); err != nil {
+		return nil, err
+	}
+	m.pages = 1
+	return m, nil
+}
+
+func (s *sqliteWriter) writePage(page []byte) (uint32, error) {
+	if _, err := s.w.Write(page); err != nil {
+		return 0, err
+	}
+	s.pages++
+	return s.pages, nil
+}
+
+// createTable adds the table. The sql is the CREATE TABLE statement of it.
+func (s *sqliteWriter) createTable(name string, sql string) *sqliteTable {
+	t := &sqliteTable{s: s, name: name, sql: sql}
+	s.tables = append(s.tables, t)
+	return t
+}
+
+// insert appends the row. The rowid must be larger than the previous one, or 0 to use the next rowid.
+// The values are nil, int64, float64, string or []byte.
+func (t *sqliteTable) insert(rowid int64, values ...interface{}) error {
+	if rowid == 0 {
+		rowid = t.lastRow + 1
+	}
+	if rowid <= t.lastRow {
+		return fmt.Errorf("rowid of %v must be ascending: %v", t.name, rowid)
+	}
+	t.lastRow = rowid
+	record, err := sqliteRecord(values)
+	if err != nil {
+		return fmt.Errorf("%v: %v", t.name, err)
+	}
+	cell, err := t.s.leafCell(rowid, record)
+	if err != nil {
+		return err
+	}
+	if t.used+2+len(cell) > sqlitePageSize-8 {
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+	t.cells = append(t.cells, cell)
+	t.used += 2 + len(cell)
+	return nil
+}
+
+// flush writes the leaf page.
+func (t *sqliteTable) flush() error {
+	page := make([]byte, sqlitePageSize)
+	writeSQLiteBTreePage(page, 0, 0x0d, t.cells, 0)
+	pageNumber, err := t.s.writePage(page)
+	if err != nil {
+		return err
+	}
+	t.children = append(t.children, sqliteChild{page: pageNumber, maxRow: t.lastRowOf(t.cells)})
+	t.cells = nil
+	t.used = 0
+	return nil
+}
+
+// lastRowOf returns the rowid of the last cell of the leaf page.
+func (t *sqliteTable) lastRowOf(cells [][]byte) int64 {
+	if len(cells) == 0 {
+		return t.lastRow
+	}
+	cell := cells[len(cells)-1]
+	_, n := readSQLiteVarint(cell)
+	rowid, _ := readSQLiteVarint(cell[n:])
+	return int64(rowid)
+}
+
+// finish writes the rest of the leaves and the interior pages, and returns the root page.
+func (t *sqliteTable) finish() (uint32, error) {
+	if len(t.cells) > 0 || len(t.children) == 0 {
+		if err := t.flush(); err != nil {
+			return 0, err
+		}
+	}
+	children := t.children
+	for len(children) > 1 {
+		var parents []sqliteChild
+		var cells [][]byte
+		used := 0
+		for i, child := range children {
+			last := i == len(children)-1
+			var cell []byte
+			if !last {
+				cell = make([]byte, 4)
+				binary.BigEndian.PutUint32(cell, child.page)
+				cell = appendSQLiteVarint(cell, uint64(child.maxRow))
+			}
+			// the child which doesn't fit is the right-most pointer of the page.
+			if last || used+2+len(cell) > sqlitePageSize-12 {
+				page := make([]byte, sqlitePageSize)
+				writeSQLiteBTreePage(page, 0, 0x05, cells, child.page)
+				pageNumber, err := t.s.writePage(page)
+				if err != nil {
+					return 0, err
+				}
+				parents = append(parents, sqliteChild{page: pageNumber, maxRow: child.maxRow})
+				cells = nil
+				used = 0
+				continue
+			}
+			cells = append(cells, cell)
+			used += 2 + len(cell)
+		}
+		children = parents
+	}
+	return children[0].page, nil
+}
+
+// close writes the tables and the schema on the page 1.
+func (s *sqliteWriter) close() error {
+	var schema [][]byte
+	for i, t := range s.tables {
+		root, err := t.finish()
+		if err != nil {
+			return err
+		}
+		record, err := sqliteRecord([]interface{}{"table", t.name, t.name, int64(root), t.sql})
+		if err != nil {
+			return err
+		}
+		cell, err := s.leafCell(int64(i+1), record)
+		if err != nil {
+			return err
+		}
+		schema = append(schema, cell)
+	}
+	page := make([]byte, sqlitePageSize)
+	used := 100 + 8
+	for _, cell := range schema {
+		used += 2 + len(cell)
+	}
+	if used > sqlitePageSize {
+		return fmt.Errorf("too many tables for the schema page")
+	}
+	writeSQLiteBTreePage(page, 100, 0x0d, schema, 0)
+
+	header := page[:100]
+	copy(header, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(header[16:], sqlitePageSize)
+	// file format write and read version: legacy
+	header[18] = 1
+	header[19] = 1
+	// max and min embedded payload fractions, and leaf payload fraction
+	header[21] = 64
+	header[22] = 32
+	header[23] = 32
+	// file change counter
+	binary.BigEndian.PutUint32(header[24:], 1)
+	binary.BigEndian.PutUint32(header[28:], s.pages)
+	// schema cookie
+	binary.BigEndian.PutUint32(header[40:], 1)
+	// schema format number
+	binary.BigEndian.PutUint32(header[44:], 4)
+	// text encoding: UTF-8
+	binary.BigEndian.PutUint32(header[56:], 1)
+	// version-valid-for number, and the version of SQLite which wrote the file
+	binary.BigEndian.PutUint32(header[92:], 1)
+	binary.BigEndian.PutUint32(header[96:], 3031001)
+
+	if _, err := s.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(page); err != nil {
+		return err
+	}
+	_, err := s.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// writeSQLiteBTreePage writes the b-tree page header at the offset, the cell pointers and the cells from the end of the page.
+// The rightMost is the right-most pointer of the interior pages.
+func writeSQLiteBTreePage(page []byte, offset int, pageType byte, cells [][]byte, rightMost uint32) {
+	page[offset] = pageType
+	headerSize := 8
+	if pageType == 0x05 {
+		headerSize = 12
+		binary.BigEndian.PutUint32(page[offset+8:], rightMost)
+	}
+	binary.BigEndian.PutUint16(page[offset+3:], uint16(len(cells)))
+	content := len(page)
+	for i, cell := range cells {
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(page[offset+headerSize+2*i:], uint16(content))
+	}
+	binary.BigEndian.PutUint16(page[offset+5:], uint16(content))
+}
+
+// leafCell returns the cell of the table leaf page. The payload exceeding the page is written in the overflow pages.
+func (s *sqliteWriter) leafCell(rowid int64, payload []byte) ([]byte, error) {
+	cell := appendSQLiteVarint(nil, uint64(len(payload)))
+	cell = appendSQLiteVarint(cell, uint64(rowid))
+
+	const usable = sqlitePageSize
+	maxLocal := usable - 35
+	if len(payload) <= maxLocal {
+		return append(cell, payload...), nil
+	}
+	minLocal := (usable-12)*32/255 - 23
+	local := minLocal + (len(payload)-minLocal)%(usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	cell = append(cell, payload[:local]...)
+
+	// the overflow pages are written in order, so the next one is the following page.
+	var chunks [][]byte
+	for rest := payload[local:]; len(rest) > 0; {
+		n := usable - 4
+		if n > len(rest) {
+			n = len(rest)
+		}
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+	first := s.pages + 1
+	for i, chunk := range chunks {
+		page := make([]byte, sqlitePageSize)
+		if i < len(chunks)-1 {
+			binary.BigEndian.PutUint32(page, first+uint32(i)+1)
+		}
+		copy(page[4:], chunk)
+		if _, err := s.writePage(page); err != nil {
+			return nil, err
+		}
+	}
+	var next [4]byte
+	binary.BigEndian.PutUint32(next[:], first)
+	return append(cell, next[:]...), nil
+}
+
+// sqliteRecord encodes the values in the record format.
+func sqliteRecord(values []interface{}) ([]byte, error) {
+	var header []byte
+	var body bytes.Buffer
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			header = appendSQLiteVarint(header, 0)
+		case int64:
+			if v == 0 || v == 1 {
+				// the integer constants of the schema format 4.
+				header = appendSQLiteVarint(header, uint64(8+v))
+				continue
+			}
+			serialType, size := uint64(6), 8
+			for _, t := range []struct {
+				serialType uint64
+				size       int
+			}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}} {
+				bits := uint(t.size * 8)
+				if v >= -(1<<(bits-1)) && v < 1<<(bits-1) {
+					serialType, size = t.serialType, t.size
+					break
+				}
+			}
+			header = appendSQLiteVarint(header, serialType)
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], uint64(v))
+			body.Write(buf[8-size:])
+		case float64:
+			header = appendSQLiteVarint(header, 7)
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+			body.Write(buf[:])
+		case string:
+			header = appendSQLiteVarint(header, uint64(13+2*len(v)))
+			body.WriteString(v)
+		case []byte:
+			header = appendSQLiteVarint(header, uint64(12+2*len(v)))
+			body.Write(v)
+		default:
+			return nil, fmt.Errorf("unsupported value: %#v", value)
+		}
+	}
+	// the size of the header includes the varint of itself.
+	headerSize := len(header) + 1
+	if len(appendSQLiteVarint(nil, uint64(headerSize))) > 1 {
+		headerSize++
+	}
+	record := appendSQLiteVarint(nil, uint64(headerSize))
+	record = append(record, header...)
+	return append(record, body.Bytes()...), nil
+}
+
+// appendSQLiteVarint appends the big endian varint of SQLite, whose 9th byte has 8 bits.
+func appendSQLiteVarint(b []byte, v uint64) []byte {
+	if v > 0x00ffffffffffffff {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+	}
+	return append(b, buf[i:]...)
+}
+
+func readSQLiteVarint(b []byte) (uint64, int) {
+	v := uint64(0)
+	for i := 0; i < 8; i++ {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v<<8 | uint64(b[8]), 9
+}
@@ -0,0 +1,187 @@
+package heapdump
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteVarint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 0x7f, 0x80, 0x3fff, 0x4000, 1 << 56, math.MaxUint64} {
+		b := appendSQLiteVarint(nil, v)
+		if decoded, n := readSQLiteVarint(b); decoded != v || n != len(b) {
+			t.Errorf("%x should be decoded from %x. But %x", v, b, decoded)
+		}
+	}
+}
+
+func TestSQLiteRecordUnsupported(t *testing.T) {
+	if _, err := sqliteRecord([]interface{}{int64(1), uint64(1)}); err == nil {
+		t.Errorf("uint64 should be unsupported")
+	}
+}
+
+// readSQLiteTable reads the rows of the table b-tree by the rowid, without the overflow pages.
+func readSQLiteTable(t *testing.T, db []byte, page uint32) map[int64][]interface{} {
+	rows := make(map[int64][]interface{})
+	data := db[int(page-1)*sqlitePageSize : int(page)*sqlitePageSize]
+	offset := 0
+	if page == 1 {
+		offset = 100
+	}
+	cells := int(binary.BigEndian.Uint16(data[offset+3:]))
+	switch data[offset] {
+	case 0x05:
+		for i := 0; i < cells; i++ {
+			cell := binary.BigEndian.Uint16(data[offset+12+2*i:])
+			for rowid, row := range readSQLiteTable(t, db, binary.BigEndian.Uint32(data[cell:])) {
+				rows[rowid] = row
+			}
+		}
+		for rowid, row := range readSQLiteTable(t, db, binary.BigEndian.Uint32(data[offset+8:])) {
+			rows[rowid] = row
+		}
+	case 0x0d:
+		for i := 0; i < cells; i++ {
+			cell := data[binary.BigEndian.Uint16(data[offset+8+2*i:]):]
+			_, n := readSQLiteVarint(cell)
+			rowid, m := readSQLiteVarint(cell[n:])
+			record := cell[n+m:]
+			headerSize, n := readSQLiteVarint(record)
+			header := record[n:headerSize]
+			body := record[headerSize:]
+			var row []interface{}
+			for len(header) > 0 {
+				serialType, n := readSQLiteVarint(header)
+				header = header[n:]
+				switch {
+				case serialType == 0:
+					row = append(row, nil)
+				case serialType == 8 || serialType == 9:
+					row = append(row, int64(serialType-8))
+				case serialType <= 6:
+					size := []int{0, 1, 2, 3, 4, 6, 8}[serialType]
+					v := int64(readValue(body, size)) << uint(64-size*8) >> uint(64-size*8)
+					row = append(row, v)
+					body = body[size:]
+				case serialType == 7:
+					row = append(row, math.Float64frombits(readValue(body, 8)))
+					body = body[8:]
+				default:
+					size := int(serialType-12) / 2
+					row = append(row, string(body[:size]))
+					body = body[size:]
+				}
+			}
+			rows[int64(rowid)] = row
+		}
+	default:
+		t.Fatalf("unknown page type of the page %v: %x", page, data[offset])
+	}
+	return rows
+}
+
+// writeTestSQLite writes the heap dump to the SQLite database in the directory.
+func writeTestSQLite(t *testing.T, tester *Tester, dir string) string {
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "heapdump.db")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tester.analyzer.WriteSQLite(f, tree); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWriteSQLite(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestSQLite(t, tester, dir)
+
+	db, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(db[:16]) != "SQLite format 3\x00" || int(binary.BigEndian.Uint32(db[28:]))*sqlitePageSize != len(db) {
+		t.Fatalf("invalid header: %q", db[:100])
+	}
+
+	tables := make(map[string]map[int64][]interface{})
+	for _, row := range readSQLiteTable(t, db, 1) {
+		tables[row[1].(string)] = readSQLiteTable(t, db, uint32(row[3].(int64)))
+	}
+	if len(tables) != len(sqliteTables) {
+		t.Fatalf("%v tables should be written. But %v", len(sqliteTables), len(tables))
+	}
+
+	object1 := tester.FindInstance("Object1")
+	object2 := tester.FindInstance("Object2")
+	row := tables["instances"][int64(object1)]
+	if len(row) != 5 || row[3] != int64(66) {
+		t.Errorf("Object1 should retain 66 bytes. But %v", row)
+	}
+	if row := tables["instances"][int64(object2)]; len(row) != 5 || row[4] != int64(object1) {
+		t.Errorf("Object2 should be dominated by Object1. But %v", row)
+	}
+	if class := tables["classes"][row[1].(int64)]; len(class) != 9 || class[1] != "Object1" {
+		t.Errorf("The class of Object1 should be written. But %v", class)
+	}
+	found := false
+	for _, ref := range tables["refs"] {
+		if ref[0] == int64(object1) && ref[1] == int64(object2) && ref[2] == "field" && ref[3] == "o2" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("The reference from Object1 to Object2 should be written.")
+	}
+}
+
+// TestWriteSQLiteBySQLite3 reads the database by the sqlite3 command, to check it's readable by SQLite itself.
+func TestWriteSQLiteBySQLite3(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestSQLite(t, tester, dir)
+
+	for query, expected := range map[string]string{
+		"PRAGMA integrity_check": "ok",
+		"SELECT c.name, i.retained_size FROM instances i JOIN classes c ON c.id = i.class_id WHERE c.name LIKE 'Object_' ORDER BY 1": "Object1|66\nObject2|42",
+		"SELECT count(*) FROM refs WHERE from_id = " + strconv.FormatUint(tester.FindInstance("Object1"), 10):                        "1",
+	} {
+		out, err := exec.Command(sqlite3, "-readonly", path, query).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %v: %s", query, err, out)
+		}
+		if result := strings.TrimSpace(string(out)); result != expected {
+			t.Errorf("%v should be %q. But %q", query, expected, result)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package heapdump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// HeapStackTree selects the tree to walk by ForEachHeapStack.
+type HeapStackTree int
+
+const (
+	// HeapStackTree_DOMINATOR walks the dominator tree. The cumulative shallow size of a frame is the retained size.
+	HeapStackTree_DOMINATOR HeapStackTree = iota
+	// HeapStackTree_PATH walks the shortest paths from the GC roots.
+	HeapStackTree_PATH
+)
+
+// TruncatedFrame replaces the frames deeper than the max depth.
+const TruncatedFrame = "..."
+
+// ForEachHeapStack calls fn for each reachable object, with the frames from the top of the tree down to the object.
+// Each frame is the class name of the object, prefixed by the name of the reference from the parent if the parent refers
+// it directly, e.g. "table -> [Ljava/util/HashMap$Node;". The array indexes are folded into "[]".
+// If maxDepth is positive, the frames deeper than maxDepth are replaced by TruncatedFrame and the frame of the object.
+// The frames are reused for the next call.
+func (a HeapDumpAnalyzer) ForEachHeapStack(tree *DominatorTree, kind HeapStackTree, maxDepth int, fn func(frames []string, objectId uint64) error) error {
+	roots := tree.Children(0)
+	children := func(objectId uint64) []uint64 {
+		return tree.Children(objectId)
+	}
+	if kind == HeapStackTree_PATH {
+		pathTree, err := a.buildShortestPathTree(tree)
+		if err != nil {
+			return err
+		}
+		roots = pathTree[0]
+		children = func(objectId uint64) []uint64 {
+			return pathTree[objectId]
+		}
+	}
+
+	type frame struct {
+		objectId  uint64
+		depth     int
+		reference string
+	}
+	var stack []*frame
+	for i := len(roots) - 1; i >= 0; i-- {
+		stack = append(stack, &frame{objectId: roots[i]})
+	}
+	var path []string
+	var frames []string
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		className, err := a.hprof.GetObjectClassName(f.objectId)
+		if err != nil {
+			return err
+		}
+		name := className
+		if f.reference != "" {
+			name = f.reference + " -> " + className
+		}
+		path = append(path[:f.depth], name)
+		frames = path
+		if maxDepth > 0 && len(path) > maxDepth {
+			frames = append(append(frames[:maxDepth:maxDepth], TruncatedFrame), name)
+		}
+		if err := fn(frames, f.objectId); err != nil {
+			return err
+		}
+
+		objectIds := children(f.objectId)
+		if len(objectIds) == 0 {
+			continue
+		}
+		references, err := a.referenceNamesTo(f.objectId, objectIds)
+		if err != nil {
+			return err
+		}
+		for i := len(objectIds) - 1; i >= 0; i-- {
+			stack = append(stack, &frame{objectId: objectIds[i], depth: f.depth + 1, reference: references[objectIds[i]]})
+		}
+	}
+	return nil
+}
+
+// referenceNamesTo returns the names of the first references from the object to the children.
+// The children which aren't referred directly, e.g. dominated through the other objects, aren't included.
+func (a HeapDumpAnalyzer) referenceNamesTo(objectId uint64, children []uint64) (map[uint64]string, error) {
+	names := make(map[uint64]string, len(children))
+	targets := make(map[uint64]bool, len(children))
+	for _, child := range children {
+		targets[child] = true
+	}
+	err := a.hprof.ForEachReference(objectId, func(ref *Reference) error {
+		if _, ok := names[ref.ObjectId]; ok || !targets[ref.ObjectId] {
+			return nil
+		}
+		name := a.hprof.GetReferenceName(ref)
+		if strings.HasPrefix(name, "[") {
+			name = "[]"
+		}
+		names[ref.ObjectId] = name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// buildShortestPathTree builds the tree of the shortest paths from the GC roots by the breadth first search.
+// It returns the children of each object, and the GC roots as the children of 0.
+func (a HeapDumpAnalyzer) buildShortestPathTree(tree *DominatorTree) (map[uint64][]uint64, error) {
+	children := make(map[uint64][]uint64)
+	seen := make(map[uint64]bool)
+	var queue []uint64
+	for _, rootObjectId := range a.hprof.RootObjectIds() {
+		if tree.Contains(rootObjectId) {
+			seen[rootObjectId] = true
+			children[0] = append(children[0], rootObjectId)
+			queue = append(queue, rootObjectId)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		err := a.hprof.ForEachReference(current, func(ref *Reference) error {
+			if seen[ref.ObjectId] || !tree.Contains(ref.ObjectId) || ref.Strength > tree.strength {
+				return nil
+			}
+			seen[ref.ObjectId] = true
+			children[current] = append(children[current], ref.ObjectId)
+			queue = append(queue, ref.ObjectId)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return children, nil
+}
+
+// WriteFoldedStacks writes the reachable objects in the collapsed stack format of flamegraph.pl, e.g.
+// "class Foo;static cache -> java/util/HashMap;table -> [Ljava/util/HashMap$Node; 4096".
+// Each line is the frames from ForEachHeapStack joined by ";" and the total shallow size of the objects with the same stack,
+// so the width of a frame in the flame graph is its retained size on the dominator tree.
+func (a HeapDumpAnalyzer) WriteFoldedStacks(w io.Writer, tree *DominatorTree, kind HeapStackTree, maxDepth int) error {
+	sizes := make(map[string]uint64)
+	var stacks []string
+	err := a.ForEachHeapStack(tree, kind, maxDepth, func(frames []string, objectId uint64) error {
+		stack := strings.Join(frames, ";")
+		if _, ok := sizes[stack]; !ok {
+			stacks = append(stacks, stack)
+		}
+		sizes[stack] += tree.ShallowSize(objectId)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", stack, sizes[stack]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,103 @@
+package heapdump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testHeapStack(t *testing.T, kind HeapStackTree, maxDepth int, targetClass string) []string {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectId := tester.FindInstance(targetClass)
+	var result []string
+	err = tester.analyzer.ForEachHeapStack(tree, kind, maxDepth, func(frames []string, id uint64) error {
+		if id == objectId {
+			result = append([]string{}, frames...)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestForEachHeapStack(t *testing.T) {
+	expected := []string{"class TestData", "static o1 -> Object1", "o2 -> Object2"}
+	if frames := testHeapStack(t, HeapStackTree_DOMINATOR, 0, "Object2"); !reflect.DeepEqual(frames, expected) {
+		t.Errorf("Object2 should be dominated by Object1. But %v", frames)
+	}
+	expected = []string{"class TestData", TruncatedFrame, "o2 -> Object2"}
+	if frames := testHeapStack(t, HeapStackTree_DOMINATOR, 1, "Object2"); !reflect.DeepEqual(frames, expected) {
+		t.Errorf("The frames deeper than 1 should be truncated. But %v", frames)
+	}
+	frames := testHeapStack(t, HeapStackTree_PATH, 0, "Object2")
+	if len(frames) < 2 || frames[len(frames)-1] != "o2 -> Object2" || frames[len(frames)-2] != "static o1 -> Object1" {
+		t.Errorf("Object2 should be referred from Object1. But %v", frames)
+	}
+}
+
+func TestWriteProfile(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := tester.analyzer.WriteProfile(&b, tree, HeapStackTree_DOMINATOR, 64); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"retained", "bytes", "o2 -> Object2"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("The string table should have %q", s)
+		}
+	}
+}
+
+func TestWriteFoldedStacks(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := tester.analyzer.WriteFoldedStacks(&b, tree, HeapStackTree_DOMINATOR, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b.Bytes(), []byte("\nclass TestData;static o1 -> Object1;o2 -> Object2 24\n")) {
+		t.Errorf("Object2 should be folded under Object1")
+	}
+	total := uint64(0)
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		size, err := strconv.ParseUint(line[strings.LastIndex(line, " ")+1:], 10, 64)
+		if err != nil {
+			t.Fatalf("%q should end with the size: %v", line, err)
+		}
+		total += size
+	}
+	if total != tree.RetainedSize(0) {
+		t.Errorf("The total should be the size of the reachable objects %v. But %v", tree.RetainedSize(0), total)
+	}
+}
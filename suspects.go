@@ -0,0 +1,255 @@
+package heapdump
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// accumulationRatio is the ratio of the retained size to follow the child in the dominator tree to find the accumulation point.
+const accumulationRatio = 0.8
+
+// LeakSuspect is a single object, or a group of the instances of the same class, which retains the big part of the heap.
+type LeakSuspect struct {
+	ClassName string
+	// ObjectIds are the suspected objects ordered by the retained size. It has an element for the single object.
+	ObjectIds    []uint64
+	RetainedSize uint64
+	Percentage   float64
+	// AccumulationPoint is the object where the memory is accumulated, e.g. the array of the collection.
+	// It's found by following the biggest child in the dominator tree from the (biggest) suspected object,
+	// while the child retains the most of its parent.
+	AccumulationPoint *DominatorEntry
+	// Path is the shortest path from the GC roots to the accumulation point.
+	Path []*PathElement
+	// DominatedClasses is the breakdown of the objects retained by the suspect, ordered by the retained size.
+	DominatedClasses []*DominatedClass
+}
+
+// IsGroup returns true if the suspect is a group of the instances.
+func (s *LeakSuspect) IsGroup() bool {
+	return len(s.ObjectIds) > 1
+}
+
+// DominatedClass is the objects of a class retained by the suspect.
+type DominatedClass struct {
+	ClassName   string
+	Count       int
+	ShallowSize uint64
+	// RetainedSize is of the outermost objects of the class. See DominatorTree.WalkDominated.
+	RetainedSize uint64
+}
+
+// LeakSuspectsReport is the result of FindLeakSuspects.
+type LeakSuspectsReport struct {
+	GeneratedAt  time.Time
+	TotalSize    uint64
+	ThresholdPct float64
+	Suspects     []*LeakSuspect
+}
+
+// FindLeakSuspects finds the objects directly dominated by the GC roots which retain more than thresholdPct percent of the
+// reachable heap, and the classes whose such objects retain more than thresholdPct percent together.
+// DominatedClasses of each suspect has `limit` classes at most.
+func (a HeapDumpAnalyzer) FindLeakSuspects(tree *DominatorTree, thresholdPct float64, limit int) (*LeakSuspectsReport, error) {
+	total := tree.RetainedSize(0)
+	report := &LeakSuspectsReport{
+		GeneratedAt:  time.Now(),
+		TotalSize:    total,
+		ThresholdPct: thresholdPct,
+	}
+	threshold := uint64(float64(total) * thresholdPct / 100)
+
+	var suspects []*LeakSuspect
+	groups := make(map[string]*LeakSuspect)
+	var groupNames []string
+	for _, objectId := range tree.Children(0) {
+		name, err := a.hprof.GetObjectClassName(objectId)
+		if err != nil {
+			return nil, err
+		}
+		size := tree.RetainedSize(objectId)
+		if size >= threshold {
+			suspects = append(suspects, &LeakSuspect{ClassName: name, ObjectIds: []uint64{objectId}, RetainedSize: size})
+			continue
+		}
+		group, ok := groups[name]
+		if !ok {
+			group = &LeakSuspect{ClassName: name}
+			groups[name] = group
+			groupNames = append(groupNames, name)
+		}
+		group.ObjectIds = append(group.ObjectIds, objectId)
+		group.RetainedSize += size
+	}
+	for _, name := range groupNames {
+		if group := groups[name]; group.IsGroup() && group.RetainedSize >= threshold {
+			suspects = append(suspects, group)
+		}
+	}
+	sort.SliceStable(suspects, func(i, j int) bool {
+		return suspects[i].RetainedSize > suspects[j].RetainedSize
+	})
+
+	for _, suspect := range suspects {
+		suspect.Percentage = Percentage(suspect.RetainedSize, total)
+
+		// the children of the virtual root are ordered by the retained size, so the first one is the biggest.
+		point := suspect.ObjectIds[0]
+		for {
+			children := tree.Children(point)
+			if len(children) == 0 || float64(tree.RetainedSize(children[0])) < float64(tree.RetainedSize(point))*accumulationRatio {
+				break
+			}
+			point = children[0]
+		}
+		name, err := a.hprof.GetObjectClassName(point)
+		if err != nil {
+			return nil, err
+		}
+		suspect.AccumulationPoint = &DominatorEntry{
+			ObjectId:     point,
+			ClassName:    name,
+			ShallowSize:  tree.ShallowSize(point),
+			RetainedSize: tree.RetainedSize(point),
+		}
+		if suspect.Path, err = a.FindPathFromRoot(point); err != nil {
+			return nil, err
+		}
+		if suspect.DominatedClasses, err = a.dominatedClasses(tree, suspect.ObjectIds, limit); err != nil {
+			return nil, err
+		}
+	}
+	report.Suspects = suspects
+	return report, nil
+}
+
+// dominatedClasses returns the breakdown by class of the objects dominated by the objects, including themselves.
+func (a HeapDumpAnalyzer) dominatedClasses(tree *DominatorTree, objectIds []uint64, limit int) ([]*DominatedClass, error) {
+	classes := make(map[string]*DominatedClass)
+	err := tree.WalkDominated(objectIds, func(objectId uint64) ([]interface{}, error) {
+		name, err := a.hprof.GetObjectClassName(objectId)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{name}, nil
+	}, func(objectId uint64, key interface{}, outermost bool) {
+		name := key.(string)
+		class, ok := classes[name]
+		if !ok {
+			class = &DominatedClass{ClassName: name}
+			classes[name] = class
+		}
+		class.Count++
+		class.ShallowSize += tree.ShallowSize(objectId)
+		if outermost {
+			class.RetainedSize += tree.RetainedSize(objectId)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*DominatedClass
+	for _, class := range classes {
+		result = append(result, class)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].RetainedSize != result[j].RetainedSize {
+			return result[i].RetainedSize > result[j].RetainedSize
+		}
+		return result[i].ClassName < result[j].ClassName
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// Description returns the one line summary of the suspect.
+func (s *LeakSuspect) Description() string {
+	if s.IsGroup() {
+		return fmt.Sprintf("%d instances of %s retain %d bytes (%.2f%%).",
+			len(s.ObjectIds), s.ClassName, s.RetainedSize, s.Percentage)
+	}
+	return fmt.Sprintf("One instance of %s (%d) retains %d bytes (%.2f%%).",
+		s.ClassName, s.ObjectIds[0], s.RetainedSize, s.Percentage)
+}
+
+// WriteText writes the report as the plain text.
+func (r *LeakSuspectsReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Leak suspects: objects retaining %.2f%% or more of %d bytes.\n", r.ThresholdPct, r.TotalSize)
+	if len(r.Suspects) == 0 {
+		b.WriteString("\nNo suspect found.\n")
+	}
+	for i, suspect := range r.Suspects {
+		fmt.Fprintf(&b, "\nProblem Suspect %d\n\n%s\n", i+1, suspect.Description())
+		point := suspect.AccumulationPoint
+		fmt.Fprintf(&b, "The memory is accumulated in %s (%d), retaining %d bytes.\n",
+			point.ClassName, point.ObjectId, point.RetainedSize)
+
+		b.WriteString("\nShortest path from the GC roots to the accumulation point:\n")
+		WritePath(&b, suspect.Path, "  ")
+
+		b.WriteString("\nRetained objects by class:\n")
+		tw := tabwriter.NewWriter(&b, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "  count\tshallowSize\tretainedSize\t  class\n")
+		for _, class := range suspect.DominatedClasses {
+			fmt.Fprintf(tw, "  %d\t%d\t%d\t  %s\n", class.Count, class.ShallowSize, class.RetainedSize, class.ClassName)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var suspectsTemplate = template.Must(template.New("suspects").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Leak suspects</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; }
+td.num { text-align: right; font-family: monospace; }
+.path { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Leak suspects</h1>
+<p>Generated at {{.GeneratedAt.Format "2006-01-02 15:04:05"}}.
+Objects retaining {{printf "%.2f" .ThresholdPct}}% or more of {{.TotalSize}} bytes are reported.</p>
+{{range $i, $suspect := .Suspects}}
+<h2>Problem Suspect {{$i | inc}}</h2>
+<p>{{.Description}}</p>
+<p>The memory is accumulated in {{.AccumulationPoint.ClassName}} ({{.AccumulationPoint.ObjectId}}), retaining {{.AccumulationPoint.RetainedSize}} bytes.</p>
+<h3>Shortest path from the GC roots to the accumulation point</h3>
+<ol class="path">
+{{range .Path}}<li>{{with .Reference}}{{.}} &rarr; {{end}}{{.ClassName}} ({{.ObjectId}}){{with .RootKinds}} [GC root: {{range $j, $kind := .}}{{if $j}}, {{end}}{{$kind}}{{end}}]{{end}}</li>
+{{end}}</ol>
+<h3>Retained objects by class</h3>
+<table>
+<tr><th>Class</th><th>Count</th><th>Shallow size</th><th>Retained size</th></tr>
+{{range .DominatedClasses}}<tr><td>{{.ClassName}}</td><td class="num">{{.Count}}</td><td class="num">{{.ShallowSize}}</td><td class="num">{{.RetainedSize}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No suspect found.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a standalone HTML page.
+func (r *LeakSuspectsReport) WriteHTML(w io.Writer) error {
+	return suspectsTemplate.Execute(w, r)
+}
@@ -0,0 +1,81 @@
+package heapdump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFindLeakSuspects(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := tester.analyzer.FindLeakSuspects(tree, 5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Suspects) == 0 {
+		t.Fatal("Suspects should be found")
+	}
+	for i, suspect := range report.Suspects {
+		if suspect.Percentage < 5 {
+			t.Errorf("%v retains %v%%, less than the threshold", suspect.ClassName, suspect.Percentage)
+		}
+		if i > 0 && report.Suspects[i-1].RetainedSize < suspect.RetainedSize {
+			t.Errorf("Suspects should be ordered by the retained size")
+		}
+		path := suspect.Path
+		if len(path) == 0 || path[len(path)-1].ObjectId != suspect.AccumulationPoint.ObjectId {
+			t.Errorf("The path of %v should end at the accumulation point", suspect.ClassName)
+		}
+		if len(suspect.DominatedClasses) == 0 || len(suspect.DominatedClasses) > 10 {
+			t.Errorf("%v should have 1 to 10 dominated classes. But %v", suspect.ClassName, len(suspect.DominatedClasses))
+		}
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), report.Suspects[0].Description()) {
+		t.Errorf("The text report should describe the suspect. But %v", text.String())
+	}
+	var html bytes.Buffer
+	if err := report.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), "<h2>Problem Suspect 1</h2>") {
+		t.Errorf("The HTML report should have the suspect. But %v", html.String())
+	}
+}
+
+func TestDominatedClasses(t *testing.T) {
+	tester := NewTester("testdata/object/heapdump.hprof", t)
+	defer tester.Close()
+
+	tree, err := tester.analyzer.BuildDominatorTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	classes, err := tester.analyzer.dominatedClasses(tree, []uint64{tester.FindInstance("Object1")}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DominatedClass{
+		{ClassName: "Object1", Count: 1, ShallowSize: 24, RetainedSize: 66},
+		{ClassName: "Object2", Count: 1, ShallowSize: 24, RetainedSize: 42},
+		{ClassName: "java/lang/Short", Count: 1, ShallowSize: 18, RetainedSize: 18},
+	}
+	if len(classes) != len(expected) {
+		t.Fatalf("Object1 should dominate %v classes. But %v", len(expected), len(classes))
+	}
+	for i, class := range classes {
+		if *class != expected[i] {
+			t.Errorf("%v should be %v. But %v", i, expected[i], *class)
+		}
+	}
+}
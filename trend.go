@@ -0,0 +1,142 @@
+package heapdump
+
+import (
+	"sort"
+	"strings"
+)
+
+// TrendPoint is the size of a class in a heap dump of the time series.
+type TrendPoint struct {
+	Count int
+	Size  uint64
+}
+
+// TrendSample is a heap dump of the time series, as the points of the classes by the class name.
+type TrendSample map[string]TrendPoint
+
+// ClassTrend is the time series of a class across the heap dumps.
+type ClassTrend struct {
+	Name   string
+	Counts []int
+	Sizes  []uint64
+	// GrowthRate is the slope of the least squares line of the sizes, in bytes per sample.
+	GrowthRate float64
+	// MonotonicScore is the ratio of the growing steps to all the steps between the samples, from 0 to 1.
+	MonotonicScore float64
+	// LeakCandidate is true if the class grows steadily, i.e. MonotonicScore is the min score or more.
+	LeakCandidate bool
+}
+
+// HistogramTrendSample returns the sample of the shallow sizes of the classes.
+// Classes with the same name loaded by the different class loaders are merged.
+func HistogramTrendSample(histogram []*HistogramEntry) TrendSample {
+	sample := make(TrendSample)
+	for _, entry := range histogram {
+		point := sample[entry.Name]
+		point.Count += entry.Count
+		point.Size += entry.ShallowSize
+		sample[entry.Name] = point
+	}
+	return sample
+}
+
+// RetainedTrendSample returns the sample of the retained sizes of the classes, by RetainedSizeByGroup.
+func (a HeapDumpAnalyzer) RetainedTrendSample(tree *DominatorTree) (TrendSample, error) {
+	groups, err := a.RetainedSizeByGroup(tree, func(className string) string {
+		return className
+	})
+	if err != nil {
+		return nil, err
+	}
+	sample := make(TrendSample)
+	for _, group := range groups {
+		sample[group.Name] = TrendPoint{Count: group.InstanceCount, Size: group.RetainedSize}
+	}
+	return sample, nil
+}
+
+// Trends returns the time series of the classes in the samples ordered by the time, ordered by the growth rate descending.
+// The class is a leak candidate if it grows at minScore of the steps or more, e.g. 1 for every step.
+// There are no candidates if less than 3 samples are given, because two samples can't tell the steady growth.
+func Trends(samples []TrendSample, minScore float64) []*ClassTrend {
+	trends := make(map[string]*ClassTrend)
+	for i, sample := range samples {
+		for name, point := range sample {
+			trend, ok := trends[name]
+			if !ok {
+				trend = &ClassTrend{Name: name, Counts: make([]int, len(samples)), Sizes: make([]uint64, len(samples))}
+				trends[name] = trend
+			}
+			trend.Counts[i] = point.Count
+			trend.Sizes[i] = point.Size
+		}
+	}
+
+	var result []*ClassTrend
+	for _, trend := range trends {
+		trend.GrowthRate = growthRate(trend.Sizes)
+		growing := 0
+		for i := 1; i < len(trend.Sizes); i++ {
+			if trend.Sizes[i] > trend.Sizes[i-1] {
+				growing++
+			}
+		}
+		if len(trend.Sizes) > 1 {
+			trend.MonotonicScore = float64(growing) / float64(len(trend.Sizes)-1)
+		}
+		trend.LeakCandidate = len(samples) >= 3 && trend.GrowthRate > 0 && trend.MonotonicScore >= minScore
+		result = append(result, trend)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].GrowthRate != result[j].GrowthRate {
+			return result[i].GrowthRate > result[j].GrowthRate
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// growthRate returns the slope of the least squares line of the values by the index.
+func growthRate(values []uint64) float64 {
+	n := float64(len(values))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, v := range values {
+		x := float64(i)
+		y := float64(v)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+}
+
+var sparklineBars = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline returns the values as the bars between the min and the max, e.g. "▁▂▄█".
+func Sparkline(values []uint64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if max > min {
+			i = int((v - min) * uint64(len(sparklineBars)-1) / (max - min))
+		}
+		b.WriteRune(sparklineBars[i])
+	}
+	return b.String()
+}
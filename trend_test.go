@@ -0,0 +1,79 @@
+package heapdump
+
+import (
+	"github.com/google/hprof-parser/parser"
+	"testing"
+)
+
+func TestTrends(t *testing.T) {
+	samples := []TrendSample{
+		{"Leak": {1, 100}, "Stable": {5, 500}, "Spike": {1, 100}},
+		{"Leak": {2, 200}, "Stable": {5, 500}, "Spike": {9, 900}},
+		{"Leak": {3, 300}, "Stable": {5, 500}, "Spike": {1, 100}},
+		{"Leak": {4, 400}, "Stable": {5, 500}, "Spike": {2, 200}, "New": {1, 50}},
+	}
+	trends := Trends(samples, 1)
+	if len(trends) != 4 || trends[0].Name != "Leak" {
+		t.Fatalf("Leak should grow fastest. But %v", trends)
+	}
+	for _, trend := range trends {
+		switch trend.Name {
+		case "Leak":
+			if trend.GrowthRate != 100 || trend.MonotonicScore != 1 || !trend.LeakCandidate {
+				t.Errorf("Leak should grow 100 bytes in every step. But %+v", trend)
+			}
+		case "Stable":
+			if trend.GrowthRate != 0 || trend.MonotonicScore != 0 || trend.LeakCandidate {
+				t.Errorf("Stable shouldn't grow. But %+v", trend)
+			}
+		case "Spike":
+			if trend.LeakCandidate {
+				t.Errorf("Spike shouldn't be the leak candidate. But %+v", trend)
+			}
+		case "New":
+			if trend.Sizes[0] != 0 || trend.Sizes[3] != 50 || trend.Counts[3] != 1 {
+				t.Errorf("New should be 0 before it appears. But %+v", trend)
+			}
+		}
+	}
+
+	if trends := Trends(samples[:2], 0); trends[0].LeakCandidate {
+		t.Errorf("Two samples shouldn't tell the leak. But %+v", trends[0])
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if s := Sparkline([]uint64{0, 1, 2, 3, 4, 5, 6, 7}); s != "▁▂▃▄▅▆▇█" {
+		t.Errorf("unexpected sparkline: %v", s)
+	}
+	if s := Sparkline([]uint64{3, 3}); s != "▁▁" {
+		t.Errorf("unexpected sparkline: %v", s)
+	}
+}
+
+func TestHistogramTrendSample(t *testing.T) {
+	var samples []TrendSample
+	for i := 1; i <= 3; i++ {
+		b := NewHProfBuilder(8)
+		node := nodeClass(b)
+		for j := 0; j < i; j++ {
+			b.AddRoot(parser.HProfHDRecordTypeRootJNIGlobal, b.AddInstance(node))
+		}
+		tester := builderTestData(t, b)
+		histogram, err := tester.analyzer.Histogram()
+		tester.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, HistogramTrendSample(histogram))
+	}
+	for _, trend := range Trends(samples, 1) {
+		if trend.Name == "Node" {
+			if trend.Sizes[2] != 28*3 || !trend.LeakCandidate {
+				t.Errorf("Node should be the leak candidate. But %+v", trend)
+			}
+			return
+		}
+	}
+	t.Errorf("Node should be found")
+}
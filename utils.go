@@ -1,4 +1,4 @@
-package main
+package heapdump
 
 func keys(d map[uint64]bool) []uint64 {
 	var keys []uint64